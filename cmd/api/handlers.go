@@ -8,9 +8,11 @@ package main
 import (
 	"coldbrew/tools"
 	"coldbrew/tools/logging"
+	"coldbrew/tools/tracing"
 	
 	"github.com/google/uuid"
 	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
 	
 	"context"
 	"net/http"
@@ -29,11 +31,14 @@ var (
  //----- HELPERS -----------------------------------------------------------------------------------------------------------//
 //-------------------------------------------------------------------------------------------------------------------------//
 
-func handlerCtx () (context.Context, context.CancelFunc) {
-	ctx, cancel := handlerTimeout.Context("api")
+// the context for a request, it carries the request's span so anything we call shows up in the same trace
+func handlerCtx (c *fiber.Ctx) (context.Context, context.CancelFunc) {
+	ctx, cancel := handlerTimeout.ContextFrom(c.UserContext(), "api")
 
-	u := uuid.New()
-	ctx = context.WithValue (ctx, logging.ThreadId, u.String())
+	threadId := tracing.TraceId (ctx)
+	if len(threadId) == 0 { threadId = uuid.New().String() } // tracing is off
+
+	ctx = context.WithValue (ctx, logging.ThreadId, threadId)
 
 	return ctx, cancel
 }
@@ -53,7 +58,7 @@ func getVersion (c *fiber.Ctx) int {
 
 // for when we make actions makes calls to us
 func (this *app) bearer (c *fiber.Ctx) error {
-	ctx, cancel := handlerCtx()
+	ctx, cancel := handlerCtx(c)
 	defer cancel()
 
 	auth := c.Get ("Authorization")
@@ -85,3 +90,16 @@ func (this *app) bearer (c *fiber.Ctx) error {
   //-------------------------------------------------------------------------------------------------------------------------//
  //----- PATH PARAMS -------------------------------------------------------------------------------------------------------//
 //-------------------------------------------------------------------------------------------------------------------------//
+
+// pulls a uuid out of the path, returns an error for the user if it's not one
+func pathUUID (c *fiber.Ctx, param string) (*uuid.UUID, error) {
+	var id tools.String
+	id.Set (c.Params(param))
+
+	ret := id.UUID()
+	if ret == nil {
+		return nil, errors.Wrapf (logging.ErrReturnToUser, "%s appears invalid", param)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,44 @@
+/** ****************************************************************************************************************** **
+	Admin endpoints for the emails the QB gave up on
+** ****************************************************************************************************************** **/
+
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+  //-------------------------------------------------------------------------------------------------------------------------//
+ //----- EMAILS ------------------------------------------------------------------------------------------------------------//
+//-------------------------------------------------------------------------------------------------------------------------//
+
+func (this *app) emailDeadList (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	resp, err := this.api.EmailsDead (ctx)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+// requeues every dead email
+func (this *app) emailDeadRequeuePut (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	resp, err := this.api.EmailsRequeueDead (ctx)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) emailRequeuePut (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	emailId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	err = this.api.EmailRequeue (ctx, emailId)
+
+	return this.Respond (ctx, err, c, nil)
+}
@@ -0,0 +1,140 @@
+/** ****************************************************************************************************************** **
+	Admin endpoints for managing the mailmen we send from
+** ****************************************************************************************************************** **/
+
+package main
+
+import (
+	"coldbrew/db/postgres"
+	"coldbrew/tools/logging"
+
+	"github.com/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type mailmanPutRequest struct {
+	postgres.MailmanAttr
+	Paused bool
+}
+
+// validates the data is ok to create
+func (this *mailmanPutRequest) ValidInput () error {
+	return this.MailmanAttr.Valid()
+}
+
+type mailmanPatchRequest struct {
+	postgres.MailmanAttr
+}
+
+// validates the data is ok to update, anything left off stays the same
+func (this *mailmanPatchRequest) ValidInput () error {
+	if this.FromEmail.Valid() && this.FromEmail.Email() == false {
+		return errors.Wrap (logging.ErrReturnToUser, "FromEmail appears invalid")
+	}
+
+	if this.ReplyEmail.Valid() && this.ReplyEmail.Email() == false {
+		return errors.Wrap (logging.ErrReturnToUser, "ReplyEmail appears invalid")
+	}
+
+	return nil // we're good
+}
+
+type mailmanPauseRequest struct {
+	Paused bool
+}
+
+// validates the data is ok to update
+func (this *mailmanPauseRequest) ValidInput () error {
+	return nil // we're good
+}
+
+  //-------------------------------------------------------------------------------------------------------------------------//
+ //----- MAILMEN -----------------------------------------------------------------------------------------------------------//
+//-------------------------------------------------------------------------------------------------------------------------//
+
+// creates a new mailman
+func (this *app) mailmanPut (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	data := &mailmanPutRequest{}
+	if this.ValidateInput (ctx, c, data) == false {
+		return nil
+	}
+
+	resp, err := this.api.MailmanCreate (ctx, data.MailmanAttr, data.Paused)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) mailmanList (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	resp, err := this.api.MailmanList (ctx)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) mailmanGet (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	mailmanId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	resp, err := this.api.MailmanGet (ctx, mailmanId)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) mailmanPatch (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	mailmanId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	data := &mailmanPatchRequest{}
+	if this.ValidateInput (ctx, c, data) == false {
+		return nil
+	}
+
+	resp, err := this.api.MailmanUpdate (ctx, mailmanId, data.MailmanAttr)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+// pauses or resumes a mailman
+func (this *app) mailmanPausePut (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	mailmanId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	data := &mailmanPauseRequest{}
+	if this.ValidateInput (ctx, c, data) == false {
+		return nil
+	}
+
+	resp, err := this.api.MailmanPause (ctx, mailmanId, data.Paused)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) mailmanDelete (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	mailmanId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	err = this.api.MailmanDelete (ctx, mailmanId)
+
+	return this.Respond (ctx, err, c, nil)
+}
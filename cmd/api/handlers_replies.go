@@ -0,0 +1,120 @@
+/** ****************************************************************************************************************** **
+	Replies sent to us by sendgrid's inbound parse, and the admin endpoints for reviewing them
+** ****************************************************************************************************************** **/
+
+package main
+
+import (
+	"coldbrew/db/postgres"
+	"coldbrew/pkg/api"
+	"coldbrew/tools/logging"
+	"coldbrew/tools/sendgrid"
+
+	"github.com/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+
+	"net/http"
+	"log/slog"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type replyReviewPutRequest struct {
+	Action api.ReplyAction
+}
+
+// validates the data is ok to update
+func (this *replyReviewPutRequest) ValidInput () error {
+	switch this.Action {
+	case api.ReplyAction_none, api.ReplyAction_unsubscribe, api.ReplyAction_bounce:
+		return nil // we're good
+	}
+
+	return errors.Wrap (logging.ErrReturnToUser, "Action appears invalid")
+}
+
+  //-------------------------------------------------------------------------------------------------------------------------//
+ //----- INBOUND -----------------------------------------------------------------------------------------------------------//
+//-------------------------------------------------------------------------------------------------------------------------//
+
+// sendgrid posts each email sent to our reply domain here, with the basic auth we put in the url
+func (this *app) inboundSendgridPost (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	if sendgrid.VerifyInbound (c.Get (fiber.HeaderAuthorization), cfg.InboundKey) == false {
+		slog.Warn ("sendgrid inbound failed verification", slog.String("ip", this.ClientIP (c)))
+		return this.RespondError (ctx, nil, c, http.StatusUnauthorized, "")
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return this.RespondError (ctx, errors.WithStack (err), c, http.StatusBadRequest, "form appears invalid")
+	}
+
+	inbound, err := sendgrid.ParseInbound (form)
+	if err != nil {
+		return this.RespondError (ctx, err, c, http.StatusBadRequest, "form appears invalid")
+	}
+
+	msg, err := inbound.Received()
+	if err != nil {
+		// sendgrid will keep retrying an email we can't read, so we take it and move on
+		this.StackTrace (ctx, err)
+		return this.LiveCheck (c)
+	}
+
+	to := inbound.To
+	if len(inbound.Envelope.To) > 0 { to = inbound.Envelope.To[0] } // where it was actually delivered
+
+	if err := this.api.ReplyReceived (ctx, postgres.EmailEventSource_sendgridInbound, msg, to, inbound.SpamScore); err != nil {
+		return this.Respond (ctx, err, c, nil) // sendgrid will try again
+	}
+
+	return this.LiveCheck (c)
+}
+
+  //-------------------------------------------------------------------------------------------------------------------------//
+ //----- REVIEW ------------------------------------------------------------------------------------------------------------//
+//-------------------------------------------------------------------------------------------------------------------------//
+
+// the replies waiting on someone, ?all=true for the ones already reviewed too and ?kind= for only one kind
+func (this *app) replyList (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	resp, err := this.api.ReplyList (ctx, postgres.EmailReplyKind(c.Query ("kind")), c.QueryBool ("all"))
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) replyGet (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	replyId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	resp, err := this.api.ReplyGet (ctx, replyId)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) replyReviewPut (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	replyId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	data := &replyReviewPutRequest{}
+	if this.ValidateInput (ctx, c, data) == false {
+		return nil
+	}
+
+	resp, err := this.api.ReplyReview (ctx, replyId, data.Action)
+
+	return this.Respond (ctx, err, c, resp)
+}
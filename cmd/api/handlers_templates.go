@@ -0,0 +1,154 @@
+/** ****************************************************************************************************************** **
+	Admin endpoints for managing the email templates
+** ****************************************************************************************************************** **/
+
+package main
+
+import (
+	"coldbrew/tools"
+	"coldbrew/pkg/api"
+	"coldbrew/db/postgres"
+	"coldbrew/tools/logging"
+
+	"github.com/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type templatePutRequest struct {
+	api.TemplateContent
+	Warmup, Paused bool
+}
+
+// validates the data is ok to create
+func (this *templatePutRequest) ValidInput () error {
+	if this.Subject.Valid() == false {
+		return errors.Wrap (logging.ErrReturnToUser, "Subject is required")
+	}
+
+	if this.Text.Valid() == false && this.Html.Valid() == false {
+		return errors.Wrap (logging.ErrReturnToUser, "Text or Html is required")
+	}
+
+	return nil // we're good
+}
+
+type templatePatchRequest struct {
+	api.TemplatePatch
+	Warmup, Paused *bool
+}
+
+// validates the data is ok to update, anything left off stays the same
+func (this *templatePatchRequest) ValidInput () error {
+	return nil // we're good
+}
+
+type templatePreviewRequest struct {
+	User, Email tools.String
+	Attr postgres.UserAttr // merge fields for the sample user
+}
+
+// validates the data is ok to render with, both are optional
+func (this *templatePreviewRequest) ValidInput () error {
+	if this.User.Valid() && this.User.IsUUID() == false {
+		return errors.Wrap (logging.ErrReturnToUser, "User appears invalid")
+	}
+
+	if this.Email.Valid() && this.Email.Email() == false {
+		return errors.Wrap (logging.ErrReturnToUser, "Email appears invalid")
+	}
+
+	return nil // we're good
+}
+
+  //-------------------------------------------------------------------------------------------------------------------------//
+ //----- TEMPLATES ---------------------------------------------------------------------------------------------------------//
+//-------------------------------------------------------------------------------------------------------------------------//
+
+// creates a new template, this fails if the template doesn't render
+func (this *app) templatePut (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	data := &templatePutRequest{}
+	if this.ValidateInput (ctx, c, data) == false {
+		return nil
+	}
+
+	resp, err := this.api.TemplateCreate (ctx, data.TemplateContent, data.Warmup, data.Paused)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) templateList (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	resp, err := this.api.TemplateList (ctx)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) templateGet (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	templateId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	resp, err := this.api.TemplateGet (ctx, templateId)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) templatePatch (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	templateId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	data := &templatePatchRequest{}
+	if this.ValidateInput (ctx, c, data) == false {
+		return nil
+	}
+
+	resp, err := this.api.TemplateUpdate (ctx, templateId, data.TemplatePatch, data.Warmup, data.Paused)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) templateDelete (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	templateId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	err = this.api.TemplateDelete (ctx, templateId)
+
+	return this.Respond (ctx, err, c, nil)
+}
+
+// renders the template against a real user or a sample one
+func (this *app) templatePreviewPost (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	templateId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	data := &templatePreviewRequest{}
+	if len(c.Body()) > 0 { // the body is optional here
+		if this.ValidateInput (ctx, c, data) == false {
+			return nil
+		}
+	}
+
+	resp, err := this.api.TemplatePreview (ctx, templateId, data.User.UUID(), data.Email, data.Attr)
+
+	return this.Respond (ctx, err, c, resp)
+}
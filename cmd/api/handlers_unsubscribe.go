@@ -25,7 +25,7 @@ import (
 // renders the webpage for a user to unsubscribe from
 
 func (this *app) unsubscribeGet (c *fiber.Ctx) error {
-	ctx, cancel := handlerCtx()
+	ctx, cancel := handlerCtx(c)
 	defer cancel()
 
 	var token tools.String 
@@ -49,7 +49,7 @@ func (this *app) unsubscribeGet (c *fiber.Ctx) error {
 }
 
 func (this *app) unsubscribePut (c *fiber.Ctx) error {
-	ctx, cancel := handlerCtx()
+	ctx, cancel := handlerCtx(c)
 	defer cancel()
 
 	var token tools.String 
@@ -6,6 +6,7 @@ package main
 
 import (
 	"coldbrew/tools"
+	"coldbrew/pkg/api"
 	"coldbrew/tools/logging"
 	
 	"github.com/pkg/errors"
@@ -19,14 +20,26 @@ import (
 type userPutRequest struct {
 	Warmup, SkipValidation bool
 	Emails tools.StringList
+	Users []api.NewUser // for when we have attributes to go along with the email
 }
 
 // validates the data is ok to create
 func (this *userPutRequest) ValidInput () error {
-	if this.Emails.Len() == 0 {
+	if this.Emails.Len() == 0 && len(this.Users) == 0 {
 		return errors.Wrap (logging.ErrReturnToUser, "no emails found")
 	}
 
+	for _, user := range this.Users {
+		if user.Email.Valid() == false {
+			return errors.Wrap (logging.ErrReturnToUser, "user is missing an email")
+		}
+	}
+
+	// the plain list of emails just don't have any attributes
+	for _, email := range this.Emails {
+		this.Users = append (this.Users, api.NewUser { Email: email })
+	}
+
 	return nil // we're good
 }
 
@@ -36,7 +49,7 @@ func (this *userPutRequest) ValidInput () error {
 
 // ads more user emails
 func (this *app) userPut (c *fiber.Ctx) error {
-	ctx, cancel := handlerCtx()
+	ctx, cancel := handlerCtx(c)
 	defer cancel()
 	
 	data := &userPutRequest{}
@@ -44,7 +57,7 @@ func (this *app) userPut (c *fiber.Ctx) error {
 		return nil
 	}
 
-	resp, err := this.api.AddUsers (ctx, data.Warmup, data.Emails, data.SkipValidation)
+	resp, err := this.api.AddUsers (ctx, data.Warmup, data.Users, data.SkipValidation)
 
 	return this.Respond (ctx, err, c, resp)
 }
@@ -0,0 +1,159 @@
+/** ****************************************************************************************************************** **
+	Admin endpoints for managing the warmup plans, and which one each mailman is on
+** ****************************************************************************************************************** **/
+
+package main
+
+import (
+	"coldbrew/tools"
+	"coldbrew/db/postgres"
+	"coldbrew/tools/logging"
+
+	"github.com/pkg/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type warmupPlanPutRequest struct {
+	Name tools.String
+	postgres.WarmupPlanAttr
+}
+
+// validates the data is ok to create
+func (this *warmupPlanPutRequest) ValidInput () error {
+	if this.Name.Valid() == false {
+		return errors.Wrap (logging.ErrReturnToUser, "Name is required")
+	}
+
+	return this.WarmupPlanAttr.Valid()
+}
+
+type warmupPlanPatchRequest struct {
+	Name tools.String
+	postgres.WarmupPlanAttr
+}
+
+// validates the data is ok to update, anything left off stays the same
+func (this *warmupPlanPatchRequest) ValidInput () error {
+	if len(this.Phases) > 0 { return this.WarmupPlanAttr.Valid() }
+	return nil // we're good
+}
+
+type mailmanWarmupPutRequest struct {
+	Plan tools.String
+}
+
+// validates the data is ok to update
+func (this *mailmanWarmupPutRequest) ValidInput () error {
+	if this.Plan.IsUUID() == false {
+		return errors.Wrap (logging.ErrReturnToUser, "Plan appears invalid")
+	}
+
+	return nil // we're good
+}
+
+  //-------------------------------------------------------------------------------------------------------------------------//
+ //----- PLANS -------------------------------------------------------------------------------------------------------------//
+//-------------------------------------------------------------------------------------------------------------------------//
+
+func (this *app) warmupPut (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	data := &warmupPlanPutRequest{}
+	if this.ValidateInput (ctx, c, data) == false {
+		return nil
+	}
+
+	resp, err := this.api.WarmupPlanCreate (ctx, data.Name, data.WarmupPlanAttr)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) warmupList (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	resp, err := this.api.WarmupPlanList (ctx)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) warmupGet (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	planId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	resp, err := this.api.WarmupPlanGet (ctx, planId)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) warmupPatch (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	planId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	data := &warmupPlanPatchRequest{}
+	if this.ValidateInput (ctx, c, data) == false {
+		return nil
+	}
+
+	resp, err := this.api.WarmupPlanUpdate (ctx, planId, data.Name, data.WarmupPlanAttr)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+func (this *app) warmupDelete (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	planId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	err = this.api.WarmupPlanDelete (ctx, planId)
+
+	return this.Respond (ctx, err, c, nil)
+}
+
+  //-------------------------------------------------------------------------------------------------------------------------//
+ //----- MAILMEN -----------------------------------------------------------------------------------------------------------//
+//-------------------------------------------------------------------------------------------------------------------------//
+
+// which plan the mailman is on and how far along they are
+func (this *app) mailmanWarmupGet (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	mailmanId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	resp, err := this.api.MailmanWarmupGet (ctx, mailmanId)
+
+	return this.Respond (ctx, err, c, resp)
+}
+
+// puts the mailman on a plan, starting it over from the first phase
+func (this *app) mailmanWarmupPut (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	mailmanId, err := pathUUID (c, "id")
+	if err != nil { return this.Respond (ctx, err, c, nil) }
+
+	data := &mailmanWarmupPutRequest{}
+	if this.ValidateInput (ctx, c, data) == false {
+		return nil
+	}
+
+	resp, err := this.api.MailmanWarmupSet (ctx, mailmanId, data.Plan.UUID())
+
+	return this.Respond (ctx, err, c, resp)
+}
@@ -5,28 +5,180 @@
 package main 
 
 import (
+	"coldbrew/cmd"
 	"coldbrew/tools"
 	"coldbrew/db/postgres"
+	"coldbrew/tools/ses"
+	"coldbrew/tools/sendgrid"
+	"coldbrew/tools/mailgun"
+	"coldbrew/tools/postmark"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
 	json "github.com/json-iterator/go"
+
+	"context"
+	"net/http"
+	"log/slog"
+	"time"
 )
 
   //-----------------------------------------------------------------------------------------------------------------------//
  //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
 
-type sendgridPost []struct {
+type sendgridEvent struct {
 	Category tools.StringList
-	Email, Event tools.String
+	Email, Event, Type tools.String // type tells a block apart from a bounce
 	Sg_event_id, Sg_message_id tools.String
+	Timestamp int64
+	Reason, Response, Url, Ip, Useragent tools.String
 }
 
+// we keep each event exactly as it came in, so this is parsed one event at a time
+type sendgridPost []json.RawMessage
+
 // validates the data is ok to create
 func (this sendgridPost) ValidInput () error {
 	return nil // we're good
 }
 
+  //-------------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE -----------------------------------------------------------------------------------------------------------//
+//-------------------------------------------------------------------------------------------------------------------------//
+
+// sendgrid's events are already our statuses, except it puts blocks in with the bounces
+func sendgridStatus (event *sendgridEvent) postgres.EmailStatus {
+	if event.Event.String() == "bounce" && event.Type.String() == "blocked" { return postgres.EmailStatus_blocked }
+	return postgres.EmailStatus(event.Event.String())
+}
+
+// maps what ses tells us happened onto our own statuses, empty means we don't care about it
+func sesStatus (event *ses.SESEvent) postgres.EmailStatus {
+	switch event.Type() {
+	case "Send":
+		return postgres.EmailStatus_processed
+	case "Delivery":
+		return postgres.EmailStatus_delivered
+	case "DeliveryDelay":
+		return postgres.EmailStatus_deferred
+	case "Bounce":
+		if event.Bounce.BounceType == "Permanent" {
+			return postgres.EmailStatus_bounce
+		}
+		if event.Bounce.BounceSubType == "ContentRejected" {
+			return postgres.EmailStatus_blocked // their server didn't want what we sent, not a problem with the address
+		}
+		return postgres.EmailStatus_deferred // transient, ses will keep trying
+	case "Complaint":
+		return postgres.EmailStatus_spamreport
+	case "Reject", "Rendering Failure":
+		return postgres.EmailStatus_dropped
+	case "Open":
+		return postgres.EmailStatus_open
+	case "Click":
+		return postgres.EmailStatus_click
+	case "Subscription":
+		return postgres.EmailStatus_unsubscribe
+	}
+	return ""
+}
+
+// maps what mailgun tells us happened onto our own statuses, empty means we don't care about it
+func mailgunStatus (webhook *mailgun.Webhook) postgres.EmailStatus {
+	switch webhook.EventData.Event {
+	case "accepted":
+		return postgres.EmailStatus_processed
+	case "delivered":
+		return postgres.EmailStatus_delivered
+	case "failed":
+		if webhook.EventData.Reason == "espblock" {
+			return postgres.EmailStatus_blocked
+		}
+		if webhook.EventData.Severity == "permanent" {
+			return postgres.EmailStatus_bounce
+		}
+		return postgres.EmailStatus_deferred // temporary, mailgun will keep trying
+	case "rejected":
+		return postgres.EmailStatus_dropped
+	case "opened":
+		return postgres.EmailStatus_open
+	case "clicked":
+		return postgres.EmailStatus_click
+	case "complained":
+		return postgres.EmailStatus_spamreport
+	case "unsubscribed":
+		return postgres.EmailStatus_unsubscribe
+	}
+	return ""
+}
+
+// maps what postmark tells us happened onto our own statuses, empty means we don't care about it
+func postmarkStatus (webhook *postmark.Webhook) postgres.EmailStatus {
+	switch webhook.RecordType {
+	case postmark.RecordType_delivery:
+		return postgres.EmailStatus_delivered
+	case postmark.RecordType_bounce:
+		switch webhook.Type {
+		case "HardBounce", "BadEmailAddress", "ManuallyDeactivated":
+			return postgres.EmailStatus_bounce
+		case "Blocked":
+			return postgres.EmailStatus_blocked
+		case "SpamNotification", "SpamComplaint":
+			return postgres.EmailStatus_spamreport
+		case "Unsubscribe":
+			return postgres.EmailStatus_unsubscribe
+		}
+		return postgres.EmailStatus_deferred // soft bounces, auto responders, etc
+	case postmark.RecordType_spamComplaint:
+		return postgres.EmailStatus_spamreport
+	case postmark.RecordType_open:
+		return postgres.EmailStatus_open
+	case postmark.RecordType_click:
+		return postgres.EmailStatus_click
+	case postmark.RecordType_subscription:
+		if webhook.SuppressSending {
+			return postgres.EmailStatus_unsubscribe
+		}
+	}
+	return ""
+}
+
+// records the event, and from that what happened for the user and the email we sent them
+func (this *app) webhookStatus (ctx context.Context, address string, event *postgres.EmailEvent) {
+	cmd.MetricWebhookEvents.WithLabelValues (string(event.Provider), string(event.Status)).Inc()
+
+	var email tools.String
+	email.Set (address)
+
+	// still recorded, it just can't be tied to a user
+	if email.Email() == false {
+		this.TraceErr (ctx, "we got an invalid email: %s", string(event.Raw))
+	}
+
+	if len(event.MessageId) == 0 {
+		this.TraceErr (ctx, "we got an invalid message id: %s", string(event.Raw))
+	}
+
+	this.StackTrace (ctx, this.db.EmailEventRecord (ctx, email, event))
+}
+
+// anyone can post to us, so this makes sure one of our mailmen for this provider has the key that signed it
+func (this *app) webhookVerified (ctx context.Context, c *fiber.Ctx, provider postgres.MailmanProvider, verify func (key string) bool) bool {
+	keys, err := this.db.MailmanWebhookKeys (ctx, provider)
+	if err != nil {
+		this.StackTrace (ctx, err)
+		return false
+	}
+
+	for _, key := range keys {
+		if verify (key) { return true }
+	}
+
+	slog.Warn ("webhook failed verification", slog.String("provider", string(provider)), slog.String("ip", this.ClientIP (c)))
+	return false
+}
+
 
   //-------------------------------------------------------------------------------------------------------------------------//
  //----- TEAMS -------------------------------------------------------------------------------------------------------------//
@@ -34,7 +186,7 @@ func (this sendgridPost) ValidInput () error {
 
 
 func (this *app) sendgridPost (c *fiber.Ctx) error {
-	ctx, cancel := handlerCtx()
+	ctx, cancel := handlerCtx(c)
 	defer cancel()
 
 	data := sendgridPost{}
@@ -43,6 +195,20 @@ func (this *app) sendgridPost (c *fiber.Ctx) error {
 	bodyBytes := c.Body()
 	// fmt.Println("sendgridPost", string(bodyBytes))
 
+	// each sendgrid account signs with its own key, so this has to match one of our mailmen
+	signature, timestamp := c.Get (sendgrid.HeaderSignature), c.Get (sendgrid.HeaderTimestamp)
+	if err := sendgrid.WebhookFresh (timestamp, time.Now()); err != nil {
+		slog.Warn ("sendgrid webhook failed verification", slog.String("error", err.Error()), slog.String("ip", this.ClientIP (c)))
+		return this.RespondError (ctx, nil, c, http.StatusUnauthorized, "")
+	}
+
+	verify := func (key string) bool {
+		return sendgrid.VerifyWebhook (key, signature, timestamp, bodyBytes)
+	}
+	if this.webhookVerified (ctx, c, postgres.MailmanProvider_sendgrid, verify) == false {
+		return this.RespondError (ctx, nil, c, http.StatusUnauthorized, "")
+	}
+
 	err := json.Unmarshal (bodyBytes, &data)
 	if err != nil {
 		this.StackTrace (ctx, err)
@@ -50,23 +216,186 @@ func (this *app) sendgridPost (c *fiber.Ctx) error {
 	} else {
 		// this worked, we got some data
 
-		for _, event := range data {
-			// for each piece of data we have some possible action items
-			// first let's give a report about this user
-			if event.Email.Email() {
-				this.StackTrace (ctx, this.db.UserUpdateStatus (ctx, event.Email, event.Event.String()))
-			} else {
-				this.TraceErr (ctx, "we got an invalid email: %s", string(bodyBytes))
+		for _, raw := range data {
+			event := &sendgridEvent{}
+			if err := json.Unmarshal (raw, event); err != nil {
+				this.StackTrace (ctx, errors.Wrapf (err, "sendgrid event : %s", string(raw)))
+				continue
 			}
 
-			// now let's give a report about this email we sent
+			// deferred events put the reason in the response
+			reason := event.Reason
+			if reason.Valid() == false { reason = event.Response }
+
+			messageId := ""
 			if event.Sg_message_id.Valid() {
-				this.StackTrace (ctx, this.db.EmailUpdateStatus (ctx, event.Email, event.Sg_message_id.String(), postgres.EmailStatus(event.Event.String())))
-			} else {
-				this.TraceErr (ctx, "we got an invalid sg_message_id: %s", string(bodyBytes))
+				messageId = sendgrid.MessageId (event.Sg_message_id.String())
 			}
+
+			this.webhookStatus (ctx, event.Email.String(), &postgres.EmailEvent {
+				Provider: postgres.MailmanProvider_sendgrid,
+				EventId: event.Sg_event_id.String(),
+				MessageId: messageId,
+				Status: sendgridStatus (event),
+				Time: time.Unix (event.Timestamp, 0),
+				Reason: reason,
+				Url: event.Url,
+				Ip: event.Ip,
+				UserAgent: event.Useragent,
+				Raw: raw,
+			})
 		}
 	}
 
 	return this.LiveCheck (c)
 }
+
+// sns notifications for our ses mailmen
+func (this *app) sesPost (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	msg := &ses.SNSMessage{}
+	if err := json.Unmarshal (c.Body(), msg); err != nil {
+		return this.RespondError (ctx, errors.WithStack (err), c, http.StatusBadRequest, "json appears invalid")
+	}
+
+	// anyone can post to us, so make sure this is really from aws before we do anything with it
+	if err := msg.Verify (ctx); err != nil {
+		slog.Warn ("ses webhook failed verification", slog.String("error", err.Error()), slog.String("ip", this.ClientIP (c)))
+		return this.RespondError (ctx, nil, c, http.StatusUnauthorized, "")
+	}
+
+	// any aws account can sign a message, so it has to be from a topic one of our mailmen set up
+	// this goes before confirming, otherwise anyone could subscribe us to their own topic
+	verify := func (key string) bool {
+		return key == msg.TopicArn
+	}
+	if this.webhookVerified (ctx, c, postgres.MailmanProvider_ses, verify) == false {
+		return this.RespondError (ctx, nil, c, http.StatusUnauthorized, "")
+	}
+
+	switch msg.Type {
+	case ses.SNSType_subscribe:
+		if err := msg.Confirm (ctx); err != nil {
+			return this.Respond (ctx, err, c, nil) // sns will try again
+		}
+		slog.Info ("confirmed ses sns subscription", slog.String("topic", msg.TopicArn))
+
+	case ses.SNSType_notification:
+		event := &ses.SESEvent{}
+		if err := json.Unmarshal ([]byte(msg.Message), event); err != nil {
+			this.StackTrace (ctx, errors.Wrapf (err, "ses event : %s", msg.Message))
+			break
+		}
+
+		status := sesStatus (event)
+		if len(status) == 0 {
+			this.TraceErr (ctx, "unknown ses event type: %s", msg.Message)
+			break
+		}
+
+		for _, recipient := range event.Recipients() {
+			this.webhookStatus (ctx, recipient, &postgres.EmailEvent {
+				Provider: postgres.MailmanProvider_ses,
+				EventId: msg.MessageId + ":" + recipient, // one notification can be about more than one recipient
+				MessageId: event.Mail.MessageId,
+				Status: status,
+				Time: event.Time(),
+				Reason: tools.String(event.Reason (recipient)),
+				Url: tools.String(event.Click.Link),
+				Ip: tools.String(event.Ip()),
+				UserAgent: tools.String(event.UserAgent()),
+				Raw: []byte(msg.Message),
+			})
+		}
+	}
+
+	return this.LiveCheck (c)
+}
+
+// mailgun sends each event on its own, signed with the domain's webhook signing key
+func (this *app) mailgunPost (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	webhook := &mailgun.Webhook{}
+	if err := json.Unmarshal (c.Body(), webhook); err != nil {
+		return this.RespondError (ctx, errors.WithStack (err), c, http.StatusBadRequest, "json appears invalid")
+	}
+
+	if this.webhookVerified (ctx, c, postgres.MailmanProvider_mailgun, webhook.Verify) == false {
+		return this.RespondError (ctx, nil, c, http.StatusUnauthorized, "")
+	}
+
+	// the signature only covers the timestamp and token, so a captured webhook is good until we stop taking it
+	if err := webhook.Fresh (time.Now()); err != nil {
+		slog.Warn ("mailgun webhook failed verification", slog.String("error", err.Error()), slog.String("ip", this.ClientIP (c)))
+		return this.RespondError (ctx, nil, c, http.StatusUnauthorized, "")
+	}
+	if this.mailgunTokens.Add (webhook.Signature.Token, time.Now()) == false {
+		slog.Warn ("mailgun webhook replayed", slog.String("ip", this.ClientIP (c)))
+		return this.LiveCheck (c) // already have it, no reason for them to try again
+	}
+
+	status := mailgunStatus (webhook)
+	if len(status) == 0 {
+		this.TraceErr (ctx, "unknown mailgun event type: %s", string(c.Body()))
+		return this.LiveCheck (c)
+	}
+
+	this.webhookStatus (ctx, webhook.EventData.Recipient, &postgres.EmailEvent {
+		Provider: postgres.MailmanProvider_mailgun,
+		EventId: webhook.EventData.Id,
+		MessageId: webhook.EventData.Message.Headers.MessageId,
+		Status: status,
+		Time: webhook.Time(),
+		Reason: tools.String(webhook.Reason()),
+		Url: tools.String(webhook.EventData.Url),
+		Ip: tools.String(webhook.EventData.Ip),
+		UserAgent: tools.String(webhook.EventData.ClientInfo.UserAgent),
+		Raw: c.Body(),
+	})
+
+	return this.LiveCheck (c)
+}
+
+// postmark sends each event on its own, using the basic auth we put in the webhook url
+func (this *app) postmarkPost (c *fiber.Ctx) error {
+	ctx, cancel := handlerCtx(c)
+	defer cancel()
+
+	auth := c.Get (fiber.HeaderAuthorization)
+	verify := func (key string) bool {
+		return postmark.VerifyAuth (auth, key)
+	}
+
+	if this.webhookVerified (ctx, c, postgres.MailmanProvider_postmark, verify) == false {
+		return this.RespondError (ctx, nil, c, http.StatusUnauthorized, "")
+	}
+
+	webhook := &postmark.Webhook{}
+	if err := json.Unmarshal (c.Body(), webhook); err != nil {
+		return this.RespondError (ctx, errors.WithStack (err), c, http.StatusBadRequest, "json appears invalid")
+	}
+
+	status := postmarkStatus (webhook)
+	if len(status) == 0 {
+		this.TraceErr (ctx, "unknown postmark event type: %s", string(c.Body()))
+		return this.LiveCheck (c)
+	}
+
+	this.webhookStatus (ctx, webhook.Address(), &postgres.EmailEvent {
+		Provider: postgres.MailmanProvider_postmark,
+		MessageId: webhook.MessageID,
+		Status: status,
+		Time: webhook.Time(),
+		Reason: tools.String(webhook.Reason()),
+		Url: tools.String(webhook.OriginalLink),
+		Ip: tools.String(webhook.Geo.IP),
+		UserAgent: tools.String(webhook.UserAgent),
+		Raw: c.Body(),
+	})
+
+	return this.LiveCheck (c)
+}
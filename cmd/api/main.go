@@ -10,6 +10,7 @@ import (
 	"coldbrew/cmd"
 	"coldbrew/db/postgres"
 	"coldbrew/pkg/api"
+	"coldbrew/tools/mailgun"
 	
 	"github.com/jessevdk/go-flags"
 	
@@ -41,6 +42,7 @@ var opts struct {
 var cfg struct {
 	cmd.CFG
 	AdminToken string
+	InboundKey string // the basic auth password in the url we give sendgrid's inbound parse, no key turns it away
 }
 
 
@@ -97,6 +99,8 @@ type app struct {
 	api *api.API 
 	
 	db 			*postgres.Coldbrew
+
+	mailgunTokens	mailgun.Tokens // the webhooks we've already taken, so they can't be replayed
 }
 
   //-------------------------------------------------------------------------------------------------------------------//
@@ -120,6 +124,7 @@ func (this *app) init() (cmd.Callback, error) {
 	}
 
 	this.db = postgres.NewColdbrew(coldbrewDB)
+	this.MetricsPool ("coldbrew", coldbrewDB)
 	
 	if err == nil {
 		this.api = api.NewAPI(this.db, tools.String(cfg.ApiUrl), cfg.Production)
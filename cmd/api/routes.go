@@ -24,7 +24,7 @@ func (this *app) defaultGet (c *fiber.Ctx) error {
 //-------------------------------------------------------------------------------------------------------------------------//
 
 func (this *app) ready (c *fiber.Ctx) error {
-	if this.Running == false {
+	if this.Running() == false {
 		return this.K8ServiceNotRunning (c)
 	}
 
@@ -46,6 +46,9 @@ func (this *app) ready (c *fiber.Ctx) error {
 //-------------------------------------------------------------------------------------------------------------------------//
 
 func (this *app) routes () *fiber.App {
+	// these take requests from anyone, so we don't continue any trace they send us
+	this.PublicPaths ("/sendgrid", "/ses", "/mailgun", "/postmark", "/inbound/", "/unsubscribe/")
+
 	// standard chain that all calls make
 	app := this.Routes()
 
@@ -57,13 +60,58 @@ func (this *app) routes () *fiber.App {
 	// sendgrid event callbacks
 	app.Post("/sendgrid", this.sendgridPost)
 
+	// ses events through sns
+	app.Post("/ses", this.sesPost)
+
+	// mailgun and postmark event callbacks
+	app.Post("/mailgun", this.mailgunPost)
+	app.Post("/postmark", this.postmarkPost)
+
+	// replies to our emails from sendgrid's inbound parse
+	app.Post("/inbound/sendgrid", this.inboundSendgridPost)
+
 	app.Get("/unsubscribe/:token", this.unsubscribeGet)
 	app.Put("/unsubscribe/:token", this.unsubscribePut)
 
 // Bearer Stuff
 	// users
 	app.Put("/user", this.bearer, this.userPut)
-	
+
+	// mailmen
+	app.Put("/mailman", this.bearer, this.mailmanPut)
+	app.Get("/mailman", this.bearer, this.mailmanList)
+	app.Get("/mailman/:id", this.bearer, this.mailmanGet)
+	app.Patch("/mailman/:id", this.bearer, this.mailmanPatch)
+	app.Put("/mailman/:id/pause", this.bearer, this.mailmanPausePut)
+	app.Delete("/mailman/:id", this.bearer, this.mailmanDelete)
+	app.Get("/mailman/:id/warmup", this.bearer, this.mailmanWarmupGet)
+	app.Put("/mailman/:id/warmup", this.bearer, this.mailmanWarmupPut)
+
+	// warmup plans
+	app.Put("/warmup", this.bearer, this.warmupPut)
+	app.Get("/warmup", this.bearer, this.warmupList)
+	app.Get("/warmup/:id", this.bearer, this.warmupGet)
+	app.Patch("/warmup/:id", this.bearer, this.warmupPatch)
+	app.Delete("/warmup/:id", this.bearer, this.warmupDelete)
+
+	// templates
+	app.Put("/template", this.bearer, this.templatePut)
+	app.Get("/template", this.bearer, this.templateList)
+	app.Get("/template/:id", this.bearer, this.templateGet)
+	app.Patch("/template/:id", this.bearer, this.templatePatch)
+	app.Delete("/template/:id", this.bearer, this.templateDelete)
+	app.Post("/template/:id/preview", this.bearer, this.templatePreviewPost)
+
+	// emails the qb gave up on
+	app.Get("/email/dead", this.bearer, this.emailDeadList)
+	app.Put("/email/dead/requeue", this.bearer, this.emailDeadRequeuePut)
+	app.Put("/email/:id/requeue", this.bearer, this.emailRequeuePut)
+
+	// replies, bounces and unsubscribe requests people sent back to us
+	app.Get("/reply", this.bearer, this.replyList)
+	app.Get("/reply/:id", this.bearer, this.replyGet)
+	app.Put("/reply/:id/review", this.bearer, this.replyReviewPut)
+
 
 	// Catch-all 404 handler (MUST be the last middleware)
 	app.Use(func(c *fiber.Ctx) error {
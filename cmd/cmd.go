@@ -9,13 +9,18 @@ import (
 	"coldbrew/db"
 	"coldbrew/tools"
 	"coldbrew/tools/logging"
+	"coldbrew/tools/tracing"
 	
 	"github.com/pkg/errors"
 	"github.com/google/uuid"
 	json "github.com/json-iterator/go"
 	
+	"context"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"math/rand"
@@ -27,6 +32,7 @@ import (
 
 var (
 	ContextTimeout 	tools.TimeDuration = 50 // seconds for a request to finish, this is so everything finishes < 60 seconds which is the kubernetes timeout
+	DrainTimeout	tools.TimeDuration = 25 // default seconds we give running flows to finish after we're told to stop, kubernetes gives us 30
 )
 
   //-----------------------------------------------------------------------------------------------------------------------//
@@ -38,6 +44,8 @@ type CFG struct {
 	Production bool 
 	ApiUrl, ServiceName string
 	Coldbrew db.SqlCFG
+	DrainTimeout tools.TimeDuration // seconds running flows get to finish when we're shutting down
+	Tracing tracing.CFG
 }
 
 // for parsing command line arguments
@@ -54,7 +62,16 @@ type OPTS struct {
 
 type App struct {
 	logging.Logger
-	Running bool // flag for letting other threads know the parent is shutting down
+
+	ctx context.Context // cancelled when we're told to shut down, nothing new should start after this
+	stop context.CancelFunc
+	drainCtx context.Context // cancelled once the drain timeout passes, this is what in-flight work runs under
+	drain context.CancelFunc
+	drainTimeout tools.TimeDuration
+
+	flows flowStats // how each flow is doing, and so we can report on anything that didn't stop in time
+	leader *db.Leader // only set when this app takes part in leader election
+	publicPaths []string // anyone on the internet can call these, see PublicPaths
 }
 
 
@@ -72,19 +89,82 @@ func (this *App) Init (cfg CFG) (Callback, error) {
 
 	uuid.EnableRandPool() // call this to help with uuid randomness
 
-	this.Running = true // never a reason not to set this
+	this.ctx, this.stop = context.WithCancel (context.Background())
+	this.drainCtx, this.drain = context.WithCancel (context.Background())
+
+	this.drainTimeout = cfg.DrainTimeout
+	if this.drainTimeout <= 0 { this.drainTimeout = DrainTimeout }
+
+	ctx, cancel := tools.TimeDuration(10).Context ("tracing")
+	defer cancel()
+
+	shutdown, err := tracing.Init (ctx, cfg.Tracing, cfg.ServiceName)
+	if err != nil { return EmptyCallback, err }
+
+	return func() error {
+		ctx, cancel := tools.TimeDuration(5).Context ("tracing")
+		defer cancel()
+
+		return shutdown (ctx) // flush whatever spans are left
+	}, nil
+}
+
+// false once we've been told to shut down
+func (this *App) Running () bool {
+	return this.ctx.Err() == nil
+}
 
-	return EmptyCallback, nil
+// the root context, this is cancelled when we're told to shut down
+func (this *App) Context () context.Context {
+	return this.ctx
 }
 
-// monitors for a kill sigterm to set the running = false
-// fires a custom function when it exits
+// monitors for a kill sigterm and cancels the root context
 func (this *App) MonitorForKill() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
-	<-c // this sits until something comes into the channel, eg the notify interupts from above
-	this.Running = false
+	select {
+	case <-c: // this sits until something comes into the channel, eg the notify interupts from above
+	case <-this.ctx.Done(): // or someone else stopped us
+	}
+	this.stop()
+}
+
+// stops everything without waiting for a signal
+func (this *App) Stop () {
+	this.stop()
+}
+
+// waits for the flows to finish what they're doing, up to the drain timeout
+// after that their contexts get cancelled and we report the ones that still haven't stopped
+func (this *App) Drain (wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close (done)
+	}()
+
+	select {
+	case <-done:
+		this.drain()
+		return nil // everyone stopped on their own
+
+	case <-time.After (this.drainTimeout.Duration()):
+		this.drain() // out of time, cancel whatever is still running
+	}
+
+	// give them a moment to notice the cancel before we report them
+	select {
+	case <-done:
+	case <-time.After (time.Second):
+	}
+
+	stuck := this.flows.running()
+	if len(stuck) == 0 { return nil }
+
+	slog.Error ("flows failed to stop in time", slog.Any("flows", stuck), slog.Int("drainSeconds", int(this.drainTimeout)))
+	return errors.Errorf ("flows failed to stop in time : %s", strings.Join (stuck, ", "))
 }
 
   //-----------------------------------------------------------------------------------------------------------------------//
@@ -7,9 +7,16 @@ package cmd
 
 import (
 	"coldbrew/tools"
+	"coldbrew/tools/logging"
+	"coldbrew/tools/tracing"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"fmt"
 	"context"
+	"math/rand"
 	"runtime"
 	"time"
 	"sync"
@@ -21,142 +28,210 @@ import (
  //----- CONSTS ----------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
 
+// returned by a flow that had nothing to do because it isn't its turn, it's counted as skipped rather than a run
+var ErrFlowSkipped = errors.New ("flow skipped")
+
 // generic function prototype we launch using flow control
 type flowRunFunc func (context.Context) error 
 type flowRunChFunc func (context.Context, interface{}) error 
 
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// how and when a flow runs, set either the interval or the cron
+type FlowSchedule struct {
+	Interval tools.TimeDuration // run every this many seconds
+	Cron string // or on a cron expression, see tools.ParseCron. ex: "CRON_TZ=America/Chicago 0 6 * * *"
+	Jitter time.Duration // random extra wait before each run, so pods don't all fire at the same moment
+	InitialDelay time.Duration // wait before the first run, otherwise interval flows fire right away
+	MaxRuntime tools.TimeDuration // context timeout for each run, defaults to ContextTimeout
+	Blocking bool // wait for each run to finish before starting the next
+}
+
   //-----------------------------------------------------------------------------------------------------------------------//
  //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
 
 // anytime we launch a thread and want to know the stack of where it failed
-// call this as a defer
-func (this *App) recover () {
+// call this as a defer, the panic gets turned into the run's error
+func (this *App) recover (threadName string, err *error) {
 	if r := recover(); r != nil {
+		this.flows.panicked (threadName)
+		*err = errors.Errorf ("panic: %v", r)
+
 		buf := make([]byte, 4096)
 		n := runtime.Stack(buf, false)
-		slog.Error (fmt.Sprintf("Recovered from panic: %v", r), slog.Any("stacktrace", buf[:n]))
+		slog.Error (fmt.Sprintf("Recovered from panic: %v", r), slog.String("flow", threadName), slog.Any("stacktrace", buf[:n]))
 	}
 }
 
-func (this *App) fire (locWg *sync.WaitGroup, fn flowRunFunc, contextTimeout tools.TimeDuration, threadName string, blocking bool) {
-	if blocking {
-		defer this.recover()
+// runs the function once, the context comes off the drain context so it keeps going through a shutdown until the drain timeout
+func (this *App) run (fn flowRunFunc, contextTimeout tools.TimeDuration, threadName string) (err error) {
+	started := time.Now()
+	skipped := false
+	this.flows.start (threadName, started) // so we can report on it if it doesn't stop in time
+	defer func() { this.flows.finish (threadName, started, skipped, err) }()
+
+	// create some context for this run
+	ctx, cancel := contextTimeout.ContextFrom (this.drainCtx, threadName) // give local context to run
+	defer cancel() // we're done with this local context
+
+	// each tick is its own trace
+	ctx, span := tracing.Start (ctx, "flow " + threadName, trace.SpanKindInternal, attribute.String ("flow", threadName))
+	defer func() { 
+		tracing.Error (span, err)
+		span.End() 
+	}()
+	if traceId := tracing.TraceId (ctx); len(traceId) > 0 {
+		ctx = context.WithValue (ctx, logging.ThreadId, traceId) // so the logs line up with the trace
+	}
 
-		// create some context for this run
-		ctx, cancel := contextTimeout.Context(threadName) // give local context to run
-		err := fn(ctx)
+	defer this.recover (threadName, &err) // deferred last so a panic is turned into an error before the span and stats see it
 
-		// now check our context, and then error 
-		this.StackTrace(ctx, err) // record this error
-		
-		cancel() // we're done with this local context
+	err = fn(ctx)
+	if errors.Is (err, ErrFlowSkipped) {
+		skipped, err = true, nil // nothing went wrong, it just wasn't our turn
+		return
+	}
+
+	// now check our context, and then error 
+	this.CtxOk (ctx)
+	this.StackTrace(ctx, err) // record this error
+	return
+}
+
+func (this *App) fire (locWg *sync.WaitGroup, fn flowRunFunc, contextTimeout tools.TimeDuration, threadName string, blocking bool) {
+	if blocking {
+		this.run (fn, contextTimeout, threadName)
 
 	} else {
 		// non-blocking
 
+		locWg.Add(1) // add to our sync group before we launch, so a wait can't miss it
 		go func() {
-			locWg.Add(1) // add to our sync group
 			defer locWg.Done() // make sure we always fire this
-			defer this.recover()
+			this.run (fn, contextTimeout, threadName)
+		}()
+	}
+}
 
-			// create some context for this run
-			ctx, cancel := contextTimeout.Context(threadName) // give local context to run
+// when the flow should run first
+func (this *FlowSchedule) first (cron *tools.Cron, now time.Time) time.Time {
+	start := now.Add (this.InitialDelay)
+	if cron != nil { return cron.Next (start) }
+	return start // interval flows fire right away, unless they asked to wait
+}
 
-			err := fn(ctx)
+// when the flow should run after the one that was scheduled for last
+func (this *FlowSchedule) next (cron *tools.Cron, last, now time.Time) time.Time {
+	if cron != nil { return cron.Next (now) }
 
-			// now check our context, and then error 
-			this.StackTrace(ctx, err) // record this error
-			
-			cancel() // we're done with this local context
-		}()
-	}
+	next := last.Add (this.Interval.Duration())
+	if next.Before (now) { next = now } // the last run took longer than the interval, so go again right away
+	return next
 }
 
+func (this *FlowSchedule) jitter () time.Duration {
+	if this.Jitter <= 0 { return 0 }
+	return time.Duration(rand.Int63n (int64(this.Jitter)))
+}
 
 // background flow "launcher"
 // can be used for blocking and non-blocking thread launching
-func (this *App) launcher (wg *sync.WaitGroup, fn flowRunFunc, interval, contextTimeout tools.TimeDuration, threadName string, blocking bool) {
+func (this *App) launcher (wg *sync.WaitGroup, fn flowRunFunc, schedule FlowSchedule, threadName string) {
 	defer wg.Done()
 
-	ticker := interval.Ticker() // how long between calling this function
-	done := make(chan bool) // flag so we know when to exit
+	var cron *tools.Cron
+	if len(schedule.Cron) > 0 {
+		var err error
+		cron, err = tools.ParseCron (schedule.Cron)
+		if err != nil {
+			this.StackTrace (nil, errors.Wrap (err, threadName))
+			return
+		}
+	} else if schedule.Interval <= 0 {
+		this.StackTrace (nil, errors.Errorf ("flow needs an interval or a cron schedule : %s", threadName))
+		return
+	}
+
+	if schedule.MaxRuntime <= 0 { schedule.MaxRuntime = ContextTimeout }
 
 	locWg := new(sync.WaitGroup) // local sync group for waiting for all the called functions to finish
-	locWg.Add(1)
 
-	go func() { // so that each tick enters its own thread
-		defer locWg.Done()
+	scheduled := schedule.first (cron, time.Now())
+	fireAt := scheduled.Add (schedule.jitter())
 
-		this.fire (locWg, fn, contextTimeout, threadName, blocking) // fire this right away when we start
+	timer := time.NewTimer (time.Hour)
+	timer.Stop()
+	defer timer.Stop()
 
-		for {
-			select {
-			case <- done: // flag from our channel to indicate this thread should exit
-				return 
+	for this.Running() { // monitoring this so we exit when the app does
+		if scheduled.IsZero() {
+			this.StackTrace (nil, errors.Errorf ("flow schedule never fires again : %s : %s", threadName, schedule.Cron))
+			break
+		}
 
-			case <-ticker.C: // every time the ticker fires, we enter here
-				this.fire (locWg, fn, contextTimeout, threadName, blocking)
+		if wait := time.Until (fireAt); wait > 0 {
+			timer.Reset (wait)
+			select {
+			case <-this.ctx.Done(): // we're shutting down, don't start anything new
+			case <-timer.C:
 			}
+			continue
 		}
-	}()
 
-	for this.Running { // monitoring thread
-		time.Sleep(time.Second)
-	}
+		this.fire (locWg, fn, schedule.MaxRuntime, threadName, schedule.Blocking)
 
-	ticker.Stop()
-	
-	done <- true // flag to exit our local thread
+		scheduled = schedule.next (cron, scheduled, time.Now())
+		fireAt = scheduled.Add (schedule.jitter())
+	}
 	
 	locWg.Wait() // wait for our launched threads to finish
 }
 
+// fires a function on whatever schedule is passed in
+func (this *App) FlowLaunchSchedule (wg *sync.WaitGroup, fn flowRunFunc, schedule FlowSchedule, threadName string) {
+	this.launcher (wg, fn, schedule, threadName)
+}
 
 // this fires a function at an interval. Only runs it in the one thread so this will always finish before firing again
 func (this *App) FlowLaunchBlocking (wg *sync.WaitGroup, fn flowRunFunc, interval, contextTimeout tools.TimeDuration, threadName string) {
-	this.launcher (wg, fn, interval, contextTimeout, threadName, true)
+	this.launcher (wg, fn, FlowSchedule { Interval: interval, MaxRuntime: contextTimeout, Blocking: true }, threadName)
 }
 
 // this fires a function at an interval. 
 // this one doesn't block until the previous thread finishes
 // which means this can keep launching threads if it takes a long time to finish
 func (this *App) FlowLaunch (wg *sync.WaitGroup, fn flowRunFunc, interval, contextTimeout tools.TimeDuration, threadName string) {
-	this.launcher (wg, fn, interval, contextTimeout, threadName, false)
+	this.launcher (wg, fn, FlowSchedule { Interval: interval, MaxRuntime: contextTimeout }, threadName)
 }
 
 // wrapper around a flow thread based on channel data
+// exits when the channel is closed or we're shutting down, whichever comes first
 func (this *App) FlowChan (wg *sync.WaitGroup, fn flowRunChFunc, ch chan interface{}, contextTimeout tools.TimeDuration, threadName string) {
 	defer wg.Done()
 
-	locWg := new(sync.WaitGroup) // local sync group for waiting for all the called functions to finish
-	locWg.Add(1)
-
-	go func() { // so that each tick enters its own thread
-		defer locWg.Done()
-
-		for d := range ch {
-			if d == nil { return } // nil indicates the channel was closed
-
-			// create some context for this run
-			ctx, cancel := contextTimeout.Context(threadName) // give local context to run
-			err := fn(ctx, d)
+	for {
+		var d interface{}
+		select {
+		case <-this.ctx.Done():
+			return // anything left in the channel stays there
+		case d = <-ch:
+		}
+		
+		if d == nil { return } // nil indicates the channel was closed
 
-			// now check our context, and then error 
-			this.CtxOk (ctx)
-			if err != nil {
-				this.StackTrace(ctx, err) // record this error
+		err := this.run (func (ctx context.Context) error { return fn(ctx, d) }, contextTimeout, threadName)
+		if err != nil {
+			// to help with debugging, let's include whatever this channel interface was as well
+			jstr, _ := json.Marshal(d)
+			slog.Debug(fmt.Sprintf("data object: %s\n", string(jstr)))
 
-				// to help with debugging, let's include whatever this channel interface was as well
-				jstr, _ := json.Marshal(d)
-				slog.Debug(fmt.Sprintf("data object: %s\n", string(jstr)))
-				time.Sleep(time.Second * 5) // don't hammer these errors if something is wrong
+			select { // don't hammer these errors if something is wrong
+			case <-this.ctx.Done():
+			case <-time.After (time.Second * 5):
 			}
-			
-			cancel() // we're done with this local context
 		}
-	}()
-
-	
-	locWg.Wait() // wait for the channel be closed, which causes it to exit naturally
+	}
 }
@@ -0,0 +1,128 @@
+/** ****************************************************************************************************************** **
+	Keeps track of how each flow is doing, so we can tell when one has stalled without digging through the logs
+
+** ****************************************************************************************************************** **/
+
+package cmd
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// what we know about a flow, by thread name
+type FlowStat struct {
+	Name string
+	Running int // how many runs are going right now
+	Runs, Errors, Panics int64
+	Skipped int64 // ticks where it wasn't our turn, like leader only flows on a follower. these aren't counted as runs
+	LastStart, LastSuccess, LastError, LastSkipped *time.Time `json:",omitempty"`
+	LastErrorMessage string `json:",omitempty"`
+	AvgSeconds float64 // of the finished runs
+	total time.Duration
+}
+
+type flowStats struct {
+	sync.Mutex
+	list map[string]*FlowStat
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// needs to be called with the lock held
+func (this *flowStats) get (threadName string) *FlowStat {
+	if this.list == nil { this.list = make(map[string]*FlowStat) }
+
+	stat, ok := this.list[threadName]
+	if !ok {
+		stat = &FlowStat { Name: threadName }
+		this.list[threadName] = stat
+	}
+	return stat
+}
+
+func (this *flowStats) start (threadName string, now time.Time) {
+	this.Lock()
+	defer this.Unlock()
+
+	stat := this.get (threadName)
+	stat.Running++
+	stat.LastStart = &now
+}
+
+func (this *flowStats) finish (threadName string, started time.Time, skipped bool, err error) {
+	now := time.Now()
+
+	this.Lock()
+	defer this.Unlock()
+
+	stat := this.get (threadName)
+	stat.Running--
+
+	if skipped {
+		stat.Skipped++
+		stat.LastSkipped = &now
+		return // it didn't do anything, so it can't count as a success
+	}
+
+	stat.Runs++
+	stat.total += now.Sub (started)
+	stat.AvgSeconds = stat.total.Seconds() / float64(stat.Runs)
+
+	if err != nil {
+		stat.Errors++
+		stat.LastError = &now
+		stat.LastErrorMessage = err.Error()
+	} else {
+		stat.LastSuccess = &now
+	}
+}
+
+func (this *flowStats) panicked (threadName string) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.get (threadName).Panics++
+}
+
+// names of the flows that have a run going
+func (this *flowStats) running () []string {
+	this.Lock()
+	defer this.Unlock()
+
+	ret := make([]string, 0)
+	for name, stat := range this.list {
+		if stat.Running > 0 { ret = append (ret, name) }
+	}
+	sort.Strings (ret)
+	return ret
+}
+
+// copy of everything, sorted by name
+func (this *flowStats) all () []FlowStat {
+	this.Lock()
+	defer this.Unlock()
+
+	ret := make([]FlowStat, 0, len(this.list))
+	for _, stat := range this.list {
+		ret = append (ret, *stat)
+	}
+	sort.Slice (ret, func (i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// how each of our flows is doing
+func (this *App) FlowStats () []FlowStat {
+	return this.flows.all()
+}
@@ -0,0 +1,92 @@
+/** ****************************************************************************************************************** **
+	Leader election for flows that should only ever run in one pod at a time
+
+** ****************************************************************************************************************** **/
+
+package cmd
+
+import (
+	"coldbrew/db"
+	"coldbrew/tools"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"context"
+	"log/slog"
+	"sync"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// how often we try to become the leader, this is also about how long a failover takes
+var leaderInterval tools.TimeDuration = 5
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// sets up the election, every pod running the same jobs needs to use the same lock id
+func (this *App) InitLeader (pool *pgxpool.Pool, lockId int64) {
+	this.leader = db.NewLeader (pool, lockId)
+}
+
+// keeps trying to become the leader, or checking that we still are, until we're shutting down
+func (this *App) LeaderElection (wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer this.leader.Close() // so the next one can take over right away
+
+	ticker := leaderInterval.Ticker()
+	defer ticker.Stop()
+
+	for this.Running() {
+		ctx, cancel := leaderInterval.ContextFrom (this.ctx, "leaderElection")
+		was := this.leader.IsLeader()
+
+		is, err := this.leader.Elect (ctx)
+		this.StackTrace (ctx, err)
+		cancel()
+
+		if is && was == false {
+			slog.Info ("became the leader")
+		} else if was && is == false {
+			slog.Warn ("lost leadership")
+		}
+
+		select {
+		case <-this.ctx.Done(): // shutting down, let the lock go
+		case <-ticker.C:
+		}
+	}
+}
+
+// true when this pod currently holds the leader lock
+func (this *App) IsLeader () bool {
+	if this.leader == nil { return false } // we're not part of an election
+	return this.leader.IsLeader()
+}
+
+// wraps a flow so it only runs on the leader, everyone else just skips the tick
+// the run is cancelled if we lose the lock part way through, someone else is about to start doing the same thing
+func (this *App) LeaderOnly (fn flowRunFunc) flowRunFunc {
+	return func (ctx context.Context) error {
+		if this.leader == nil { return ErrFlowSkipped } // we're not part of an election
+		term := this.leader.Term()
+		if term == nil { return ErrFlowSkipped }
+
+		ctx, cancel := context.WithCancel (ctx)
+		defer cancel()
+
+		go func() {
+			select {
+			case <-term:
+				slog.Warn ("lost leadership during a run, stopping it")
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		return fn (ctx)
+	}
+}
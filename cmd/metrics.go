@@ -0,0 +1,175 @@
+/** ****************************************************************************************************************** **
+	Prometheus metrics shared by all the binaries, these get served at /metrics from Routes
+
+** ****************************************************************************************************************** **/
+
+package cmd
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+const metricsNamespace = "coldbrew"
+
+var (
+	MetricEmailsQueued = promauto.NewCounterVec (prometheus.CounterOpts {
+		Namespace: metricsNamespace, Name: "emails_queued_total", Help: "Emails scheduled to be sent",
+	}, []string { "mailman", "template" })
+
+	MetricEmailsSent = promauto.NewCounterVec (prometheus.CounterOpts {
+		Namespace: metricsNamespace, Name: "emails_sent_total", Help: "Emails handed off to the provider",
+	}, []string { "mailman", "template" })
+
+	// outcome is either retry or dead
+	MetricEmailsFailed = promauto.NewCounterVec (prometheus.CounterOpts {
+		Namespace: metricsNamespace, Name: "emails_failed_total", Help: "Failed send attempts",
+	}, []string { "mailman", "template", "outcome" })
+
+	// scope is mailman, domain or account
+	MetricEmailsCapped = promauto.NewCounterVec (prometheus.CounterOpts {
+		Namespace: metricsNamespace, Name: "emails_capped_total", Help: "Emails pushed back because a send cap was full",
+	}, []string { "scope" })
+
+	MetricWebhookEvents = promauto.NewCounterVec (prometheus.CounterOpts {
+		Namespace: metricsNamespace, Name: "webhook_events_total", Help: "Events posted to us by the email providers",
+	}, []string { "provider", "status" })
+
+	MetricValidations = promauto.NewCounterVec (prometheus.CounterOpts {
+		Namespace: metricsNamespace, Name: "email_validations_total", Help: "Email address validations by result",
+	}, []string { "result" })
+
+	// action is rescued (out of spam), opened, clicked or replied
+	MetricWarmbot = promauto.NewCounterVec (prometheus.CounterOpts {
+		Namespace: metricsNamespace, Name: "warmbot_actions_total", Help: "What the warmbot did with our emails in the seed inboxes",
+	}, []string { "action" })
+
+	// status is replied or auto_reply, or bounce and unsubscribe for the ones waiting on review
+	MetricReplies = promauto.NewCounterVec (prometheus.CounterOpts {
+		Namespace: metricsNamespace, Name: "replies_total", Help: "Replies to our emails read out of the reply mailboxes",
+	}, []string { "status" })
+
+	metricHttpDuration = promauto.NewHistogramVec (prometheus.HistogramOpts {
+		Namespace: metricsNamespace, Name: "http_request_duration_seconds", Help: "How long our routes take to respond",
+		Buckets: prometheus.DefBuckets,
+	}, []string { "method", "route", "status" })
+)
+
+// how long a scrape gets to read the queue from the database
+const metricsQueueTimeout = time.Second * 5
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// reads the pool stats every time we're scraped
+type poolCollector struct {
+	pool *pgxpool.Pool
+	acquired, idle, total, max, acquires, emptyAcquires, canceledAcquires, acquireSeconds *prometheus.Desc
+}
+
+func (this *poolCollector) Describe (ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect (this, ch)
+}
+
+func (this *poolCollector) Collect (ch chan<- prometheus.Metric) {
+	stat := this.pool.Stat()
+
+	ch <- prometheus.MustNewConstMetric (this.acquired, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric (this.idle, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric (this.total, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric (this.max, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric (this.acquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric (this.emptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric (this.canceledAcquires, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric (this.acquireSeconds, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+// asks the database how many emails are waiting every time we're scraped
+type queueCollector struct {
+	fn func (context.Context) (queued, overdue int64, err error)
+	queued, overdue *prometheus.Desc
+}
+
+func (this *queueCollector) Describe (ch chan<- *prometheus.Desc) {
+	ch <- this.queued
+	ch <- this.overdue
+}
+
+func (this *queueCollector) Collect (ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout (context.Background(), metricsQueueTimeout)
+	defer cancel()
+
+	queued, overdue, err := this.fn (ctx)
+	if err != nil {
+		slog.Warn ("reading the queue for metrics", slog.String("error", err.Error()))
+		return // we just skip these for this scrape
+	}
+
+	ch <- prometheus.MustNewConstMetric (this.queued, prometheus.GaugeValue, float64(queued))
+	ch <- prometheus.MustNewConstMetric (this.overdue, prometheus.GaugeValue, float64(overdue))
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// times every request by the route it matched, not the path, so ids don't blow up the label count
+func (this *App) metricsMiddleware (c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if fe, ok := err.(*fiber.Error); ok { 
+		status = fe.Code 
+	} else if err != nil {
+		status = fiber.StatusInternalServerError // the error handler hasn't set it yet
+	}
+
+	metricHttpDuration.WithLabelValues (c.Method(), c.Route().Path, strconv.Itoa(status)).Observe (time.Since(start).Seconds())
+	return err
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// exposes the database pool stats, the name tells the pools apart if a binary has more than one
+func (this *App) MetricsPool (name string, pool *pgxpool.Pool) {
+	labels := prometheus.Labels { "pool": name }
+	desc := func (metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc (prometheus.BuildFQName (metricsNamespace, "db_pool", metric), help, nil, labels)
+	}
+
+	prometheus.MustRegister (&poolCollector {
+		pool: pool,
+		acquired: desc ("acquired_conns", "Connections currently in use"),
+		idle: desc ("idle_conns", "Connections sitting idle"),
+		total: desc ("total_conns", "All the connections in the pool"),
+		max: desc ("max_conns", "Most connections the pool will open"),
+		acquires: desc ("acquires_total", "Connections acquired from the pool"),
+		emptyAcquires: desc ("empty_acquires_total", "Acquires that had to wait because the pool was empty"),
+		canceledAcquires: desc ("canceled_acquires_total", "Acquires that were canceled by their context"),
+		acquireSeconds: desc ("acquire_seconds_total", "Time spent waiting on connections"),
+	})
+}
+
+// exposes the send queue, the function is called on every scrape so keep it cheap
+func (this *App) MetricsQueue (fn func (context.Context) (queued, overdue int64, err error)) {
+	prometheus.MustRegister (&queueCollector {
+		fn: fn,
+		queued: prometheus.NewDesc (prometheus.BuildFQName (metricsNamespace, "emails", "queued"), "Emails waiting to be sent", nil, nil),
+		overdue: prometheus.NewDesc (prometheus.BuildFQName (metricsNamespace, "emails", "overdue"), "Emails that should have been sent by now", nil, nil),
+	})
+}
@@ -1,16 +1,22 @@
 /** ****************************************************************************************************************** **
-	Flow logic for sending emails. right now it's just one at a time and database heavy
-	Could use caching
+	Flow logic for sending emails. Emails are claimed in batches with a lease, so any number of workers
+	across any number of pods can run this at the same time
 	
 ** ****************************************************************************************************************** **/
 
 package main
 
 import (
+	"coldbrew/cmd"
 	"coldbrew/db/postgres"
-	"coldbrew/tools/sendgrid"
+	"coldbrew/tools"
+	"coldbrew/tools/mailer"
+
+	"github.com/pkg/errors"
 
 	"context"
+	"log/slog"
+	"math/rand"
 	"time"
 )
 
@@ -18,6 +24,42 @@ import (
  //----- CONSTS ----------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
 
+const (
+	defaultSendWorkers	= 4
+	defaultSendBatch	= 10
+
+	// how long a claimed email is ours, longer than the flow's context so we never lose one mid-send
+	sendLease			= time.Minute * 2
+	sendTimeout			tools.TimeDuration = 30
+
+	// once the provider has an email we try this hard to record it, otherwise the lease expires and it goes out again
+	sentSaveTimeout		= time.Second * 10
+	sentSaveAttempts	= 3
+
+	// retries back off from a minute, doubling each time, this many attempts is about 2 hours before we give up
+	maxSendAttempts		= 8
+	maxSendBackoff		= time.Hour * 2
+
+	// an email this far past its target time counts as overdue in the metrics
+	emailOverdue		= time.Minute * 5
+
+	// how long an email waits when a cap is full before we try it again
+	cappedHourlyWait	= time.Minute * 5
+	cappedDailyWait		= time.Minute * 30
+)
+
+// how long to wait before trying again, with some jitter so a provider outage doesn't retry everything at once
+func sendBackoff (attempts int) time.Duration {
+	if attempts < 1 { attempts = 1 }
+
+	backoff := maxSendBackoff
+	if attempts < 10 { // past this we'd overflow before hitting the max anyway
+		backoff = min(time.Minute << (attempts - 1), maxSendBackoff)
+	}
+
+	return backoff + time.Duration(rand.Int63n (int64(backoff / 10) + 1))
+}
+
 // i'm doing it this way to try to reduce the number of functions in our app class
 // i think it helps keep the code a little cleaner without having to do the hassle of moving this
 // flow stuff to yet another folder
@@ -25,16 +67,8 @@ type flowEmailSend struct {
 	*app
 }
 
-// looks in the database for emails that need to be sent
-func (this *flowEmailSend) emails (ctx context.Context) error {
-	// find the next email
-	email, err := this.db.EmailsToSend (ctx)
-	if err != nil { return err }
-	if email == nil { 
-		time.Sleep(time.Second * 3) // give a little break as there was nothing to do last time
-		return nil // we're good, just nothing needs to be sent
-	}
-	
+// sends a single email that we've claimed, this is one attempt
+func (this *flowEmailSend) email (ctx context.Context, email *postgres.Email, mailman *postgres.Mailman) error {
 	// we need to get all this data about the email
 	user, err := this.db.User (ctx, email.User)
 	if err != nil { return err }
@@ -43,10 +77,6 @@ func (this *flowEmailSend) emails (ctx context.Context) error {
 	template, err := this.db.Template (ctx, email.Template)
 	if err != nil { return err }
 
-	// now the mailman for sending
-	mailman, err := this.db.Mailman (ctx, email.Mailman)
-	if err != nil { return err }
-
 	// we have what we need, let's generate the text
 	htmlBody := ""
 	textBody, err := template.GenerateTextBody(cfg.ApiUrl, user)
@@ -58,21 +88,132 @@ func (this *flowEmailSend) emails (ctx context.Context) error {
 		htmlBody = body // copy this over
 	}
 
-	// record this as sent in the database, so we don't keep sending the user emails
-	if err := this.db.EmailSent (ctx, email.Id); err != nil { return err }
+	subject, err := template.GenerateSubject(cfg.ApiUrl, user)
+	if err != nil { return err }
 
 	// do a check to make sure this user hasn't changed their status, i don't think this should happen but wanted to check
-	if user.Mask & postgres.UserMask_doNotEmail > 0 { return nil } // just don't send it, mark the email as sent tho
+	if user.Mask & postgres.UserMask_doNotEmail > 0 { 
+		return this.db.EmailSent (ctx, email.Id, "") // just don't send it, mark the email as sent tho
+	}
+
+	sender, err := newSender (mailman)
+	if err != nil { return err }
+
+	msg := newMessage (email, mailman, user, subject, textBody, htmlBody)
 
 	// we're finally ready to send this
-	go func() { // this creates its own context, so just go with that
-		err := sendgrid.SendEmail (mailman.Attr.APIToken.String(), user.Email.String(), mailman.Attr.Category.String(),
-			template.GenerateSubject(), textBody, htmlBody, mailman.Attr.IpPool.String(), mailman.Attr.FromEmail.String(),
-			mailman.Attr.FromName.String(), mailman.Attr.ReplyName.String(), mailman.Attr.ReplyEmail.String())
-		if err != nil {
-			this.StackTrace (ctx, err) // record this
+	sendCtx, cancel := sendTimeout.ContextFrom (ctx, "sendEmail")
+	defer cancel()
+
+	messageId, err := sender.Send (sendCtx, msg)
+	if err != nil { return err }
+
+	// the webhooks match on this, so we need it saved before they show up
+	if len(messageId) == 0 {
+		slog.Warn ("provider didn't return a message id", slog.String("email", email.Id.String()), slog.String("mailman", mailman.Id.String()))
+	}
+
+	cmd.MetricEmailsSent.WithLabelValues (email.Mailman.String(), email.Template.String()).Inc()
+	this.sent (ctx, email, messageId)
+	return nil // it's gone, nothing past this point can send it back for a retry
+}
+
+// records that the provider has it. a drain or losing the leader lock cancels ctx, but that can't stop us from saving this
+// so it gets its own context, and a couple more tries for anything the database was having a moment over
+func (this *flowEmailSend) sent (ctx context.Context, email *postgres.Email, messageId string) {
+	saveCtx, cancel := context.WithTimeout (context.WithoutCancel (ctx), sentSaveTimeout)
+	defer cancel()
+
+	var err error
+	for i := 0; i < sentSaveAttempts && saveCtx.Err() == nil; i++ {
+		if i > 0 { time.Sleep (time.Second * time.Duration(i)) }
+		if err = this.db.EmailSent (saveCtx, email.Id, messageId); err == nil { return }
+	}
+
+	this.StackTrace (saveCtx, err)
+	slog.Error ("email was sent but we couldn't record it, it could go out again once the lease expires", 
+				slog.String("email", email.Id.String()), slog.String("messageId", messageId))
+}
+
+// pushes the time forward into the mailman's send window for this user, if it has one
+// a retry shouldn't land at 3am any more than the first attempt should
+func (this *flowEmailSend) window (ctx context.Context, email *postgres.Email, mailman *postgres.Mailman, at time.Time) time.Time {
+	if mailman == nil || mailman.Attr.SendWindow == nil { return at }
+
+	var loc *time.Location
+	user, err := this.db.User (ctx, email.User)
+	this.StackTrace (ctx, err) // we can still use the window's zone
+	if user != nil { loc = user.Attr.Location() }
+
+	if next := mailman.Attr.SendWindow.Next (at, loc); next.IsZero() == false { return next }
+	return at // Valid doesn't let a window that never opens get saved
+}
+
+// records a failed attempt, either to try again later or to give up on
+func (this *flowEmailSend) failed (ctx context.Context, email *postgres.Email, mailman *postgres.Mailman, sendErr error) error {
+	reason := mailer.FailReason (sendErr) // this ends up in the admin api, so only what's safe to show
+
+	if mailer.Retryable (sendErr) && email.Attempts < maxSendAttempts {
+		cmd.MetricEmailsFailed.WithLabelValues (email.Mailman.String(), email.Template.String(), "retry").Inc()
+		at := this.window (ctx, email, mailman, time.Now().Add (sendBackoff (email.Attempts)))
+		return this.db.EmailRetry (ctx, email.Id, reason, at)
+	}
+
+	cmd.MetricEmailsFailed.WithLabelValues (email.Mailman.String(), email.Template.String(), "dead").Inc()
+	slog.Warn ("email is dead", slog.String("email", email.Id.String()), slog.Int("attempts", email.Attempts), slog.String("error", reason))
+	return this.db.EmailDead (ctx, email.Id, reason)
+}
+
+// a cap is full, so push this one back until there should be room again
+func (this *flowEmailSend) capped (ctx context.Context, email *postgres.Email, mailman *postgres.Mailman, capped *postgres.SendCapped) {
+	wait := cappedDailyWait
+	if capped.Hourly { wait = cappedHourlyWait }
+
+	cmd.MetricEmailsCapped.WithLabelValues (string(capped.Scope)).Inc()
+	slog.Info ("send cap reached", slog.String("email", email.Id.String()), slog.String("scope", string(capped.Scope)), 
+				slog.String("key", capped.Key), slog.Bool("hourly", capped.Hourly))
+
+	this.StackTrace (ctx, this.db.EmailCapped (ctx, email.Id, this.window (ctx, email, mailman, time.Now().Add (wait))))
+}
+
+// claims batches of emails that need to be sent until there aren't any left
+// once we're shutting down we finish the batch we have but don't claim another one
+func (this *flowEmailSend) emails (ctx context.Context) error {
+	for ctx.Err() == nil && this.Running() {
+		emails, err := this.db.EmailsClaim (ctx, this.owner, cfg.SendBatch, sendLease)
+		if err != nil { return err }
+
+		for _, email := range emails {
+			if ctx.Err() != nil { return nil } // out of time, anything left gets re-claimed once the lease expires
+
+			mailman, err := this.db.Mailman (ctx, email.Mailman)
+			if err != nil { return err }
+			if mailman == nil {
+				this.StackTrace (ctx, this.failed (ctx, email, nil, errors.Errorf ("mailman not found : %s", email.Mailman)))
+				continue
+			}
+
+			// every claimed email counts as an attempt, errors here just leave it to be re-claimed once the lease expires
+			// this is also where the caps are enforced, so nothing we scheduled can go over them
+			ours, capped, err := this.db.EmailSending (ctx, email, mailman, mailman.SendCaps (cfg.DomainCaps, cfg.AccountCaps), this.owner, sendLease)
+			if err != nil { return err }
+			if capped != nil {
+				this.capped (ctx, email, mailman, capped)
+				continue
+			}
+			if ours == false {
+				slog.Warn ("lost the lease on an email before sending it", slog.String("email", email.Id.String()))
+				continue // someone else has it now
+			}
+
+			if err := this.email (ctx, email, mailman); err != nil {
+				this.StackTrace (ctx, err) // record this
+				this.StackTrace (ctx, this.failed (ctx, email, mailman, err))
+			}
 		}
-	}()
+
+		if len(emails) < cfg.SendBatch { return nil } // we're caught up
+	}
 
 	return nil // we're good
 }
@@ -86,3 +227,12 @@ func (this *app) flowEmailSend (ctx context.Context) error {
 
 	return flow.emails (ctx)
 }
+
+// clears out the send attempts that are too old to count towards the caps
+func (this *app) flowPruneSendAttempts (ctx context.Context) error {
+	cnt, err := this.db.SendAttemptsPrune (ctx)
+	if err != nil { return err }
+
+	slog.Debug ("pruned send attempts", slog.Int64("count", cnt))
+	return nil
+}
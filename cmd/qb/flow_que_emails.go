@@ -6,6 +6,7 @@
 package main
 
 import (
+	"coldbrew/cmd"
 	"coldbrew/db/postgres"
 	
 	"github.com/pkg/errors"
@@ -27,19 +28,114 @@ type flowQueEmails struct {
 	*app
 }
 
-// for when we want to schedule text based emails to start things off
-func (this *flowQueEmails) textWarmup (ctx context.Context, mailman *postgres.Mailman) error {
+// the plan the mailman is on, the default one when they don't have one
+func (this *flowQueEmails) warmupPlan (ctx context.Context, warmup *postgres.MailmanWarmup) (*postgres.WarmupPlan, error) {
+	if warmup.Plan != nil {
+		plan, err := this.db.WarmupPlan (ctx, warmup.Plan)
+		if err != nil || plan != nil { return plan, err }
+	}
+
+	plan, err := this.db.WarmupPlanByName (ctx, postgres.WarmupPlan_default)
+	if err != nil || plan != nil { return plan, err }
+
+	return postgres.DefaultWarmupPlan(), nil // nothing in the database, so the built in one
+}
+
+// starts the warmup for a mailman that doesn't have one yet, nil if they're already warm
+func (this *flowQueEmails) warmupStart (ctx context.Context, mailman *postgres.Mailman) (*postgres.MailmanWarmup, error) {
+	warm := postgres.MailmanMask_textWarm | postgres.MailmanMask_htmlWarm
+	if mailman.Mask & warm == warm { return nil, nil } // warmed up before we had plans
+
+	plan, err := this.db.WarmupPlanByName (ctx, postgres.WarmupPlan_default)
+	if err != nil { return nil, err }
+
+	warmup := &postgres.MailmanWarmup { Mailman: mailman.Id }
+	if plan != nil { warmup.Plan = plan.Id }
+
+	if err := this.db.MailmanWarmupStart (ctx, warmup); err != nil { return nil, err }
+	if err := this.db.MailmanSetMask (ctx, mailman, postgres.MailmanMask_textWarm); err != nil { return nil, err }
+
+	slog.Info ("starting warmup", slog.String("mailman", mailman.Id.String()))
+	return warmup, nil
+}
+
+// moves the mailman along their plan and queues up the next day of warmup emails
+func (this *flowQueEmails) warmup (ctx context.Context, mailman *postgres.Mailman, warmup *postgres.MailmanWarmup) error {
+	plan, err := this.warmupPlan (ctx, warmup)
+	if err != nil { return err }
+
+	if warmup.Phase >= len(plan.Attr.Phases) { warmup.Phase = len(plan.Attr.Phases) - 1 } // the plan got shorter
+
+	// see if we're done with this phase
+	phase := plan.Attr.Phases[warmup.Phase]
+	stats, err := this.db.MailmanWarmupStats (ctx, mailman.Id, warmup.PhaseStarted)
+	if err != nil { return err }
+
+	done, reason := phase.Done (warmup.PhaseStarted, time.Now(), stats)
+	warmup.Status.Set (reason)
+
+	if done {
+		warmup.Phase++
+		warmup.PhaseStarted = time.Now()
+
+		if warmup.Phase >= len(plan.Attr.Phases) {
+			// that was the last one, we're warm
+			now := time.Now()
+			warmup.Completed = &now
+			warmup.Phase = len(plan.Attr.Phases) - 1 // stay on the last one, so the api can still show it
+			slog.Info ("mailman is warmed up", slog.String("mailman", mailman.Id.String()), slog.String("plan", plan.Name.String()))
+
+			if err := this.db.MailmanWarmupUpdate (ctx, warmup); err != nil { return err }
+			return this.db.MailmanSetMask (ctx, mailman, postgres.MailmanMask_textWarm | postgres.MailmanMask_htmlWarm)
+		}
+
+		phase = plan.Attr.Phases[warmup.Phase]
+	}
+
+	if err := this.db.MailmanWarmupUpdate (ctx, warmup); err != nil { return err }
+
+	// the send flow includes the html when this is set
+	if phase.Html {
+		err = this.db.MailmanSetMask (ctx, mailman, postgres.MailmanMask_htmlWarm)
+	} else {
+		err = this.db.MailmanRemoveMask (ctx, mailman, postgres.MailmanMask_htmlWarm)
+	}
+	if err != nil { return err }
+
+	return this.warmupQue (ctx, mailman, phase)
+}
+
+// moves the target into the mailman's send window for this user, nothing to do when they don't have one
+// each zone keeps its own pace so everyone waiting on the same morning doesn't land at once
+func (this *flowQueEmails) window (mailman *postgres.Mailman, user *postgres.User, target time.Time, frequency time.Duration, 
+									zoneNext map[string]time.Time) (time.Time, error) {
+	window := mailman.Attr.SendWindow
+	if window == nil { return target, nil }
+
+	loc := window.Location (user.Attr.Location())
+	if zoneNext[loc.String()].After (target) { 
+		target = zoneNext[loc.String()].Add (time.Second * time.Duration(rand.Intn(20))) // jitter, same as the rest
+	}
+
+	target = window.Next (target, loc) // anything we land on is inside the window
+	if target.IsZero() { return target, errors.Errorf ("send window never opens for mailman : %s", mailman.Id) }
+
+	zoneNext[loc.String()] = target.Add (frequency)
+	return target, nil
+}
+
+// schedules a day of warmup emails for the phase
+func (this *flowQueEmails) warmupQue (ctx context.Context, mailman *postgres.Mailman, phase postgres.WarmupPhase) error {
 	// get our warmup users
 	users, err := this.db.UsersFromMask (ctx, postgres.UserMask_warmup)
 	if err != nil { return err }
 
 	if len(users) < 5 { return errors.Errorf("no enough warmup users. use at least 5") }
 
-	// for this we que all of them up, 1 hour apart
 	// let's find our warmup template
 	templates, err := this.db.TemplateList (ctx)
 	if err != nil { return err }
-	if len(templates) == 0 { return errors.Errorf("No tempaltes found for initial text warmup") }
+	if len(templates) == 0 { return errors.Errorf("No tempaltes found for warmup") }
 
 	target := templates[0] // just default to the first
 
@@ -51,37 +147,34 @@ func (this *flowQueEmails) textWarmup (ctx context.Context, mailman *postgres.Ma
 		}
 	}
 
-	// before we schedule the emails, let's make sure we can update the mask
-	if mailman.Mask & postgres.MailmanMask_textWarm == 0 {
-		if err := this.db.MailmanSetMask (ctx, mailman, postgres.MailmanMask_textWarm); err != nil { return err }
-	} else {
-		// set the html version now
-		if err := this.db.MailmanSetMask (ctx, mailman, postgres.MailmanMask_htmlWarm); err != nil { return err }
-	}
-
-	// que up the warmup emails
-	targetToQue := 30
+	// spread the day's emails out, with some jitter
+	frequency := (time.Hour * 24) / time.Duration(phase.PerDay)
 	nextEmail := time.Now() // start right away for this one
-	for targetToQue > 0 {
-		for _, user := range users {
-			targetToQue--
-
-			email := &postgres.Email {
-				Mailman: mailman.Id,
-				Template: target.Id,
-				User: user.Id,
-				Target: nextEmail,
-			}
-
-			// now insert it
-			if err := this.db.EmailInsert (ctx, email); err != nil { return err }
-
-			// these are once an hour or so, i think we want jitter in there
-			nextEmail = nextEmail.Add(time.Minute * time.Duration(50 + rand.Intn(10)))
+	zoneNext := make(map[string]time.Time) // warmups stick to the send window too
+
+	for i := 0; i < phase.PerDay; i++ {
+		user := users[i % len(users)]
+
+		sendAt, err := this.window (mailman, user, nextEmail, frequency, zoneNext)
+		if err != nil { return err }
+
+		email := &postgres.Email {
+			Mailman: mailman.Id,
+			Template: target.Id,
+			User: user.Id,
+			Target: sendAt,
+		}
+
+		// now insert it
+		if err := this.db.EmailInsert (ctx, email); err != nil { return err }
+		cmd.MetricEmailsQueued.WithLabelValues (email.Mailman.String(), email.Template.String()).Inc()
+
+		// same as the cold emails, waiting on the window doesn't change our pace for everyone else
+		if sendAt.After (nextEmail) == false {
+			nextEmail = nextEmail.Add(frequency - frequency / 10 + time.Duration(rand.Int63n (int64(frequency / 5) + 1)))
 		}
 	}
 
-	// we're good, this mailman has their plain text warmups set
 	return nil
 }
 
@@ -92,10 +185,17 @@ func (this *flowQueEmails) mailman (ctx context.Context, mailman *postgres.Mailm
 	cnt, err := this.db.EmailsScheduledByMailman (ctx, mailman.Id)
 	if err != nil || cnt > 0 { return err } // we're done either way
 
-	// no future scheduled emails, see what the status is
-	if mailman.Mask & (postgres.MailmanMask_textWarm | postgres.MailmanMask_htmlWarm) != (postgres.MailmanMask_textWarm | postgres.MailmanMask_htmlWarm) {
-		// we're still warming this one up
-		return this.textWarmup (ctx, mailman)
+	// no future scheduled emails, see where it is with its warmup
+	warmup, err := this.db.MailmanWarmup (ctx, mailman.Id)
+	if err != nil { return err }
+
+	if warmup == nil {
+		warmup, err = this.warmupStart (ctx, mailman)
+		if err != nil { return err }
+	}
+
+	if warmup != nil && warmup.Completed == nil {
+		return this.warmup (ctx, mailman, warmup) // we're still warming this one up
 	}
 
 	// we're warmed up, so let's find some non-warmup templates to send
@@ -112,6 +212,20 @@ func (this *flowQueEmails) mailman (ctx context.Context, mailman *postgres.Mailm
 
 	if len(targetTemplates) == 0 { return errors.Errorf("No templates found for mailman : %s", mailman.Id) }
 
+	// see how fast this mailman should be going based on how it's been doing
+	policy := mailman.Attr.ThrottlePolicy (&cfg.Throttle)
+	stats, err := this.db.MailmanStats (ctx, mailman.Id, policy.Window)
+	if err != nil { return err }
+
+	decision := policy.Decide (*stats)
+	if decision.Pause {
+		slog.Warn ("pausing mailman", slog.String("mailman", mailman.Id.String()), slog.String("reason", decision.Reason))
+		return this.db.MailmanSetMask (ctx, mailman, postgres.MailmanMask_paused)
+	}
+
+	slog.Info ("mailman send rate", slog.String("mailman", mailman.Id.String()), slog.Int("perDay", decision.PerDay), 
+				slog.String("reason", decision.Reason))
+
 	// we want a list of the users that haven't gotten an email yet
 	users, err := this.db.UsersMissing (ctx)
 	if err != nil { return err }
@@ -121,28 +235,39 @@ func (this *flowQueEmails) mailman (ctx context.Context, mailman *postgres.Mailm
 		return nil 
 	}
 
+	// only a day's worth at a time, so the throttle gets another look at it tomorrow
+	if len(users) > decision.PerDay { users = users[:decision.PerDay] }
+
 	nextEmail := time.Now() // send the next one right away
-	// get our performance for this mailman
-	frequency, err := this.db.MailmanPerformance (ctx, mailman.Id)
-	if err != nil { return err }
+	frequency := decision.Interval
+
+	// with a send window, users outside of it wait for it to open in their time zone
+	zoneNext := make(map[string]time.Time)
 
 	templateIdx := 0 // start with the first template and a/b from there
 
 	// loop through all the users we pulled in
 	for _, user := range users {
+		target, err := this.window (mailman, user, nextEmail, frequency, zoneNext)
+		if err != nil { return err }
+
 		email := &postgres.Email {
 			Mailman: mailman.Id,
 			Template: targetTemplates[templateIdx].Id,
 			User: user.Id,
-			Target: nextEmail,
+			Target: target,
 		}
 
 		// now insert it
 		if err := this.db.EmailInsert (ctx, email); err != nil { return err }
+		cmd.MetricEmailsQueued.WithLabelValues (email.Mailman.String(), email.Template.String()).Inc()
 
-		nextEmail = nextEmail.Add(frequency)
-		// i think we want jitter in there
-		nextEmail = nextEmail.Add(time.Second * time.Duration(rand.Intn(20)))
+		// when this one is waiting on the window our pace for everyone else doesn't change
+		if target.After (nextEmail) == false {
+			nextEmail = nextEmail.Add(frequency)
+			// i think we want jitter in there
+			nextEmail = nextEmail.Add(time.Second * time.Duration(rand.Intn(20)))
+		}
 
 		templateIdx++
 		if templateIdx >= len(targetTemplates) { templateIdx = 0 } // reset this
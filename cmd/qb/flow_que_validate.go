@@ -6,6 +6,7 @@
 package main
 
 import (
+	"coldbrew/cmd"
 	"coldbrew/db/postgres"
 	"coldbrew/tools/zerobounce"
 	
@@ -29,13 +30,23 @@ func (this *flowEmailValidate) validateUser (ctx context.Context, user *postgres
 
 	// make sure this email appears valid before we even bother with zerobounce
 	if user.Email.Email() == false {
+		cmd.MetricValidations.WithLabelValues ("malformed").Inc()
 		return this.db.UserSetDisabled (ctx, user) // disable them, they're not good
 	}
 
 	// validate this with our bounce config
 	if cfg.ZeroBounce.Valid() {
 		valid, typo, err := zerobounce.ValidateEmail (ctx, cfg.ZeroBounce.String(), user.Email.String())
-		if err != nil { return err }
+		if err != nil { 
+			cmd.MetricValidations.WithLabelValues ("error").Inc()
+			return err 
+		}
+
+		switch {
+		case valid: cmd.MetricValidations.WithLabelValues ("valid").Inc()
+		case len(typo) > 0: cmd.MetricValidations.WithLabelValues ("typo").Inc()
+		default: cmd.MetricValidations.WithLabelValues ("invalid").Inc()
+		}
 
 		if valid == false && len(typo) > 0 {
 			// we think there was a typo, so let's just update this user's email
@@ -53,6 +64,7 @@ func (this *flowEmailValidate) validateUser (ctx context.Context, user *postgres
 		
 	}
 	
+	cmd.MetricValidations.WithLabelValues ("skipped").Inc()
 	slog.Info ("no zero bounce api key found, all emails will be sent to")
 	return this.db.UserSetValid (ctx, user) // just say they're good
 }
@@ -0,0 +1,189 @@
+/** ****************************************************************************************************************** **
+	Flow logic for reading the replies to our emails out of each mailman's reply mailbox
+	
+** ****************************************************************************************************************** **/
+
+package main
+
+import (
+	"coldbrew/cmd"
+	"coldbrew/db/postgres"
+	"coldbrew/tools"
+	"coldbrew/tools/imap"
+	"coldbrew/tools/mailer"
+
+	"context"
+	json "github.com/json-iterator/go"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+const (
+	repliesFrequency		tools.TimeDuration = 300 // every 5 minutes
+	repliesMaxRuntime		tools.TimeDuration = 240
+	repliesMailboxTimeout	tools.TimeDuration = 60 // one slow mailbox shouldn't eat the whole run
+
+	// we look back this far each time, the events table keeps us from counting the same reply twice
+	repliesLookback			= time.Hour * 24 * 3
+)
+
+// i'm doing it this way to try to reduce the number of functions in our app class
+type flowReplies struct {
+	*app
+}
+
+// the mailbox behind the mailman's reply address, nil when they don't have one set up
+func newReplyAccount (mailman *postgres.Mailman) *imap.Account {
+	if mailman.Attr.ReplyImapHost.Valid() == false { return nil }
+
+	username := mailman.Attr.ReplyImapUser
+	if username.Valid() == false { username = mailman.Attr.ReplyEmail } // usually the same thing
+
+	return &imap.Account {
+		Host: mailman.Attr.ReplyImapHost.String(),
+		Port: mailman.Attr.ReplyImapPort,
+		Username: username.String(),
+		Password: mailman.Attr.ReplyImapPassword.String(),
+	}
+}
+
+// finds the email this is a reply to, the threading headers first and then the token in the address
+func (this *flowReplies) match (ctx context.Context, msg *mailer.Received) (*postgres.Email, error) {
+	return this.db.EmailForReply (ctx, msg.EmailId(), msg.ThreadIds(), msg.PlusTokens())
+}
+
+// bounces and unsubscribes could be wrong, so they go on the list for someone to look at instead of counting as engagement
+func (this *flowReplies) review (ctx context.Context, email *postgres.Email, msg *mailer.Received, account *imap.Account) error {
+	reply := &postgres.EmailReply {
+		Email: email.Id,
+		User: email.User,
+		Source: postgres.EmailEventSource_imap,
+		Kind: postgres.EmailReplyKind(msg.Kind()),
+		Attr: postgres.EmailReplyAttr { Headers: msg.Header },
+	}
+	reply.MessageId.Set (msg.MessageId)
+	reply.ToEmail.Set (account.Username)
+	reply.Subject.Set (msg.Subject)
+	reply.Text.Set (msg.Text)
+	if msg.From != nil { reply.FromEmail.Set (msg.From.Address) }
+
+	for _, attachment := range msg.Attachments {
+		reply.Attr.Attachments = append (reply.Attr.Attachments, postgres.EmailReplyAttachment (*attachment))
+	}
+
+	inserted, err := this.db.EmailReplyInsert (ctx, reply)
+	if err != nil || inserted == false { return err }
+
+	cmd.MetricReplies.WithLabelValues (string(reply.Kind)).Inc()
+	slog.Info ("reply needs review", slog.String("email", email.Id.String()), slog.String("kind", string(reply.Kind)), 
+				slog.String("from", reply.FromEmail.String()))
+	return nil
+}
+
+// records the reply, auto replies get their own status so they don't count as someone engaging
+func (this *flowReplies) reply (ctx context.Context, account *imap.Account, raw []byte) error {
+	msg, err := mailer.Parse (raw)
+	if err != nil { return err }
+
+	email, err := this.match (ctx, msg)
+	if err != nil || email == nil { return err }
+
+	var status postgres.EmailStatus
+	switch msg.Kind() {
+	case mailer.ReceivedKind_reply:
+		status = postgres.EmailStatus_replied
+	case mailer.ReceivedKind_autoReply:
+		status = postgres.EmailStatus_autoReply
+	default: // a bounce is never a person
+		return this.review (ctx, email, msg, account)
+	}
+
+	from := ""
+	if msg.From != nil { from = msg.From.Address }
+
+	event := &postgres.EmailEvent {
+		Provider: postgres.EmailEventSource_imap,
+		EventId: msg.MessageId,
+		MessageId: email.MessageId.String(),
+		Status: status,
+		Time: time.Now(),
+	}
+	event.Reason.Set (msg.Subject)
+	if len(event.EventId) == 0 { // the whole message is as close to an id as we're going to get
+		headers := tools.String(raw)
+		event.EventId = headers.MD5()
+	}
+	if date, err := msg.Header.Date(); err == nil { event.Time = date }
+
+	event.Raw, err = json.Marshal (map[string]string {
+		"messageId": msg.MessageId,
+		"inReplyTo": msg.InReplyTo,
+		"from": from,
+		"subject": msg.Subject,
+	})
+	if err != nil { return err }
+
+	inserted, err := this.db.EmailReplyRecord (ctx, email, event)
+	if err != nil || inserted == false { return err }
+
+	cmd.MetricReplies.WithLabelValues (string(status)).Inc()
+	slog.Info ("reply", slog.String("email", email.Id.String()), slog.String("status", string(status)), slog.String("from", from))
+	return nil
+}
+
+// reads through the recent messages in the mailbox, this doesn't change anything in there
+func (this *flowReplies) mailbox (ctx context.Context, account *imap.Account) error {
+	c, err := account.Dial (ctx)
+	if err != nil { return err }
+	defer c.Close()
+
+	if err := c.Select (imap.Inbox); err != nil { return err }
+
+	uids, err := c.Search (imap.Since (time.Now().Add (-repliesLookback)))
+	if err != nil { return err }
+
+	for _, uid := range uids {
+		if ctx.Err() != nil { return nil } // we'll get the rest next time
+
+		// the whole thing, a bounce only has our email id in the copy it attaches and unsubscribes are in what they wrote
+		raw, err := c.Fetch (uid)
+		if err != nil { return err }
+
+		this.StackTrace (ctx, this.reply (ctx, account, raw)) // one bad email shouldn't stop the rest
+	}
+
+	return nil
+}
+
+// checks the reply mailbox of every mailman that has one
+func (this *app) flowReplies (ctx context.Context) error {
+	flow := &flowReplies { app: this } // share the pointer
+
+	mailmen, err := this.db.MailmanListAll (ctx) // paused mailmen still get replies
+	if err != nil { return err }
+
+	// mailmen can share a reply mailbox, no reason to read it more than once
+	seen := make(map[string]bool)
+
+	for _, mailman := range mailmen {
+		if ctx.Err() != nil { return nil }
+
+		account := newReplyAccount (mailman)
+		if account == nil { continue }
+
+		key := strings.ToLower (account.Host + "|" + account.Username)
+		if seen[key] { continue }
+		seen[key] = true
+
+		mailboxCtx, cancel := repliesMailboxTimeout.ContextFrom (ctx, "replies")
+		this.StackTrace (ctx, flow.mailbox (mailboxCtx, account))
+		cancel()
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+/** ****************************************************************************************************************** **
+	Flow logic for engaging with our warmup emails from the seed inboxes, see tools/warmbot
+	
+** ****************************************************************************************************************** **/
+
+package main
+
+import (
+	"coldbrew/cmd"
+	"coldbrew/db/postgres"
+	"coldbrew/tools"
+	"coldbrew/tools/warmbot"
+
+	"github.com/google/uuid"
+
+	"context"
+	"log/slog"
+	"net/url"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+const (
+	warmbotFrequency	tools.TimeDuration = 300 // every 5 minutes, people don't answer their email right away either
+	warmbotMaxRuntime	tools.TimeDuration = 240
+	warmbotSeedTimeout	tools.TimeDuration = 60 // one slow inbox shouldn't eat the whole run
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// the address our warmup email was sent from, empty if the id isn't one of them
+func (this *app) warmupSender (ctx context.Context, emailId uuid.UUID) (string, error) {
+	email, err := this.db.Email (ctx, &emailId)
+	if err != nil || email == nil { return "", err }
+
+	user, err := this.db.User (ctx, email.User)
+	if err != nil || user == nil { return "", err }
+	if user.Mask & postgres.UserMask_warmup == 0 { return "", nil } // we don't engage with what we sent a real person
+
+	mailman, err := this.db.Mailman (ctx, email.Mailman)
+	if err != nil || mailman == nil { return "", err }
+
+	return mailman.Attr.FromEmail.String(), nil
+}
+
+// where the links we click are allowed to go
+func warmbotHosts () []string {
+	hosts := append ([]string{}, cfg.ClickHosts...)
+	if api, err := url.Parse (cfg.ApiUrl); err == nil && len(api.Hostname()) > 0 { hosts = append (hosts, api.Hostname()) }
+	return hosts
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// goes through each of the seed inboxes, one at a time
+func (this *app) flowWarmbot (ctx context.Context) error {
+	bot := &warmbot.Bot { Replies: cfg.SeedReplies, Warmup: this.warmupSender, Hosts: warmbotHosts() }
+
+	for i := range cfg.Seeds {
+		if ctx.Err() != nil { return nil } // the rest get their turn next time
+
+		seed := &cfg.Seeds[i]
+
+		seedCtx, cancel := warmbotSeedTimeout.ContextFrom (ctx, "warmbot")
+		result, err := bot.Run (seedCtx, seed)
+		cancel()
+
+		if result != nil {
+			cmd.MetricWarmbot.WithLabelValues ("rescued").Add (float64(result.Rescued))
+			for _, engagement := range result.Engaged {
+				cmd.MetricWarmbot.WithLabelValues ("opened").Inc()
+				if engagement.Clicked { cmd.MetricWarmbot.WithLabelValues ("clicked").Inc() }
+				if engagement.Replied { cmd.MetricWarmbot.WithLabelValues ("replied").Inc() }
+			}
+
+			slog.Info ("warmbot", slog.String("seed", seed.Email), slog.Int("rescued", result.Rescued), slog.Int("engaged", len(result.Engaged)), 
+						slog.Int("ignored", result.Ignored))
+		}
+
+		this.StackTrace (ctx, err) // keep going with the other seeds
+	}
+
+	return nil
+}
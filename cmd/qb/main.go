@@ -1,5 +1,5 @@
 /** ****************************************************************************************************************** **
-	This is the single thread, one pod at a time, background service for the Coldbrew app
+	This is the background service for the Coldbrew app, more than one pod can run at a time
 
 	
 ** ****************************************************************************************************************** **/
@@ -10,13 +10,18 @@ import (
 	"coldbrew/db/postgres"
 	"coldbrew/cmd"
 	"coldbrew/tools"
+	"coldbrew/tools/throttle"
+	"coldbrew/tools/warmbot"
 	
 	"github.com/jessevdk/go-flags"
+	"github.com/google/uuid"
 	
+	"context"
 	"fmt"
 	"os"
 	"sync"
 	"strings"
+	"time"
 	"log"
 	"log/slog"
 )
@@ -28,6 +33,9 @@ import (
 const serviceVersion = "0.1.0"
 const serviceName = "Coldbrew QB"
 
+// every qb pod competes for this, the scheduling flows only run on the one that has it
+const leaderLockId = 0x636f6c64627265 // "coldbre"
+
   //-------------------------------------------------------------------------------------------------------------------//
  //----- CONFIG ------------------------------------------------------------------------------------------------------//
 //-------------------------------------------------------------------------------------------------------------------//
@@ -41,6 +49,20 @@ var cfg struct {
 	cmd.CFG
 
 	ZeroBounce tools.String
+
+	// how many emails each worker claims at a time, and how many workers each pod runs
+	SendBatch, SendWorkers int
+
+	// how fast each mailman gets to send, defaults to throttle.Default(). a mailman can set its own (MailmanAttr.Throttle)
+	Throttle throttle.Policy
+
+	// hard caps shared by every mailman sending from the domain, or through the provider account (MailmanAttr.Account)
+	DomainCaps, AccountCaps map[string]postgres.SendLimit
+
+	// inboxes of our warmup users, the warmbot opens, clicks and replies to our emails from these
+	Seeds []warmbot.Seed
+	SeedReplies []string // what the replies say, defaults to warmbot.DefaultReplies
+	ClickHosts []string // the providers' click tracking hosts, the warmbot only clicks links to these and the ApiUrl
 }
 
 
@@ -96,6 +118,7 @@ type app struct {
 	cmd.App
 
 	db 		*postgres.Coldbrew
+	owner	string // unique to this pod, used for leasing emails
 }
 
   //-------------------------------------------------------------------------------------------------------------------//
@@ -120,6 +143,28 @@ func (this *app) init() (cmd.Callback, error) {
 	}
 
 	this.db = postgres.NewColdbrew(cbDB)
+	this.InitLeader (cbDB, leaderLockId)
+
+	this.MetricsPool ("coldbrew", cbDB)
+	this.MetricsQueue (func (ctx context.Context) (int64, int64, error) {
+		return this.db.EmailsQueueDepth (ctx, emailOverdue)
+	})
+
+	hostname, _ := os.Hostname()
+	this.owner = hostname + "-" + uuid.NewString()
+
+	if cfg.SendBatch <= 0 { cfg.SendBatch = defaultSendBatch }
+	if cfg.SendWorkers <= 0 { cfg.SendWorkers = defaultSendWorkers }
+
+	// the domain caps are matched on the lowercase from domain
+	domainCaps := make(map[string]postgres.SendLimit, len(cfg.DomainCaps))
+	for domain, limit := range cfg.DomainCaps {
+		domainCaps[strings.ToLower (domain)] = limit
+	}
+	cfg.DomainCaps = domainCaps
+
+	if len(cfg.Throttle.Ramp) == 0 { cfg.Throttle = throttle.Default() }
+	if err := cfg.Throttle.Valid(); err != nil { return cmdFn, err }
 
 	return func() error {
 		this.db.DB.Close() // close our database as well
@@ -172,19 +217,49 @@ func main() {
 	
 	wg := new(sync.WaitGroup)
 
+	wg.Add(1)
+	go app.LeaderElection (wg) // decides which pod runs the scheduling flows
+
 	// launch our task to check for new instant game combinations
 	var emailValidateFrequency tools.TimeDuration = 3 // pretty quick validate the email address with zero bounce
 	var emailSendFrequency tools.TimeDuration = 5 // pretty quick check for emails that need to be sent
 	var queEmailFrequency tools.TimeDuration = 60 // once a minute, look for emails that need to get queued
 	
 	wg.Add(1)
-	go app.FlowLaunchBlocking (wg, app.flowEmailValidate, emailValidateFrequency, cmd.ContextTimeout, "flowEmailValidate") // checks for emails that need to be validated
+	go app.FlowLaunchBlocking (wg, app.LeaderOnly (app.flowEmailValidate), emailValidateFrequency, cmd.ContextTimeout, "flowEmailValidate") // checks for emails that need to be validated, only the leader does this so we don't pay twice
+
+	for i := 0; i < cfg.SendWorkers; i++ {
+		wg.Add(1)
+		go app.FlowLaunchBlocking (wg, app.flowEmailSend, emailSendFrequency, cmd.ContextTimeout, fmt.Sprintf("flowEmailSend-%d", i)) // checks for emails that need to be sent
+	}
+
+	wg.Add(1)
+	go app.FlowLaunchSchedule (wg, app.LeaderOnly (app.flowQueEmails), cmd.FlowSchedule { 
+		Interval: queEmailFrequency, 
+		Jitter: time.Second * 10, 
+		Blocking: true,
+	}, "flowQueEmails") // populates emails to be sent over the next hour, only the leader does this
 
 	wg.Add(1)
-	go app.FlowLaunchBlocking (wg, app.flowEmailSend, emailSendFrequency, cmd.ContextTimeout, "flowEmailSend") // checks for emails that need to be sent
+	go app.FlowLaunchSchedule (wg, app.LeaderOnly (app.flowPruneSendAttempts), cmd.FlowSchedule { Cron: "17 * * * *" }, "flowPruneSendAttempts") // hourly, keeps the caps table small
 
 	wg.Add(1)
-	go app.FlowLaunchBlocking (wg, app.flowQueEmails, queEmailFrequency, cmd.ContextTimeout, "flowQueEmails") // populates emails to be sent over the next hour
+	go app.FlowLaunchSchedule (wg, app.LeaderOnly (app.flowReplies), cmd.FlowSchedule { 
+		Interval: repliesFrequency, 
+		Jitter: time.Second * 30, 
+		MaxRuntime: repliesMaxRuntime,
+		Blocking: true,
+	}, "flowReplies") // reads the replies out of the mailmen's reply mailboxes
+
+	if len(cfg.Seeds) > 0 {
+		wg.Add(1)
+		go app.FlowLaunchSchedule (wg, app.LeaderOnly (app.flowWarmbot), cmd.FlowSchedule { 
+			Interval: warmbotFrequency, 
+			Jitter: time.Second * 30, 
+			MaxRuntime: warmbotMaxRuntime,
+			Blocking: true,
+		}, "flowWarmbot") // engages with the warmup emails that landed in the seed inboxes
+	}
 
 	// create our server
 	gg := app.routes()
@@ -202,7 +277,7 @@ func main() {
 	
 	slog.Info("QB exiting")
 	
-	wg.Wait()
+	app.StackTrace(nil, app.Drain(wg)) // lets any in-flight sends finish, up to the drain timeout
 
 	// ok, we're done with the server
 	gg.Shutdown()
@@ -11,6 +11,15 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
+  //-------------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS -----------------------------------------------------------------------------------------------------------//
+//-------------------------------------------------------------------------------------------------------------------------//
+
+type readyResponse struct {
+	Status string
+	Leader bool // only the leader runs the scheduling flows
+}
+
   //-------------------------------------------------------------------------------------------------------------------------//
  //----- LOCAL MIDDLEWARE --------------------------------------------------------------------------------------------------//
 //-------------------------------------------------------------------------------------------------------------------------//
@@ -24,7 +33,7 @@ func (this *app) defaultGet (c *fiber.Ctx) error {
 //-------------------------------------------------------------------------------------------------------------------------//
 
 func (this *app) ready (c *fiber.Ctx) error {
-	if this.Running == false {
+	if this.Running() == false {
 		return this.K8ServiceNotRunning (c)
 	}
 
@@ -38,7 +47,12 @@ func (this *app) ready (c *fiber.Ctx) error {
 		return this.K8ServiceNotRunning (c)
 	}
 
-	return c.SendString("We're good") 
+	return c.JSON(readyResponse { Status: "We're good", Leader: this.IsLeader() })
+}
+
+// how each flow is doing, for when sends stall and we want to know which one without digging through the logs
+func (this *app) flowsGet (c *fiber.Ctx) error {
+	return c.JSON(this.FlowStats())
 }
 
   //-------------------------------------------------------------------------------------------------------------------------//
@@ -52,6 +66,7 @@ func (this *app) routes () *fiber.App {
 	app.Get("/", this.defaultGet)
 
 	app.Get("/status/ready", this.ready)
+	app.Get("/status/flows", this.flowsGet)
 
 	return app
 }
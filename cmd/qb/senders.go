@@ -0,0 +1,81 @@
+/** ****************************************************************************************************************** **
+	Picks the email provider each mailman sends through
+	
+** ****************************************************************************************************************** **/
+
+package main
+
+import (
+	"coldbrew/db/postgres"
+	"coldbrew/tools/mailer"
+	"coldbrew/tools/sendgrid"
+	"coldbrew/tools/smtp"
+	"coldbrew/tools/ses"
+	"coldbrew/tools/mailgun"
+	"coldbrew/tools/postmark"
+
+	"github.com/pkg/errors"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// creates the sender for this mailman's provider
+func newSender (mailman *postgres.Mailman) (mailer.Sender, error) {
+	switch mailman.Attr.Provider {
+	case postgres.MailmanProvider_sendgrid, "": // empty is from before we had providers
+		return &sendgrid.Sender {
+			APIToken: mailman.Attr.APIToken.String(),
+			IpPool: mailman.Attr.IpPool.String(),
+		}, nil
+
+	case postgres.MailmanProvider_smtp:
+		return &smtp.Sender {
+			Host: mailman.Attr.SmtpHost.String(),
+			Port: mailman.Attr.SmtpPort,
+			Username: mailman.Attr.SmtpUser.String(),
+			Password: mailman.Attr.SmtpPassword.String(),
+		}, nil
+
+	case postgres.MailmanProvider_ses:
+		return &ses.Sender {
+			AccessKey: mailman.Attr.SesAccessKey.String(),
+			SecretKey: mailman.Attr.SesSecretKey.String(),
+			Region: mailman.Attr.SesRegion.String(),
+			ConfigurationSet: mailman.Attr.SesConfigurationSet.String(),
+		}, nil
+
+	case postgres.MailmanProvider_mailgun:
+		return &mailgun.Sender {
+			APIKey: mailman.Attr.APIToken.String(),
+			Domain: mailman.Attr.MailgunDomain.String(),
+			EU: mailman.Attr.MailgunEU,
+		}, nil
+
+	case postgres.MailmanProvider_postmark:
+		return &postmark.Sender {
+			ServerToken: mailman.Attr.APIToken.String(),
+			MessageStream: mailman.Attr.PostmarkStream.String(),
+		}, nil
+	}
+
+	return nil, errors.Errorf ("unknown provider for mailman : %s : %s", mailman.Id, mailman.Attr.Provider)
+}
+
+// the provider agnostic version of the email we're sending
+// the email's id goes along in a header, so anything that reads the inbox on the other side can match it back to us
+func newMessage (email *postgres.Email, mailman *postgres.Mailman, user *postgres.User, subject, textBody, htmlBody string) *mailer.Message {
+	return &mailer.Message {
+		To: user.Email.String(),
+		FromEmail: mailman.Attr.FromEmail.String(),
+		FromName: mailman.Attr.FromName.String(),
+		ReplyEmail: mailman.Attr.ReplyTo (email.Id),
+		ReplyName: mailman.Attr.ReplyName.String(),
+		Subject: subject,
+		Text: textBody,
+		Html: htmlBody,
+		Category: mailman.Attr.Category.String(),
+		Headers: map[string]string { mailer.HeaderEmailId: email.Id.String() },
+	}
+}
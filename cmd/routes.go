@@ -6,15 +6,23 @@
 package cmd 
 
 import (
+	"coldbrew/tools/tracing"
+
 	json "github.com/json-iterator/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/template/html/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/fiber/v2/middleware/compress"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	
 	"net/http"
+	"strings"
 	"time"
 	"os"
 )
@@ -23,11 +31,51 @@ import (
  //----- MIDDLEWARE --------------------------------------------------------------------------------------------------------//
 //-------------------------------------------------------------------------------------------------------------------------//
 
+// whether anyone on the internet can call this path
+func (this *App) public (path string) bool {
+	for _, prefix := range this.publicPaths {
+		if strings.HasPrefix (path, prefix) { return true }
+	}
+	return false
+}
+
+// a span for every request, continuing the caller's trace if they sent one
+// handlers get it from c.UserContext()
+func (this *App) traceMiddleware (c *fiber.Ctx) error {
+	ctx := c.UserContext()
+
+	// a trace from the public internet doesn't get to decide what we sample, or what shows up as the id in our logs
+	if this.public (c.Path()) == false {
+		header := make(http.Header)
+		c.Request().Header.VisitAll (func (key, value []byte) {
+			header.Add (string(key), string(value))
+		})
+		ctx = tracing.Extract (ctx, propagation.HeaderCarrier(header))
+	}
+
+	ctx, span := tracing.Start (ctx, c.Method() + " " + c.Path(), trace.SpanKindServer,
+		attribute.String ("http.request.method", c.Method()),
+		attribute.String ("url.path", c.Path()))
+	defer span.End()
+
+	c.SetUserContext (ctx)
+	err := c.Next()
+
+	span.SetName (c.Method() + " " + c.Route().Path) // now that we know which route matched, so ids don't make every span unique
+	span.SetAttributes (attribute.String ("http.route", c.Route().Path), attribute.Int ("http.response.status_code", c.Response().StatusCode()))
+	tracing.Error (span, err)
+	return err
+}
 
   //-------------------------------------------------------------------------------------------------------------------------//
  //----- ROUTES ------------------------------------------------------------------------------------------------------------//
 //-------------------------------------------------------------------------------------------------------------------------//
 
+// marks the paths that take requests from outside, like webhooks. we start a new trace for these rather than continuing theirs
+func (this *App) PublicPaths (prefixes ...string) {
+	this.publicPaths = append (this.publicPaths, prefixes...)
+}
+
 func (this *App) LiveCheck (c *fiber.Ctx) error {
 	// this gets called first, and then kubernetes waits for it to be "live"
 	// so if the code is running at all, we want to serve the next request and return a 200 here
@@ -67,12 +115,17 @@ func (this *App) Routes () *fiber.App {
 	// cors
 	app.Use(cors.New())
 	
+	// time and trace everything that comes after this, ahead of the recover so panics still get counted
+	app.Use(this.metricsMiddleware)
+	app.Use(this.traceMiddleware)
+
 	// Attach the recover middleware to catch panics
 	app.Use(recover.New(recover.Config{
 		EnableStackTrace: true, // Optional: logs stack traces
 	}))
 
 	app.Get("/status/live", this.LiveCheck)
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
 
 	return app
 }
@@ -0,0 +1,131 @@
+/** ****************************************************************************************************************** **
+	Leader election with a postgres advisory lock
+	The lock belongs to the session, so it lives on its own connection that we keep out of the pool.
+	If the leader dies its connection closes, postgres releases the lock and the next one to try gets it
+
+** ****************************************************************************************************************** **/
+
+package db
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+const leaderCloseTimeout = time.Second * 5
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type Leader struct {
+	pool *pgxpool.Pool
+	lockId int64
+
+	lock sync.Mutex // only one election at a time
+	conn *pgx.Conn
+	leader atomic.Bool // so checking doesn't wait on an election
+
+	termLock sync.Mutex
+	term chan struct{} // closed when we stop being the leader, there's a new one each time we become it
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// drops the connection, which also drops the lock if we had it
+func (this *Leader) reset () {
+	if this.conn != nil {
+		ctx, cancel := context.WithTimeout (context.Background(), leaderCloseTimeout)
+		this.conn.Close (ctx)
+		cancel()
+	}
+	this.conn = nil
+	this.setLeader (false)
+}
+
+// keeps the term in step with the flag, anything running as the leader is waiting on the term to close
+func (this *Leader) setLeader (leader bool) {
+	this.termLock.Lock()
+	defer this.termLock.Unlock()
+
+	if leader && this.term == nil {
+		this.term = make(chan struct{})
+	} else if leader == false && this.term != nil {
+		close (this.term)
+		this.term = nil
+	}
+	this.leader.Store (leader)
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// everyone competing for the same job needs to use the same lock id
+func NewLeader (pool *pgxpool.Pool, lockId int64) *Leader {
+	return &Leader { pool: pool, lockId: lockId }
+}
+
+// tries to become the leader, or makes sure we still are. call this on an interval
+func (this *Leader) Elect (ctx context.Context) (bool, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.conn == nil {
+		conn, err := this.pool.Acquire (ctx)
+		if err != nil { return false, errors.WithStack (err) }
+
+		this.conn = conn.Hijack() // this is ours now, the pool won't hand it to anyone else
+	}
+
+	if this.leader.Load() {
+		// we already have the lock, just make sure the connection holding it is still alive
+		if err := this.conn.Ping (ctx); err != nil {
+			this.reset()
+			return false, errors.WithStack (err)
+		}
+		return true, nil
+	}
+
+	leader := false
+	err := this.conn.QueryRow (ctx, `SELECT pg_try_advisory_lock($1)`, this.lockId).Scan (&leader)
+	if err != nil {
+		this.reset()
+		return false, errors.WithStack (err)
+	}
+
+	this.setLeader (leader)
+	return leader, nil
+}
+
+func (this *Leader) IsLeader () bool {
+	return this.leader.Load()
+}
+
+// closed as soon as we lose the lock, nil if we're not the leader right now
+func (this *Leader) Term () <-chan struct{} {
+	this.termLock.Lock()
+	defer this.termLock.Unlock()
+
+	return this.term
+}
+
+// gives up the lock so someone else can take over right away
+func (this *Leader) Close () {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.reset()
+}
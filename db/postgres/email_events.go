@@ -0,0 +1,94 @@
+/** ****************************************************************************************************************** **
+	SQL queries related to the email_events table
+	Every webhook event we get from a provider, the statuses on the emails and users are derived from these
+
+** ****************************************************************************************************************** **/
+
+package postgres
+
+import (
+	"coldbrew/tools"
+	"coldbrew/db"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"context"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// replies we read out of the mailman's reply mailbox ourselves, this isn't a provider anyone sends with
+const EmailEventSource_imap = MailmanProvider("imap")
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type EmailEvent struct {
+	db.DBStruct
+	Email *uuid.UUID // can be nil if we couldn't match it to an email we sent
+	Provider MailmanProvider
+	EventId, MessageId string
+	Status EmailStatus
+	Time time.Time
+	Reason, Url, Ip, UserAgent tools.String
+	Raw []byte // the event exactly as the provider sent it
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// saves the event, returns false if we've already seen this one. providers retry so this happens
+func (this *Coldbrew) EmailEventInsert (ctx context.Context, event *EmailEvent) (bool, error) {
+	event.SetPK()
+
+	// not every provider gives each event an id, for those the body itself is the id
+	if len(event.EventId) == 0 {
+		raw := tools.String(event.Raw)
+		event.EventId = raw.MD5()
+	}
+	if event.Time.Unix() <= 0 { event.Time = time.Now() } // a missing timestamp, either zero or the epoch depending on the provider
+	if len(event.Raw) == 0 { event.Raw = []byte("{}") }
+
+	err := this.DB.QueryRow (ctx, `INSERT INTO email_events (id, email, provider, event_id, message_id, type, event_time, reason, url, ip, user_agent, raw)
+									VALUES ($1, COALESCE($12, (SELECT id FROM emails WHERE message_id = $4 AND $4 <> '' LIMIT 1)), $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+									ON CONFLICT (provider, event_id) DO NOTHING
+									RETURNING email`, event.Id, event.Provider, event.EventId, event.MessageId, event.Status, event.Time,
+									event.Reason, event.Url, event.Ip, event.UserAgent, event.Raw, event.Email).Scan(&event.Email)
+	if this.ErrNoRows (err) { return false, nil } // we already had it
+
+	return err == nil, errors.WithStack (err)
+}
+
+// records the event and updates the email and user it's about, duplicate events are ignored
+func (this *Coldbrew) EmailEventRecord (ctx context.Context, userEmail tools.String, event *EmailEvent) error {
+	inserted, err := this.EmailEventInsert (ctx, event)
+	if err != nil || inserted == false { return err }
+
+	// we can't tell which user it was without an address, the email still gets it if the message id matches
+	if userEmail.Email() {
+		if err := this.UserUpdateStatus (ctx, userEmail, string(event.Status)); err != nil { return err }
+	}
+
+	if len(event.MessageId) == 0 { return nil } // nothing we can match it to
+	return this.EmailUpdateStatus (ctx, userEmail, event.MessageId, event.Status)
+}
+
+// records a reply to one of our emails that we already matched up, returns false if we've seen this reply before
+func (this *Coldbrew) EmailReplyRecord (ctx context.Context, email *Email, event *EmailEvent) (bool, error) {
+	event.Email = email.Id
+	inserted, err := this.EmailEventInsert (ctx, event)
+	if err != nil || inserted == false { return inserted, err }
+
+	if err := this.emailRaiseStatus (ctx, email, event.Status); err != nil { return true, err }
+
+	user, err := this.User (ctx, email.User)
+	if err != nil || user == nil { return true, err }
+
+	return true, this.UserUpdateStatus (ctx, user.Email, string(event.Status))
+}
@@ -0,0 +1,135 @@
+/** ****************************************************************************************************************** **
+	SQL queries related to the email_replies table
+	What people send back to us, replies, bounces and the ones asking us to stop, kept so someone can look them over
+
+** ****************************************************************************************************************** **/
+
+package postgres
+
+import (
+	"coldbrew/tools"
+	"coldbrew/db"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"context"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// replies posted to us by sendgrid's inbound parse
+const EmailEventSource_sendgridInbound = MailmanProvider("sendgrid_inbound")
+
+type EmailReplyKind string
+const (
+	EmailReplyKind_reply		= EmailReplyKind("reply")
+	EmailReplyKind_autoReply	= EmailReplyKind("auto_reply")
+	EmailReplyKind_bounce		= EmailReplyKind("bounce")
+	EmailReplyKind_unsubscribe	= EmailReplyKind("unsubscribe")
+)
+
+// so the list doesn't get out of hand
+const emailRepliesLimit = 500
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type EmailReplyAttachment struct {
+	Filename, Type, ContentId string
+	Size int64
+}
+
+type EmailReplyAttr struct {
+	Headers map[string][]string
+	Attachments []EmailReplyAttachment `json:",omitempty"`
+	SpamScore float64 `json:",omitempty"`
+}
+
+type EmailReply struct {
+	db.DBStruct
+	Email, User *uuid.UUID // nil when we couldn't match it to anything we sent
+	Source MailmanProvider
+	Kind EmailReplyKind
+	MessageId, FromEmail, ToEmail, Subject, Text tools.String
+	Attr EmailReplyAttr
+	Reviewed *time.Time
+	Created time.Time
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+const emailReplyColumns = `id, email, "user", source, kind, message_id, from_email, to_email, subject, body_text, attr, reviewed, created`
+
+func (this *EmailReply) scan (row interface { Scan (...any) error }) error {
+	return row.Scan (&this.Id, &this.Email, &this.User, &this.Source, &this.Kind, &this.MessageId, &this.FromEmail, &this.ToEmail,
+					&this.Subject, &this.Text, &this.Attr, &this.Reviewed, &this.Created)
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// whether someone needs to look at this, an out of office doesn't tell us anything we need to act on
+func (this EmailReplyKind) NeedsReview () bool {
+	return this != EmailReplyKind_autoReply
+}
+
+// saves the reply, returns false if we already had it. the same email can get posted to us more than once
+func (this *Coldbrew) EmailReplyInsert (ctx context.Context, reply *EmailReply) (bool, error) {
+	reply.SetPK()
+	if reply.Kind.NeedsReview() == false {
+		now := time.Now()
+		reply.Reviewed = &now
+	}
+
+	err := this.DB.QueryRow (ctx, `INSERT INTO email_replies (id, email, "user", source, kind, message_id, from_email, to_email, subject, body_text, attr, reviewed)
+									VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+									ON CONFLICT (source, message_id) WHERE message_id <> '' DO NOTHING
+									RETURNING created`, reply.Id, reply.Email, reply.User, reply.Source, reply.Kind, reply.MessageId, reply.FromEmail,
+									reply.ToEmail, reply.Subject, reply.Text, reply.Attr, reply.Reviewed).Scan (&reply.Created)
+	if this.ErrNoRows (err) { return false, nil } // we already had it
+
+	return err == nil, errors.WithStack (err)
+}
+
+func (this *Coldbrew) EmailReply (ctx context.Context, replyId *uuid.UUID) (*EmailReply, error) {
+	reply := &EmailReply{}
+	err := reply.scan (this.DB.QueryRow (ctx, `SELECT ` + emailReplyColumns + ` FROM email_replies WHERE id = $1`, replyId))
+	if this.ErrNoRows (err) { return nil, nil }
+	return reply, errors.WithStack (err)
+}
+
+// the most recent replies, only the ones still waiting on someone unless all is set. an empty kind is every kind
+func (this *Coldbrew) EmailReplies (ctx context.Context, kind EmailReplyKind, all bool) ([]*EmailReply, error) {
+	rows, err := this.DB.Query (ctx, `SELECT ` + emailReplyColumns + ` FROM email_replies
+										WHERE ($1 = '' OR kind = $1) AND ($2 OR reviewed IS NULL)
+										ORDER BY created DESC LIMIT $3`, kind, all, emailRepliesLimit)
+	if err != nil { return nil, errors.WithStack(err) }
+	defer rows.Close()
+
+	ret := make([]*EmailReply, 0, 10)
+	for rows.Next() {
+		reply := &EmailReply{}
+		if err := reply.scan (rows); err != nil { return nil, errors.WithStack (err) }
+
+		ret = append (ret, reply)
+	}
+
+	return ret, errors.WithStack (rows.Err())
+}
+
+// marks it as looked at, returns false if someone already had
+func (this *Coldbrew) EmailReplyReviewed (ctx context.Context, reply *EmailReply) (bool, error) {
+	err := this.DB.QueryRow (ctx, `UPDATE email_replies SET reviewed = NOW() WHERE id = $1 AND reviewed IS NULL RETURNING reviewed`,
+							reply.Id).Scan (&reply.Reviewed)
+	if this.ErrNoRows (err) { return false, nil }
+
+	return err == nil, errors.WithStack (err)
+}
@@ -14,6 +14,8 @@ import (
 	
 	"time"
 	"context"
+	"log/slog"
+	"strings"
 )
 
   //-----------------------------------------------------------------------------------------------------------------------//
@@ -38,6 +40,18 @@ const (
 	EmailStatus_spamreport	= EmailStatus("spamreport")
 	EmailStatus_unsubscribe	= EmailStatus("unsubscribe")
 	EmailStatus_groupUnsub	= EmailStatus("group_unsubscribe")
+	EmailStatus_replied		= EmailStatus("replied") // someone wrote back, we read these ourselves
+	EmailStatus_autoReply	= EmailStatus("auto_reply") // out of office and the like, it got there but no one read it
+)
+
+// where we are with actually getting the email to the provider, the status above is what happened after that
+type EmailSendState string 
+const (
+	EmailSendState_queued	= EmailSendState("queued")
+	EmailSendState_sending	= EmailSendState("sending")
+	EmailSendState_sent		= EmailSendState("sent")
+	EmailSendState_failed	= EmailSendState("failed") // waiting to try again
+	EmailSendState_dead		= EmailSendState("dead") // we gave up, needs someone to requeue it
 )
 
   //-----------------------------------------------------------------------------------------------------------------------//
@@ -50,6 +64,9 @@ type Email struct {
 	Target time.Time
 	MessageId tools.String
 	Status EmailStatus
+	SendState EmailSendState
+	Attempts int
+	LastError tools.String
 }
 
 func (this *Email) Key () string {
@@ -64,6 +81,20 @@ func (this *Email) CacheTime () tools.TimeDuration {
  //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
 
+// the token we put in the reply address, the email's id without the dashes so it's safe in the local part
+func EmailReplyToken (emailId *uuid.UUID) string {
+	return strings.ReplaceAll (emailId.String(), "-", "")
+}
+
+// reverses EmailReplyToken, nil if this isn't one of ours
+func EmailIdFromReplyToken (token string) *uuid.UUID {
+	if len(token) != 32 { return nil }
+
+	id, err := uuid.Parse (token)
+	if err != nil { return nil }
+	return &id
+}
+
 func (this *Coldbrew) emailSetStatus (ctx context.Context, email *Email, status EmailStatus) error {
 	if email.Status == status { return nil } // we're good
 
@@ -71,6 +102,50 @@ func (this *Coldbrew) emailSetStatus (ctx context.Context, email *Email, status
 	return this.Exec (ctx, nil, `UPDATE emails SET status = $2 WHERE id = $1`, email.Id, status)
 }
 
+func (this *Coldbrew) Email (ctx context.Context, emailId *uuid.UUID) (*Email, error) {
+	email := &Email{}
+	err := this.DB.QueryRow (ctx, `SELECT id, mailman, template, "user", target_time, message_id, status, send_state, attempts, last_error
+									FROM emails WHERE id = $1`, emailId).Scan (&email.Id, &email.Mailman, &email.Template, &email.User, 
+									&email.Target, &email.MessageId, &email.Status, &email.SendState, &email.Attempts, &email.LastError)
+	if this.ErrNoRows (err) { return nil, nil }
+	return email, errors.WithStack (err)
+}
+
+// the most recent email sent with any of these message ids, nil if none of them are ours
+func (this *Coldbrew) EmailByMessageIds (ctx context.Context, messageIds []string) (*Email, error) {
+	if len(messageIds) == 0 { return nil, nil }
+
+	email := &Email{}
+	err := this.DB.QueryRow (ctx, `SELECT id, mailman, template, "user", target_time, message_id, status, send_state, attempts, last_error
+									FROM emails WHERE message_id = ANY($1) AND message_id <> '' 
+									ORDER BY sent_time DESC NULLS LAST LIMIT 1`, messageIds).Scan (&email.Id, &email.Mailman, &email.Template, 
+									&email.User, &email.Target, &email.MessageId, &email.Status, &email.SendState, &email.Attempts, &email.LastError)
+	if this.ErrNoRows (err) { return nil, nil }
+	return email, errors.WithStack (err)
+}
+
+// finds the email someone wrote back about, our own id first, then the message ids and then the tokens from the reply address
+// nil if it's not about anything we sent
+func (this *Coldbrew) EmailForReply (ctx context.Context, emailId string, messageIds, tokens []string) (*Email, error) {
+	if id, err := uuid.Parse (emailId); err == nil {
+		email, err := this.Email (ctx, &id)
+		if err != nil || email != nil { return email, err }
+	}
+
+	email, err := this.EmailByMessageIds (ctx, messageIds)
+	if err != nil || email != nil { return email, err }
+
+	for _, token := range tokens {
+		id := EmailIdFromReplyToken (token)
+		if id == nil { continue }
+
+		email, err := this.Email (ctx, id)
+		if err != nil || email != nil { return email, err }
+	}
+
+	return nil, nil
+}
+
 // lists all the non-paused emails
 func (this *Coldbrew) EmailInsert (ctx context.Context, email *Email) error {
 	email.SetPK()
@@ -82,59 +157,154 @@ func (this *Coldbrew) EmailInsert (ctx context.Context, email *Email) error {
 
 // quick check for future scheduled emails for a mailman
 func (this *Coldbrew) EmailsScheduledByMailman (ctx context.Context, mailmanId *uuid.UUID) (cnt int, err error) {
-	err = this.DB.QueryRow (ctx, `SELECT COUNT(*) FROM emails WHERE sent_time IS NULL AND mailman = $1`, mailmanId).Scan(&cnt)
+	err = this.DB.QueryRow (ctx, `SELECT COUNT(*) FROM emails WHERE sent_time IS NULL AND send_state <> $2 AND mailman = $1`, 
+								mailmanId, EmailSendState_dead).Scan(&cnt)
 	return
 }
 
-// finds the next email that needs to be sent
-func (this *Coldbrew) EmailsToSend (ctx context.Context) (*Email, error) {
-	email := &Email{}
-	err := this.DB.QueryRow (ctx, `SELECT id, mailman, template, "user" FROM emails 
-										WHERE sent_time IS NULL AND target_time < now() ORDER BY target_time LIMIT 1`).Scan(&email.Id,
-										&email.Mailman, &email.Template, &email.User)
-	if this.ErrNoRows(err) { return nil, nil } // nothing to send
+// how many emails are waiting to go out, and how many of those are more than late past their target time
+func (this *Coldbrew) EmailsQueueDepth (ctx context.Context, late time.Duration) (queued, overdue int64, err error) {
+	err = this.DB.QueryRow (ctx, `SELECT COUNT(*), COUNT(*) FILTER (WHERE target_time < NOW() - $2 * INTERVAL '1 second') 
+									FROM emails WHERE sent_time IS NULL AND send_state <> $1`, 
+									EmailSendState_dead, int(late.Seconds())).Scan(&queued, &overdue)
+	err = errors.WithStack (err)
+	return
+}
 
-	return email, errors.WithStack(err)
+// claims a batch of emails that are due to be sent, skipping any from paused or deleted mailmen
+// the lease keeps other workers off of them, and if we crash it expires so someone else picks them up
+func (this *Coldbrew) EmailsClaim (ctx context.Context, owner string, batch int, lease time.Duration) ([]*Email, error) {
+	rows, err := this.DB.Query (ctx, `UPDATE emails SET lease_owner = $1, lease_expires = NOW() + $2 * INTERVAL '1 second'
+										WHERE id IN (SELECT e.id FROM emails e
+											JOIN mailmen m ON m.id = e.mailman
+											WHERE e.sent_time IS NULL AND e.send_state <> $5 AND e.target_time < NOW() AND m.mask & $3 = 0
+											AND (e.lease_expires IS NULL OR e.lease_expires < NOW())
+											ORDER BY e.target_time LIMIT $4
+											FOR UPDATE OF e SKIP LOCKED)
+										RETURNING id, mailman, template, "user", target_time, send_state, attempts`, 
+										owner, int(lease.Seconds()), MailmanMask_deleted | MailmanMask_paused, batch, EmailSendState_dead)
+	if err != nil { return nil, errors.WithStack(err) }
+	defer rows.Close()
+
+	ret := make([]*Email, 0, batch)
+	for rows.Next() {
+		email := &Email{}
+		err := rows.Scan (&email.Id, &email.Mailman, &email.Template, &email.User, &email.Target, &email.SendState, &email.Attempts)
+		if err != nil { return nil, errors.WithStack (err) }
+
+		ret = append (ret, email)
+	}
+
+	return ret, errors.WithStack (rows.Err())
+}
+
+// starts a send attempt, returns false if our lease expired and someone else has it now
+// checks the caps first, if one of them is full the email isn't touched and we get back the cap that stopped it
+func (this *Coldbrew) EmailSending (ctx context.Context, email *Email, mailman *Mailman, caps []SendCap, 
+									owner string, lease time.Duration) (bool, *SendCapped, error) {
+	tx, err := this.Begin (ctx)
+	if err != nil { return false, nil, err }
+	defer tx.Rollback (ctx)
+
+	capped, err := this.sendCapsCheck (ctx, tx, caps)
+	if err != nil || capped != nil { return false, capped, err }
+
+	err = tx.QueryRow (ctx, `UPDATE emails SET send_state = $3, attempts = attempts + 1, lease_expires = NOW() + $4 * INTERVAL '1 second'
+								WHERE id = $1 AND lease_owner = $2 AND sent_time IS NULL
+								RETURNING send_state, attempts`, email.Id, owner, EmailSendState_sending, 
+								int(lease.Seconds())).Scan(&email.SendState, &email.Attempts)
+	if this.ErrNoRows (err) { return false, nil, nil }
+	if err != nil { return false, nil, errors.WithStack (err) }
+
+	if err := this.sendAttemptInsert (ctx, tx, email.Id, mailman); err != nil { return false, nil, err }
+
+	return true, nil, errors.WithStack (tx.Commit (ctx))
 }
 
-// marks the email as being sent
-func (this *Coldbrew) EmailSent (ctx context.Context, emailId *uuid.UUID) error {
-	return this.Exec (ctx, nil, `UPDATE emails SET sent_time = NOW() WHERE id = $1`, emailId)
+// a cap is full, so this goes back in the queue for later without counting as an attempt
+func (this *Coldbrew) EmailCapped (ctx context.Context, emailId *uuid.UUID, at time.Time) error {
+	return this.Exec (ctx, nil, `UPDATE emails SET target_time = $2, lease_owner = '', lease_expires = NULL 
+									WHERE id = $1 AND sent_time IS NULL`, emailId, at)
 }
 
+// the provider has it, this is final even if our lease expired while we were sending
+func (this *Coldbrew) EmailSent (ctx context.Context, emailId *uuid.UUID, messageId string) error {
+	return this.Exec (ctx, nil, `UPDATE emails SET send_state = $2, sent_time = NOW(), message_id = $3, last_error = '', 
+									lease_owner = '', lease_expires = NULL WHERE id = $1`, emailId, EmailSendState_sent, messageId)
+}
+
+// the send failed but it's worth trying again at this time
+func (this *Coldbrew) EmailRetry (ctx context.Context, emailId *uuid.UUID, lastErr string, at time.Time) error {
+	return this.Exec (ctx, nil, `UPDATE emails SET send_state = $2, last_error = $3, target_time = $4, 
+									lease_owner = '', lease_expires = NULL WHERE id = $1`, emailId, EmailSendState_failed, lastErr, at)
+}
+
+// we're giving up on this one until someone requeues it
+func (this *Coldbrew) EmailDead (ctx context.Context, emailId *uuid.UUID, lastErr string) error {
+	return this.Exec (ctx, nil, `UPDATE emails SET send_state = $2, last_error = $3, 
+									lease_owner = '', lease_expires = NULL WHERE id = $1`, emailId, EmailSendState_dead, lastErr)
+}
+
+// lists the emails we gave up on, most recent first
+func (this *Coldbrew) EmailsDead (ctx context.Context, limit int) ([]*Email, error) {
+	rows, err := this.DB.Query (ctx, `SELECT id, mailman, template, "user", target_time, send_state, attempts, last_error 
+										FROM emails WHERE send_state = $1 ORDER BY target_time DESC LIMIT $2`, EmailSendState_dead, limit)
+	if err != nil { return nil, errors.WithStack(err) }
+	defer rows.Close()
+
+	ret := make([]*Email, 0, 10)
+	for rows.Next() {
+		email := &Email{}
+		err := rows.Scan (&email.Id, &email.Mailman, &email.Template, &email.User, &email.Target, &email.SendState, &email.Attempts, &email.LastError)
+		if err != nil { return nil, errors.WithStack (err) }
+
+		ret = append (ret, email)
+	}
+
+	return ret, errors.WithStack (rows.Err())
+}
+
+// puts a dead email back in the queue to go out right away, returns false if it wasn't dead
+func (this *Coldbrew) EmailRequeue (ctx context.Context, emailId *uuid.UUID) (bool, error) {
+	tag, err := this.DB.Exec (ctx, `UPDATE emails SET send_state = $2, attempts = 0, last_error = '', target_time = NOW() 
+									WHERE id = $1 AND send_state = $3`, emailId, EmailSendState_queued, EmailSendState_dead)
+	if err != nil { return false, errors.WithStack (err) }
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// puts all the dead emails back in the queue, returns how many there were
+func (this *Coldbrew) EmailsRequeueDead (ctx context.Context) (int64, error) {
+	tag, err := this.DB.Exec (ctx, `UPDATE emails SET send_state = $1, attempts = 0, last_error = '', target_time = NOW() 
+									WHERE send_state = $2`, EmailSendState_queued, EmailSendState_dead)
+	if err != nil { return 0, errors.WithStack (err) }
+
+	return tag.RowsAffected(), nil
+}
+
+// updates the status of the email from a provider webhook, the message id is the only thing we match on
 func (this *Coldbrew) EmailUpdateStatus (ctx context.Context, userEmail tools.String, messageId string, status EmailStatus) error {
+	if len(messageId) == 0 {
+		return errors.Errorf("webhook is missing the message id : %s : %s", userEmail, status)
+	}
+
 	email := &Email{}
 	err := this.DB.QueryRow (ctx, `SELECT id, status FROM emails WHERE message_id = $1`, 
 								messageId).Scan(&email.Id, &email.Status)
 	if this.ErrNoRows (err) { 
-		// this is ok, and expected as we don't have the message id right away
-		// get this user from their email, and look for the last email sent to them that has no message id
-		user, err := this.UserFromEmail (ctx, userEmail)
-		if user == nil || err != nil { return err } // ignore, we couldn't find them
-
-		// we found them, now look for their last email
-		err = this.DB.QueryRow (ctx, `SELECT id, status, message_id FROM emails 
-								WHERE "user" = $1 AND sent_time IS NOT NULL ORDER BY sent_time DESC LIMIT 1`, 
-								user.Id).Scan(&email.Id, &email.Status, &email.MessageId)
-		if this.ErrNoRows (err) { 
-			return errors.Errorf("got a webhook about an email we've never messaged : %s : %s", email, messageId)
-		}
-
-		// we expect this to be empty
-		if email.MessageId.Valid() {
-			return errors.Errorf("we got a webhook from a missing message but the last user had a message id set: %s : %s : %s", userEmail, messageId, email.MessageId)
-		}
-
-		// update this email's message id for next time
-		email.MessageId.Set(messageId)
-		if err := this.Exec (ctx, nil, `UPDATE emails SET message_id = $2 WHERE id = $1`, email.Id, email.MessageId); err != nil {
-			return err 
-		}
-
+		// we save the id when we send, so this is either from an email we didn't send, or the webhook beat us to saving it
+		slog.Warn ("webhook for an unknown message id", slog.String("messageId", messageId), 
+					slog.String("email", userEmail.String()), slog.String("status", string(status)))
+		return nil
 	} else if err != nil { 
-		return err // another error happened
+		return errors.WithStack (err) // another error happened
 	}
 
+	return this.emailRaiseStatus (ctx, email, status)
+}
+
+// only changes the status when the new one is more important than what we already have
+func (this *Coldbrew) emailRaiseStatus (ctx context.Context, email *Email, status EmailStatus) error {
 	// this have a specific priority
 	if status == EmailStatus_spamreport {
 		return this.emailSetStatus (ctx, email, EmailStatus(status)) // this always wins
@@ -160,12 +330,24 @@ func (this *Coldbrew) EmailUpdateStatus (ctx context.Context, userEmail tools.St
 		return nil // we're done
 	}
 
+	if status == EmailStatus_blocked {
+		return this.emailSetStatus (ctx, email, EmailStatus(status)) // this always wins
+	} else if email.Status == EmailStatus_blocked {
+		return nil // we're done
+	}
+
 	if status == EmailStatus_dropped {
 		return this.emailSetStatus (ctx, email, EmailStatus(status)) // this always wins
 	} else if email.Status == EmailStatus_dropped {
 		return nil // we're done
 	}
 
+	if status == EmailStatus_replied {
+		return this.emailSetStatus (ctx, email, EmailStatus(status)) // this always wins
+	} else if email.Status == EmailStatus_replied {
+		return nil // we're done
+	}
+
 	if status == EmailStatus_click {
 		return this.emailSetStatus (ctx, email, EmailStatus(status)) // this always wins
 	} else if email.Status == EmailStatus_click {
@@ -178,6 +360,12 @@ func (this *Coldbrew) EmailUpdateStatus (ctx context.Context, userEmail tools.St
 		return nil // we're done
 	}
 
+	if status == EmailStatus_autoReply {
+		return this.emailSetStatus (ctx, email, EmailStatus(status)) // this always wins
+	} else if email.Status == EmailStatus_autoReply {
+		return nil // we're done
+	}
+
 	if status == EmailStatus_delivered {
 		return this.emailSetStatus (ctx, email, EmailStatus(status)) // this always wins
 	} else if email.Status == EmailStatus_delivered {
@@ -8,12 +8,16 @@ package postgres
 import (
 	"coldbrew/tools"
 	"coldbrew/db"
+	"coldbrew/tools/logging"
+	"coldbrew/tools/mailer"
+	"coldbrew/tools/sendwindow"
+	"coldbrew/tools/throttle"
 	
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	
-	"time"
 	"context"
+	"strings"
 )
 
   //-----------------------------------------------------------------------------------------------------------------------//
@@ -28,18 +32,211 @@ const (
 	MailmanMask_paused
 )
 
+// which service we send through for a mailman
+type MailmanProvider string
+const (
+	MailmanProvider_sendgrid	= MailmanProvider("sendgrid") // the default when nothing is set
+	MailmanProvider_smtp		= MailmanProvider("smtp")
+	MailmanProvider_ses			= MailmanProvider("ses")
+	MailmanProvider_mailgun		= MailmanProvider("mailgun")
+	MailmanProvider_postmark	= MailmanProvider("postmark")
+)
+
   //-----------------------------------------------------------------------------------------------------------------------//
  //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
 
+// hard limits on how many emails can go out, zero means there isn't one
+type SendLimit struct {
+	MaxPerHour, MaxPerDay int
+}
+
+func (this SendLimit) Any () bool {
+	return this.MaxPerHour > 0 || this.MaxPerDay > 0
+}
+
+type MailmanAttr struct {
+	Provider MailmanProvider
+	IpPool, FromEmail, FromName, ReplyEmail, ReplyName, Category tools.String
+	APIToken tools.String `json:",omitempty"`
+
+	// for the smtp provider
+	SmtpHost, SmtpUser tools.String
+	SmtpPort int
+	SmtpPassword tools.String `json:",omitempty"`
+
+	// for the ses provider
+	SesRegion, SesAccessKey, SesConfigurationSet tools.String
+	SesSecretKey tools.String `json:",omitempty"`
+
+	// for the mailgun provider, the api key goes in APIToken
+	MailgunDomain tools.String
+	MailgunEU bool
+
+	// for the postmark provider, the server token goes in APIToken
+	PostmarkStream tools.String
+
+	// how we know the provider's webhooks are real, what this is depends on the provider
+	// sendgrid: the signed event webhook's public key, mailgun: the webhook signing key, postmark: the basic auth password
+	// ses: the arn of the sns topic the events are published to, sns signs everything so this is who we'll listen to
+	WebhookKey tools.String `json:",omitempty"`
+
+	// caps for just this mailman
+	SendLimit
+
+	// mailmen that share a provider account use the same name here, so the account's caps cover all of them
+	Account tools.String
+
+	// when emails are allowed to land in the recipient's time zone, nil sends around the clock
+	SendWindow *sendwindow.Window `json:",omitempty"`
+
+	// how fast this mailman ramps up and when it backs off, nil uses the qb's policy
+	Throttle *throttle.Policy `json:",omitempty"`
+
+	// the mailbox behind ReplyEmail, the qb reads the replies out of it when the host is set
+	ReplyImapHost, ReplyImapUser tools.String
+	ReplyImapPort int
+	ReplyImapPassword tools.String `json:",omitempty"`
+	ReplyToken bool // sends replies to reply+token@, for mailboxes that do plus addressing, so we can match replies that drop the threading headers
+}
+
+// makes sure the settings for this mailman are good enough to send with
+func (this *MailmanAttr) Valid () error {
+	if len(this.Provider) == 0 { this.Provider = MailmanProvider_sendgrid } // this is what we started with
+
+	if this.FromEmail.Email() == false {
+		return errors.Wrap (logging.ErrReturnToUser, "FromEmail appears invalid")
+	}
+
+	if this.ReplyEmail.Valid() && this.ReplyEmail.Email() == false {
+		return errors.Wrap (logging.ErrReturnToUser, "ReplyEmail appears invalid")
+	}
+
+	// sendgrid only allows letters, numbers, underscores and dashes for these
+	if this.IpPool.Valid() && this.IpPool.Remove(`[a-zA-Z0-9_\-]`) != "" {
+		return errors.Wrap (logging.ErrReturnToUser, "IpPool appears invalid")
+	}
+
+	if this.Category.Valid() == false {
+		return errors.Wrap (logging.ErrReturnToUser, "Category is required")
+	}
+
+	if this.MaxPerHour < 0 || this.MaxPerDay < 0 {
+		return errors.Wrap (logging.ErrReturnToUser, "MaxPerHour and MaxPerDay can't be negative")
+	}
+
+	if this.SendWindow != nil {
+		if err := this.SendWindow.Valid(); err != nil {
+			return errors.Wrap (logging.ErrReturnToUser, err.Error())
+		}
+	}
+
+	if this.Throttle != nil {
+		if err := this.Throttle.Valid(); err != nil {
+			return errors.Wrap (logging.ErrReturnToUser, err.Error())
+		}
+	}
+
+	if this.ReplyImapHost.Valid() || this.ReplyToken {
+		if this.ReplyEmail.Valid() == false {
+			return errors.Wrap (logging.ErrReturnToUser, "ReplyEmail is required to read replies")
+		}
+		if this.ReplyImapPort < 0 || this.ReplyImapPort > 65535 {
+			return errors.Wrap (logging.ErrReturnToUser, "ReplyImapPort appears invalid")
+		}
+	}
+
+	switch this.Provider {
+	case MailmanProvider_sendgrid, MailmanProvider_postmark:
+		if this.APIToken.Valid() == false {
+			return errors.Wrap (logging.ErrReturnToUser, "APIToken is required")
+		}
+
+	case MailmanProvider_smtp:
+		if this.SmtpHost.Valid() == false {
+			return errors.Wrap (logging.ErrReturnToUser, "SmtpHost is required")
+		}
+		if this.SmtpPort < 0 || this.SmtpPort > 65535 {
+			return errors.Wrap (logging.ErrReturnToUser, "SmtpPort appears invalid")
+		}
+
+	case MailmanProvider_ses:
+		if this.SesRegion.Valid() == false {
+			return errors.Wrap (logging.ErrReturnToUser, "SesRegion is required")
+		}
+		if this.SesAccessKey.Valid() == false || this.SesSecretKey.Valid() == false {
+			return errors.Wrap (logging.ErrReturnToUser, "SesAccessKey and SesSecretKey are required")
+		}
+		if strings.HasPrefix (this.WebhookKey.String(), "arn:aws:sns:") == false {
+			return errors.Wrap (logging.ErrReturnToUser, "WebhookKey is required, it's the arn of the sns topic for the ses events")
+		}
+
+	case MailmanProvider_mailgun:
+		if this.APIToken.Valid() == false {
+			return errors.Wrap (logging.ErrReturnToUser, "APIToken is required")
+		}
+		if this.MailgunDomain.Valid() == false {
+			return errors.Wrap (logging.ErrReturnToUser, "MailgunDomain is required")
+		}
+
+	default:
+		return errors.Wrapf (logging.ErrReturnToUser, "Provider %s isn't supported", this.Provider)
+	}
+
+	return nil // we're good
+}
+
+// the throttle policy for this mailman, its own when it has one
+func (this *MailmanAttr) ThrottlePolicy (def *throttle.Policy) *throttle.Policy {
+	if this.Throttle != nil { return this.Throttle }
+	return def
+}
+
+// the domain we send from, the domain caps are shared by every mailman sending from it
+func (this *MailmanAttr) Domain () string {
+	_, domain, _ := strings.Cut (this.FromEmail.String(), "@")
+	return strings.ToLower (domain)
+}
+
+// copy of the attributes that's safe to return to a user, no secrets
+func (this MailmanAttr) Redacted () MailmanAttr {
+	this.APIToken = ""
+	this.SmtpPassword = ""
+	this.SesSecretKey = ""
+	this.WebhookKey = ""
+	this.ReplyImapPassword = ""
+	return this
+}
+
+// where replies to this email should go, with the token when we're using them
+func (this *MailmanAttr) ReplyTo (emailId *uuid.UUID) string {
+	if this.ReplyToken == false || this.ReplyEmail.Valid() == false || emailId == nil { return this.ReplyEmail.String() }
+	return mailer.PlusAddress (this.ReplyEmail.String(), EmailReplyToken (emailId))
+}
+
 type Mailman struct {
 	db.DBStruct
-	Attr struct {
-		IpPool, FromEmail, FromName, ReplyEmail, ReplyName, Category, APIToken tools.String
-	}
+	Attr MailmanAttr
 	Mask MailmanMask
 }
 
+// all the caps that apply to this mailman, the domain and account ones come from config
+func (this *Mailman) SendCaps (domains, accounts map[string]SendLimit) []SendCap {
+	ret := make([]SendCap, 0, 3)
+
+	if this.Attr.SendLimit.Any() {
+		ret = append (ret, SendCap { SendLimit: this.Attr.SendLimit, Scope: SendCapScope_mailman, Key: this.Id.String() })
+	}
+	if limit, ok := domains[this.Attr.Domain()]; ok && limit.Any() {
+		ret = append (ret, SendCap { SendLimit: limit, Scope: SendCapScope_domain, Key: this.Attr.Domain() })
+	}
+	if limit, ok := accounts[this.Attr.Account.String()]; ok && limit.Any() && this.Attr.Account.Valid() {
+		ret = append (ret, SendCap { SendLimit: limit, Scope: SendCapScope_account, Key: this.Attr.Account.String() })
+	}
+
+	return ret
+}
+
 func (this *Mailman) Key () string {
 	return this.PrefixKey("mailman")
 }
@@ -81,6 +278,54 @@ func (this *Coldbrew) MailmanList (ctx context.Context) ([]*Mailman, error) {
 	return ret, nil
 }
 
+// lists all the mailmen that haven't been deleted, including the paused ones
+func (this *Coldbrew) MailmanListAll (ctx context.Context) ([]*Mailman, error) {
+	
+	rows, err := this.DB.Query (ctx, `SELECT id, attr, mask FROM mailmen WHERE mask & $1 = 0 ORDER BY created`, 
+								MailmanMask_deleted)
+	if err != nil { return nil, errors.WithStack(err) }
+	defer rows.Close()
+
+	ret := make([]*Mailman, 0, 3)
+	for rows.Next() {
+		mm := &Mailman{}
+		err := rows.Scan(&mm.Id, &mm.Attr, &mm.Mask)
+		if err != nil { return nil, errors.WithStack (err) }
+
+		ret = append (ret, mm)
+	}
+
+	return ret, nil
+}
+
+// lists the webhook keys for all the mailmen using this provider, so we can tell if a webhook is really from them
+func (this *Coldbrew) MailmanWebhookKeys (ctx context.Context, provider MailmanProvider) ([]string, error) {
+	mailmen, err := this.MailmanListAll (ctx)
+	if err != nil { return nil, err }
+
+	ret := make([]string, 0, len(mailmen))
+	for _, mm := range mailmen {
+		if mm.Attr.Provider == provider && mm.Attr.WebhookKey.Valid() {
+			ret = append (ret, mm.Attr.WebhookKey.String())
+		}
+	}
+
+	return ret, nil
+}
+
+// creates a new mailman
+func (this *Coldbrew) MailmanInsert (ctx context.Context, mailman *Mailman) error {
+	mailman.SetPK()
+
+	return this.Exec (ctx, nil, `INSERT INTO mailmen (id, attr, mask) VALUES ($1, $2, $3)`, 
+						mailman.Id, mailman.Attr, mailman.Mask)
+}
+
+// saves the attributes for a mailman
+func (this *Coldbrew) MailmanUpdate (ctx context.Context, mailman *Mailman) error {
+	return this.Exec (ctx, nil, `UPDATE mailmen SET attr = $2 WHERE id = $1`, mailman.Id, mailman.Attr)
+}
+
 // updates the mask for a mailman
 func (this *Coldbrew) MailmanSetMask (ctx context.Context, mailman *Mailman, mask MailmanMask) error {
 	if mailman.Mask & mask == mask { return nil } // already good
@@ -89,23 +334,34 @@ func (this *Coldbrew) MailmanSetMask (ctx context.Context, mailman *Mailman, mas
 	return this.Exec (ctx, nil, `UPDATE mailmen SET mask = mask | $1 WHERE id = $2`, mask, mailman.Id)
 }
 
-// figures out the sending frequency based on past performance
-func (this *Coldbrew) MailmanPerformance (ctx context.Context, mailmanId *uuid.UUID) (time.Duration, error) {
-	rows, err := this.DB.Query (ctx, `SELECT COUNT(*), status, sent_time::date as sent 
-									FROM emails WHERE mailman = $1 AND sent_time IS NOT NULL 
-									GROUP BY sent, status ORDER BY sent DESC LIMIT 150`, mailmanId)
-	if err != nil { return 0, errors.WithStack(err) }
-	defer rows.Close()
+// removes a mask from the mailman
+func (this *Coldbrew) MailmanRemoveMask (ctx context.Context, mailman *Mailman, mask MailmanMask) error {
+	if mailman.Mask & mask == 0 { return nil } // already good
+	mailman.Mask = mailman.Mask & ^ mask // update it in real-time
+	
+	return this.Exec (ctx, nil, `UPDATE mailmen SET mask = mask & ~$1::int WHERE id = $2`, mask, mailman.Id)
+}
 
-	performance := make(mailmanPerformances, 0, 150) // we just need to go back far enough to get a trend line and history
+// how a mailman has been doing over the last few days, for the throttle to decide how fast it should send
+// the event counts are by email, so an email that bounced twice only counts once
+func (this *Coldbrew) MailmanStats (ctx context.Context, mailmanId *uuid.UUID, days int) (*throttle.Stats, error) {
+	stats := &throttle.Stats{}
 
-	for rows.Next() {
-		p := &mailmanPerformance{}
-		err := rows.Scan(&p.Cnt, &p.Status, &p.Sent)
-		if err != nil { return 0, errors.WithStack(err) }
+	err := this.DB.QueryRow (ctx, `SELECT COALESCE(EXTRACT(DAY FROM NOW() - MIN(sent_time))::int, 0), 
+										COUNT(*) FILTER (WHERE sent_time > NOW() - $2 * INTERVAL '1 day')
+									FROM emails WHERE mailman = $1 AND sent_time IS NOT NULL`, 
+									mailmanId, days).Scan(&stats.Day, &stats.Sent)
+	if err != nil { return nil, errors.WithStack(err) }
 
-		performance = append (performance, p)
-	}
+	err = this.DB.QueryRow (ctx, `SELECT COUNT(DISTINCT ev.email) FILTER (WHERE ev.type = ANY($3)),
+										COUNT(DISTINCT ev.email) FILTER (WHERE ev.type = ANY($4)),
+										COUNT(DISTINCT ev.email) FILTER (WHERE ev.type = ANY($5))
+									FROM email_events ev JOIN emails e ON e.id = ev.email
+									WHERE e.mailman = $1 AND ev.event_time > NOW() - $2 * INTERVAL '1 day'`, 
+									mailmanId, days, 
+									[]EmailStatus { EmailStatus_bounce, EmailStatus_dropped }, 
+									[]EmailStatus { EmailStatus_blocked }, 
+									[]EmailStatus { EmailStatus_spamreport }).Scan(&stats.Bounced, &stats.Blocked, &stats.Spam)
 
-	return performance.duration(), nil 
-}
\ No newline at end of file
+	return stats, errors.WithStack(err)
+}
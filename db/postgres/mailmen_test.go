@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"coldbrew/tools/throttle"
+
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+)
+
+func TestQAMailmanSendCaps (t *testing.T) {
+	mailman := &Mailman{}
+	mailman.SetPK()
+	mailman.Attr.FromEmail.Set ("news@Example.com")
+	assert.Equal (t, "example.com", mailman.Attr.Domain())
+
+	// nothing configured, nothing to check
+	assert.Empty (t, mailman.SendCaps (nil, nil))
+
+	mailman.Attr.MaxPerDay = 500
+	mailman.Attr.Account.Set ("sendgrid-main")
+
+	domains := map[string]SendLimit { "example.com": { MaxPerHour: 100 } }
+	accounts := map[string]SendLimit { "sendgrid-main": { MaxPerDay: 10000 }, "other": { MaxPerDay: 1 } }
+
+	caps := mailman.SendCaps (domains, accounts)
+	if assert.Len (t, caps, 3) {
+		assert.Equal (t, SendCap { SendLimit: SendLimit { MaxPerDay: 500 }, Scope: SendCapScope_mailman, Key: mailman.Id.String() }, caps[0])
+		assert.Equal (t, SendCap { SendLimit: SendLimit { MaxPerHour: 100 }, Scope: SendCapScope_domain, Key: "example.com" }, caps[1])
+		assert.Equal (t, SendCap { SendLimit: SendLimit { MaxPerDay: 10000 }, Scope: SendCapScope_account, Key: "sendgrid-main" }, caps[2])
+	}
+}
+
+func TestQAMailmanReplyTo (t *testing.T) {
+	email := &Email{}
+	email.SetPK()
+
+	attr := MailmanAttr{}
+	attr.ReplyEmail.Set ("replies@example.com")
+	assert.Equal (t, "replies@example.com", attr.ReplyTo (email.Id))
+
+	attr.ReplyToken = true
+	replyTo := attr.ReplyTo (email.Id)
+	assert.Equal (t, "replies+" + EmailReplyToken (email.Id) + "@example.com", replyTo)
+	assert.Equal (t, email.Id, EmailIdFromReplyToken (EmailReplyToken (email.Id)))
+	assert.Nil (t, EmailIdFromReplyToken ("newsletter"))
+}
+
+func TestQAMailmanThrottle (t *testing.T) {
+	def := throttle.Default()
+
+	attr := MailmanAttr { Provider: MailmanProvider_smtp, SmtpPort: 587 }
+	attr.FromEmail.Set ("news@example.com")
+	attr.Category.Set ("news")
+	attr.SmtpHost.Set ("smtp.example.com")
+	assert.Equal (t, &def, attr.ThrottlePolicy (&def), "nothing set uses the qb's")
+
+	// its own policy wins, and gets checked like the qb's does
+	attr.Throttle = &throttle.Policy { Ramp: []throttle.Step { { Day: 7, MaxSends: 200 }, { Day: 0, MaxSends: 20 } } }
+	assert.NoError (t, attr.Valid())
+	assert.Equal (t, attr.Throttle, attr.ThrottlePolicy (&def))
+	assert.Equal (t, 20, attr.Throttle.Ramp[0].MaxSends, "sorted by day")
+	assert.Equal (t, 7, attr.Throttle.Window)
+
+	attr.Throttle.Ramp[0].MaxSends = 0
+	assert.Error (t, attr.Valid())
+}
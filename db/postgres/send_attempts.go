@@ -0,0 +1,105 @@
+/** ****************************************************************************************************************** **
+	SQL queries related to the send_attempts table. Every time we hand an email to a provider it's logged here,
+	so the hourly and daily caps can be checked before the next one goes out
+
+** ****************************************************************************************************************** **/
+
+package postgres
+
+import (
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+
+	"context"
+	"sort"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// what a cap covers
+type SendCapScope string 
+const (
+	SendCapScope_mailman	= SendCapScope("mailman")
+	SendCapScope_domain		= SendCapScope("domain") // the from domain, across every mailman using it
+	SendCapScope_account	= SendCapScope("account") // the provider account, across every mailman using it
+)
+
+// how long we keep the attempts around, just needs to be longer than the biggest window we cap on
+const sendAttemptsKeep = time.Hour * 25
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type SendCap struct {
+	SendLimit
+	Scope SendCapScope
+	Key string
+}
+
+// returned when a cap stops an email from going out
+type SendCapped struct {
+	SendCap
+	Hourly bool // true when it was the hourly cap, otherwise it was the daily one
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func (this SendCap) column () string {
+	switch this.Scope {
+	case SendCapScope_domain: return "domain"
+	case SendCapScope_account: return "account"
+	}
+	return "mailman::text"
+}
+
+// locks each cap so no one else can check it until our transaction is done, then checks them
+// the locks are always taken in the same order so two workers can't deadlock on them
+func (this *Coldbrew) sendCapsCheck (ctx context.Context, tx pgx.Tx, caps []SendCap) (*SendCapped, error) {
+	sort.Slice (caps, func (i, j int) bool { 
+		if caps[i].Scope == caps[j].Scope { return caps[i].Key < caps[j].Key }
+		return caps[i].Scope < caps[j].Scope 
+	})
+
+	for _, sc := range caps {
+		if _, err := tx.Exec (ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, string(sc.Scope) + ":" + sc.Key); err != nil {
+			return nil, errors.WithStack (err)
+		}
+
+		var hour, day int
+		err := tx.QueryRow (ctx, `SELECT COUNT(*) FILTER (WHERE created > NOW() - INTERVAL '1 hour'), COUNT(*) 
+									FROM send_attempts WHERE ` + sc.column() + ` = $1 AND created > NOW() - INTERVAL '1 day'`, 
+									sc.Key).Scan(&hour, &day)
+		if err != nil { return nil, errors.WithStack (err) }
+
+		if sc.MaxPerHour > 0 && hour >= sc.MaxPerHour { return &SendCapped { SendCap: sc, Hourly: true }, nil }
+		if sc.MaxPerDay > 0 && day >= sc.MaxPerDay { return &SendCapped { SendCap: sc }, nil }
+	}
+
+	return nil, nil // we're under all of them
+}
+
+func (this *Coldbrew) sendAttemptInsert (ctx context.Context, tx pgx.Tx, emailId *uuid.UUID, mailman *Mailman) error {
+	_, err := tx.Exec (ctx, `INSERT INTO send_attempts (id, email, mailman, domain, account) VALUES ($1, $2, $3, $4, $5)`, 
+						uuid.New(), emailId, mailman.Id, mailman.Attr.Domain(), mailman.Attr.Account)
+	return errors.WithStack (err)
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// clears out the attempts that are too old to count towards any cap
+func (this *Coldbrew) SendAttemptsPrune (ctx context.Context) (int64, error) {
+	tag, err := this.DB.Exec (ctx, `DELETE FROM send_attempts WHERE created < NOW() - $1 * INTERVAL '1 second'`, 
+								int(sendAttemptsKeep.Seconds()))
+	if err != nil { return 0, errors.WithStack (err) }
+
+	return tag.RowsAffected(), nil
+}
@@ -15,6 +15,10 @@ import (
 	"context"
 	"time"
 	"strings"
+	"sync"
+	"bytes"
+	textTemplate "text/template"
+	htmlTemplate "html/template"
 )
 
   //-----------------------------------------------------------------------------------------------------------------------//
@@ -32,6 +36,32 @@ const (
  //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
 
+// the parsed version of a template, along with the content we parsed it from
+type parsedTemplate struct {
+	text, html, subject string
+	textTmpl, subjectTmpl *textTemplate.Template
+	htmlTmpl *htmlTemplate.Template
+}
+
+// parsed templates by template id, shared by everything in this process
+var templateCache = struct {
+	sync.RWMutex
+	list map[uuid.UUID]*parsedTemplate
+} { list: make(map[uuid.UUID]*parsedTemplate) }
+
+// functions available inside the templates
+var templateFuncs = map[string]interface{} {
+	// {{ default "there" .FirstName }} for when the user doesn't have this attribute
+	"default": func (def, val string) string {
+		if len(val) == 0 { return def }
+		return val
+	},
+	// kept as a function so the original {{ ShortDate }} subjects still work
+	"ShortDate": func () string {
+		return time.Now().Format("Jan 2")
+	},
+}
+
 type Template struct {
 	db.DBStruct
 	Html, Body, Subject, Preview tools.String
@@ -49,33 +79,105 @@ func (this *Template) CacheTime () tools.TimeDuration {
 	return tools.TimeDuration(600) // this can cache for a while
 }
 
-func (this *Template) GenerateTextBody (baseUrl string, user *User) (string, error) {
-	if this.Body.Valid() == false { return "", nil } // no text here
+// parses the bodies for this template, re-using what we already parsed if the content hasn't changed
+func (this *Template) parsed () (*parsedTemplate, error) {
+	if this.Id != nil {
+		templateCache.RLock()
+		p, ok := templateCache.list[*this.Id]
+		templateCache.RUnlock()
+
+		if ok && p.text == this.Body.String() && p.html == this.Html.String() && p.subject == this.Subject.String() {
+			return p, nil // we're good, nothing changed since we parsed it
+		}
+	}
+
+	p := &parsedTemplate {
+		text: this.Body.String(),
+		html: this.Html.String(),
+		subject: this.Subject.String(),
+	}
+
+	// missing attributes come through as empty strings instead of <no value>
+	var err error
+	if len(p.text) > 0 {
+		p.textTmpl, err = textTemplate.New("text").Option("missingkey=zero").Funcs(templateFuncs).Parse (p.text)
+		if err != nil { return nil, errors.Wrapf (err, "text template : %s", this.Id) }
+	}
+
+	if len(p.html) > 0 {
+		p.htmlTmpl, err = htmlTemplate.New("html").Option("missingkey=zero").Funcs(templateFuncs).Parse (p.html)
+		if err != nil { return nil, errors.Wrapf (err, "html template : %s", this.Id) }
+	}
+
+	p.subjectTmpl, err = textTemplate.New("subject").Option("missingkey=zero").Funcs(templateFuncs).Parse (p.subject)
+	if err != nil { return nil, errors.Wrapf (err, "subject template : %s", this.Id) }
 
-	data := make(map[string]string)
+	if this.Id != nil { // templates that haven't been saved yet don't get cached
+		templateCache.Lock()
+		templateCache.list[*this.Id] = p
+		templateCache.Unlock()
+	}
+
+	return p, nil
+}
+
+// drops what we parsed for this template, so edited and deleted templates don't hang around in memory
+func (this *Template) uncache () {
+	if this.Id == nil { return }
+
+	templateCache.Lock()
+	delete (templateCache.list, *this.Id)
+	templateCache.Unlock()
+}
+
+// data each template has access to, the user's attributes plus our own fields which always win
+func (this *Template) data (baseUrl string, user *User) map[string]string {
+	data := user.Attr.Strings()
 	data["BaseUrl"] = baseUrl
 	data["UserToken"] = user.Token.String()
+	data["Email"] = user.Email.String()
+	data["ShortDate"] = time.Now().Format("Jan 2")
+	return data
+}
 
-	return tools.GenText ("email", this.Body.String(), data)
+func (this *Template) GenerateTextBody (baseUrl string, user *User) (string, error) {
+	if this.Body.Valid() == false { return "", nil } // no text here
+
+	p, err := this.parsed()
+	if err != nil { return "", err }
+
+	buf := new(bytes.Buffer)
+	if err := p.textTmpl.Execute (buf, this.data (baseUrl, user)); err != nil {
+		return "", errors.Wrapf (err, "text template : %s : %s", this.Id, user.Email)
+	}
+
+	return buf.String(), nil
 }
 
 func (this *Template) GenerateHTMLBody (baseUrl string, user *User) (string, error) {
 	if this.Html.Valid() == false { return "", nil } // no html here
 
-	data := make(map[string]string)
-	data["BaseUrl"] = baseUrl
-	data["UserToken"] = user.Token.String()
+	p, err := this.parsed()
+	if err != nil { return "", err }
 
-	return tools.GenText ("email", this.Html.String(), data)
+	buf := new(bytes.Buffer)
+	if err := p.htmlTmpl.Execute (buf, this.data (baseUrl, user)); err != nil {
+		return "", errors.Wrapf (err, "html template : %s : %s", this.Id, user.Email)
+	}
+
+	return buf.String(), nil
 }
 
-func (this *Template) GenerateSubject () string {
-	// some default things
-	shortDate := time.Now().Format("Jan 2")
+func (this *Template) GenerateSubject (baseUrl string, user *User) (string, error) {
+	p, err := this.parsed()
+	if err != nil { return "", err }
 
-	subject := strings.ReplaceAll (this.Subject.String(), "{{ ShortDate }}", shortDate)
+	buf := new(bytes.Buffer)
+	if err := p.subjectTmpl.Execute (buf, this.data (baseUrl, user)); err != nil {
+		return "", errors.Wrapf (err, "subject template : %s : %s", this.Id, user.Email)
+	}
 
-	return subject
+	return strings.TrimSpace (buf.String()), nil
 }
 
   //-----------------------------------------------------------------------------------------------------------------------//
@@ -112,3 +214,58 @@ func (this *Coldbrew) TemplateList (ctx context.Context) ([]*Template, error) {
 
 	return ret, nil
 }
+
+// lists all the templates that haven't been deleted, including the paused ones
+func (this *Coldbrew) TemplateListAll (ctx context.Context) ([]*Template, error) {
+	
+	rows, err := this.DB.Query (ctx, `SELECT id, body_html, body_text, subject, preview_text, attr, mask
+								FROM templates WHERE mask & $1 = 0 ORDER BY created`, 
+								TemplateMask_deleted)
+	if err != nil { return nil, errors.WithStack(err) }
+	defer rows.Close()
+
+	ret := make([]*Template, 0, 3)
+	for rows.Next() {
+		template := &Template{}
+		err := rows.Scan(&template.Id, &template.Html, &template.Body, &template.Subject, &template.Preview, &template.Attr, &template.Mask)
+		if err != nil { return nil, errors.WithStack (err) }
+
+		ret = append (ret, template)
+	}
+
+	return ret, nil
+}
+
+// creates a new template
+func (this *Coldbrew) TemplateInsert (ctx context.Context, template *Template) error {
+	template.SetPK()
+
+	return this.Exec (ctx, nil, `INSERT INTO templates (id, body_html, body_text, subject, preview_text, attr, mask) 
+									VALUES ($1, $2, $3, $4, $5, $6, $7)`, template.Id, template.Html, template.Body, 
+									template.Subject, template.Preview, template.Attr, template.Mask)
+}
+
+// saves the content of a template
+func (this *Coldbrew) TemplateUpdate (ctx context.Context, template *Template) error {
+	template.uncache() // it'll get parsed again with the new content the next time it's used
+
+	return this.Exec (ctx, nil, `UPDATE templates SET body_html = $2, body_text = $3, subject = $4, preview_text = $5 
+									WHERE id = $1`, template.Id, template.Html, template.Body, template.Subject, template.Preview)
+}
+
+// updates the mask for a template
+func (this *Coldbrew) TemplateSetMask (ctx context.Context, template *Template, mask TemplateMask) error {
+	if template.Mask & mask == mask { return nil } // already good
+	template.Mask |= mask // update it in real-time
+	if mask & TemplateMask_deleted > 0 { template.uncache() } // nothing's going to use it again
+	
+	return this.Exec (ctx, nil, `UPDATE templates SET mask = mask | $1 WHERE id = $2`, mask, template.Id)
+}
+
+// removes a mask from the template
+func (this *Coldbrew) TemplateRemoveMask (ctx context.Context, template *Template, mask TemplateMask) error {
+	if template.Mask & mask == 0 { return nil } // already good
+	template.Mask = template.Mask & ^ mask // update it in real-time
+	
+	return this.Exec (ctx, nil, `UPDATE templates SET mask = mask & ~$1::int WHERE id = $2`, mask, template.Id)
+}
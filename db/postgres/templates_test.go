@@ -0,0 +1,74 @@
+
+package postgres
+
+import (
+	"coldbrew/tools"
+
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+	"time"
+)
+
+func TestQATemplateGenerate (t *testing.T) {
+	user := &User{}
+	user.Token.Set ("abc123")
+
+	template := &Template{}
+	template.SetPK()
+	template.Body.Set ("Hi there {{ .BaseUrl }}/unsubscribe/{{ .UserToken }}")
+	template.Html.Set (`<a href="{{ .BaseUrl }}/unsubscribe/{{ .UserToken }}">unsubscribe</a>`)
+
+	text, err := template.GenerateTextBody ("https://example.com", user)
+	tools.TestingStackTrace (t, err)
+	assert.Equal (t, "Hi there https://example.com/unsubscribe/abc123", text)
+
+	html, err := template.GenerateHTMLBody ("https://example.com", user)
+	tools.TestingStackTrace (t, err)
+	assert.Equal (t, `<a href="https://example.com/unsubscribe/abc123">unsubscribe</a>`, html)
+
+	// changing the content under the same id should get picked up, not served from the cache
+	template.Body.Set ("Changed {{ .UserToken }}")
+	text, err = template.GenerateTextBody ("https://example.com", user)
+	tools.TestingStackTrace (t, err)
+	assert.Equal (t, "Changed abc123", text)
+
+	// updates and deletes drop it from the cache
+	template.uncache()
+	templateCache.RLock()
+	_, ok := templateCache.list[*template.Id]
+	templateCache.RUnlock()
+	assert.False (t, ok)
+}
+
+func TestQATemplateGenerateInvalid (t *testing.T) {
+	user := &User{}
+
+	template := &Template{}
+	template.Body.Set ("Hi there {{ .BaseUrl ")
+
+	_, err := template.GenerateTextBody ("https://example.com", user)
+	assert.Error (t, err)
+}
+
+func TestQATemplateMergeFields (t *testing.T) {
+	user := &User{ Attr: UserAttr{ "FirstName": "Nathan", "Seats": float64(12) } }
+	user.Email.Set ("nathan@example.com")
+
+	template := &Template{}
+	template.Subject.Set ("Hey {{ .FirstName }}, it's {{ ShortDate }}")
+	template.Body.Set (`Hi {{ default "there" .FirstName }} at {{ default "your company" .Company }}, {{ .Seats }} seats{{ .Missing }}`)
+
+	subject, err := template.GenerateSubject ("https://example.com", user)
+	tools.TestingStackTrace (t, err)
+	assert.Equal (t, "Hey Nathan, it's " + time.Now().Format("Jan 2"), subject)
+
+	text, err := template.GenerateTextBody ("https://example.com", user)
+	tools.TestingStackTrace (t, err)
+	assert.Equal (t, "Hi Nathan at your company, 12 seats", text)
+
+	// no attributes at all still renders with the defaults
+	text, err = template.GenerateTextBody ("https://example.com", &User{})
+	tools.TestingStackTrace (t, err)
+	assert.Equal (t, "Hi there at your company,  seats", text)
+}
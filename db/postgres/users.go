@@ -8,6 +8,7 @@ package postgres
 import (
 	"coldbrew/tools"
 	"coldbrew/db"
+	"coldbrew/tools/sendwindow"
 	
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -16,6 +17,8 @@ import (
 	"fmt"
 	"context"
 	"crypto/sha256"
+	"strings"
+	json "github.com/json-iterator/go"
 )
 
   //-----------------------------------------------------------------------------------------------------------------------//
@@ -38,6 +41,7 @@ const (
 	UserMask_deferred
 	UserMask_bounce
 	UserMask_spam
+	UserMask_replied
 )
 
 const UserMask_doNotEmail = UserMask_deleted | UserMask_unsubscribe | UserMask_failed | UserMask_dropped | UserMask_bounce | UserMask_spam
@@ -46,12 +50,54 @@ const UserMask_doNotEmail = UserMask_deleted | UserMask_unsubscribe | UserMask_f
  //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
 
+// free form attributes about a user, first name, company, etc. these are the merge fields for the templates
+type UserAttr map[string]interface{}
+
+// returns the attribute as a string, empty if it's not set
+func (this UserAttr) String (key string) string {
+	val, ok := this[key]
+	if ok == false || val == nil { return "" }
+
+	switch v := val.(type) {
+	case string:
+		return v
+	case float64, bool, int:
+		return fmt.Sprint (v)
+	default:
+		jstr, _ := json.Marshal (v) // nested objects and arrays just come through as json
+		return string(jstr)
+	}
+}
+
+// first of these keys that's set, ignoring case since these come from whatever was imported
+func (this UserAttr) first (keys ...string) string {
+	for _, key := range keys {
+		for k := range this {
+			if strings.EqualFold (k, key) && len(this.String (k)) > 0 { return this.String (k) }
+		}
+	}
+	return ""
+}
+
+// the user's time zone, either set directly or guessed from their country and region. nil if we don't know
+func (this UserAttr) Location () *time.Location {
+	if loc := sendwindow.Zone (this.first ("timezone", "time_zone", "tz")); loc != nil { return loc }
+	return sendwindow.GuessZone (this.first ("country"), this.first ("region", "state", "province"))
+}
+
+// all the attributes as strings, so they can be used in a template
+func (this UserAttr) Strings () map[string]string {
+	ret := make(map[string]string, len(this))
+	for key := range this {
+		ret[key] = this.String (key)
+	}
+	return ret
+}
+
 type User struct {
 	db.DBStruct
 	Email, Token tools.String
-	Attr struct {
-		
-	}
+	Attr UserAttr
 	Mask UserMask
 }
 
@@ -88,9 +134,13 @@ func (this *Coldbrew) User (ctx context.Context, userId *uuid.UUID) (*User, erro
 	return user, errors.WithStack(err)
 }
 
-func (this *Coldbrew) UserInsert (ctx context.Context, warmup bool, email tools.String, skipValidation bool) error {
+// adds a new user, if they already exist then the attributes are merged into what we already have
+func (this *Coldbrew) UserInsert (ctx context.Context, warmup bool, email tools.String, attr UserAttr, skipValidation bool) error {
+	if attr == nil { attr = UserAttr{} } // don't store a null
+
 	user := &User {
 		Email: email,
+		Attr: attr,
 	}
 	user.init()
 	if warmup { user.Mask |= UserMask_warmup }
@@ -98,8 +148,9 @@ func (this *Coldbrew) UserInsert (ctx context.Context, warmup bool, email tools.
 	validated := " NULL "
 	if skipValidation { validated = " NOW() "}
 
-	err := this.Exec (ctx, nil, `INSERT INTO users (id, email, token, mask, validated) VALUES ($1, $2, $3, $4, ` + validated + `)`,
-						user.Id, user.Email, user.Token, user.Mask)
+	err := this.Exec (ctx, nil, `INSERT INTO users (id, email, token, attr, mask, validated) VALUES ($1, $2, $3, $4, $5, ` + validated + `)
+						ON CONFLICT (email) DO UPDATE SET attr = users.attr || EXCLUDED.attr`,
+						user.Id, user.Email, user.Token, user.Attr, user.Mask)
 	if this.ErrUniqueConstraint (err) { return nil } // don't record this error
 	return err
 }
@@ -234,12 +285,21 @@ func (this *Coldbrew) UserUpdateStatus (ctx context.Context, email tools.String,
 	case EmailStatus_click:
 		return this.UserSetMask (ctx, user, UserMask_click)
 
+	case EmailStatus_replied:
+		return this.UserSetMask (ctx, user, UserMask_replied)
+
+	case EmailStatus_autoReply:
+		return this.UserSetMask (ctx, user, UserMask_delivered) // all this tells us is that it got there
+
 	case EmailStatus_dropped:
 		return this.UserSetMask (ctx, user, UserMask_dropped)
 
 	case EmailStatus_bounce:
 		return this.UserSetMask (ctx, user, UserMask_bounce)
 
+	case EmailStatus_blocked:
+		return nil // that's about us, not their address, so the throttle deals with it
+
 	case EmailStatus_spamreport:
 		return this.UserSetMask (ctx, user, UserMask_spam)
 
@@ -0,0 +1,14 @@
+package postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+)
+
+func TestQAUserLocation (t *testing.T) {
+	assert.Equal (t, "Europe/Paris", UserAttr { "TimeZone": "Europe/Paris", "country": "US" }.Location().String())
+	assert.Equal (t, "America/Denver", UserAttr { "Country": "US", "State": "CO" }.Location().String())
+	assert.Equal (t, "America/Chicago", UserAttr { "country": "US", "tz": "not a zone" }.Location().String())
+	assert.Nil (t, UserAttr { "first_name": "Nathan" }.Location())
+}
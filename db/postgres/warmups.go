@@ -0,0 +1,227 @@
+/** ****************************************************************************************************************** **
+	SQL queries related to the warmup_plans and mailman_warmups tables
+	A plan is a list of phases, each mailman works through one phase at a time until it's warm
+
+** ****************************************************************************************************************** **/
+
+package postgres
+
+import (
+	"coldbrew/tools"
+	"coldbrew/db"
+	"coldbrew/tools/logging"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"context"
+	"fmt"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type WarmupPlanMask int64
+const (
+	WarmupPlanMask_deleted 			WarmupPlanMask = 1 << iota 
+)
+
+// new mailmen get the plan with this name, or the built in one if there isn't one
+const WarmupPlan_default = "default"
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type WarmupPhase struct {
+	Name tools.String
+	Days int // the least amount of time we spend in this phase
+	PerDay int // warmup emails a day
+	Html bool // include the html body, otherwise it's text only
+
+	// to move on once the days are up, zero skips the check
+	MinDeliveryRate, MinOpenRate float64 // fraction of the sends, 0.9 is 90%
+}
+
+// how the mailman did during a phase
+type WarmupStats struct {
+	Sent, Delivered, Opened int
+}
+
+type WarmupPlanAttr struct {
+	Phases []WarmupPhase
+}
+
+type WarmupPlan struct {
+	db.DBStruct
+	Name tools.String
+	Attr WarmupPlanAttr
+	Mask WarmupPlanMask
+}
+
+// where a mailman is in their plan
+type MailmanWarmup struct {
+	Mailman, Plan *uuid.UUID // no plan means the built in one
+	Phase int
+	PhaseStarted time.Time
+	Completed *time.Time
+	Status tools.String // why we're still in this phase, or why we moved on
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// what we used to hard code, 30 text emails and then 30 with html
+func DefaultWarmupPlan () *WarmupPlan {
+	plan := &WarmupPlan {
+		Attr: WarmupPlanAttr {
+			Phases: []WarmupPhase {
+				{ Days: 1, PerDay: 30 },
+				{ Days: 1, PerDay: 30, Html: true },
+			},
+		},
+	}
+	plan.Name.Set (WarmupPlan_default)
+	plan.Attr.Phases[0].Name.Set ("text")
+	plan.Attr.Phases[1].Name.Set ("html")
+	return plan
+}
+
+func (this *WarmupPlanAttr) Valid () error {
+	if len(this.Phases) == 0 { return errors.Wrap (logging.ErrReturnToUser, "Phases are required") }
+
+	for i, phase := range this.Phases {
+		if phase.Days < 1 || phase.PerDay < 1 {
+			return errors.Wrapf (logging.ErrReturnToUser, "phase %d needs Days and PerDay", i)
+		}
+		if phase.MinDeliveryRate < 0 || phase.MinDeliveryRate > 1 || phase.MinOpenRate < 0 || phase.MinOpenRate > 1 {
+			return errors.Wrapf (logging.ErrReturnToUser, "phase %d rates need to be between 0 and 1", i)
+		}
+	}
+
+	return nil
+}
+
+// true when the phase's exit criteria are met, the reason says why either way
+func (this *WarmupPhase) Done (started, now time.Time, stats WarmupStats) (bool, string) {
+	if days := now.Sub (started); days < time.Hour * 24 * time.Duration(max(this.Days, 1)) {
+		return false, fmt.Sprintf ("day %d of %d", int(days.Hours() / 24) + 1, this.Days)
+	}
+
+	rate := func (cnt int) float64 {
+		if stats.Sent < 1 { return 0 }
+		return float64(cnt) / float64(stats.Sent)
+	}
+
+	if this.MinDeliveryRate > 0 && rate (stats.Delivered) < this.MinDeliveryRate {
+		return false, fmt.Sprintf ("delivery rate %.1f%% is under %.1f%%", rate (stats.Delivered) * 100, this.MinDeliveryRate * 100)
+	}
+
+	if this.MinOpenRate > 0 && rate (stats.Opened) < this.MinOpenRate {
+		return false, fmt.Sprintf ("open rate %.1f%% is under %.1f%%", rate (stats.Opened) * 100, this.MinOpenRate * 100)
+	}
+
+	return true, fmt.Sprintf ("finished %s : %d sent, %d delivered, %d opened", this.Name, stats.Sent, stats.Delivered, stats.Opened)
+}
+
+//----- Plans ---------------------------------------------------------------------------------------------------------//
+
+func (this *Coldbrew) WarmupPlan (ctx context.Context, planId *uuid.UUID) (*WarmupPlan, error) {
+	plan := &WarmupPlan{}
+	err := this.DB.QueryRow (ctx, `SELECT id, name, attr, mask FROM warmup_plans WHERE id = $1`, 
+									planId).Scan (&plan.Id, &plan.Name, &plan.Attr, &plan.Mask)
+	
+	if this.ErrNoRows (err) { return nil, nil }
+	return plan, errors.WithStack(err)
+}
+
+func (this *Coldbrew) WarmupPlanByName (ctx context.Context, name string) (*WarmupPlan, error) {
+	plan := &WarmupPlan{}
+	err := this.DB.QueryRow (ctx, `SELECT id, name, attr, mask FROM warmup_plans WHERE name = $1 AND mask & $2 = 0`, 
+									name, WarmupPlanMask_deleted).Scan (&plan.Id, &plan.Name, &plan.Attr, &plan.Mask)
+	
+	if this.ErrNoRows (err) { return nil, nil }
+	return plan, errors.WithStack(err)
+}
+
+// lists all the plans that haven't been deleted
+func (this *Coldbrew) WarmupPlanList (ctx context.Context) ([]*WarmupPlan, error) {
+	rows, err := this.DB.Query (ctx, `SELECT id, name, attr, mask FROM warmup_plans WHERE mask & $1 = 0 ORDER BY name`, 
+								WarmupPlanMask_deleted)
+	if err != nil { return nil, errors.WithStack(err) }
+	defer rows.Close()
+
+	ret := make([]*WarmupPlan, 0, 3)
+	for rows.Next() {
+		plan := &WarmupPlan{}
+		err := rows.Scan(&plan.Id, &plan.Name, &plan.Attr, &plan.Mask)
+		if err != nil { return nil, errors.WithStack (err) }
+
+		ret = append (ret, plan)
+	}
+
+	return ret, errors.WithStack (rows.Err())
+}
+
+func (this *Coldbrew) WarmupPlanInsert (ctx context.Context, plan *WarmupPlan) error {
+	plan.SetPK()
+
+	return this.Exec (ctx, nil, `INSERT INTO warmup_plans (id, name, attr, mask) VALUES ($1, $2, $3, $4)`, 
+									plan.Id, plan.Name, plan.Attr, plan.Mask)
+}
+
+func (this *Coldbrew) WarmupPlanUpdate (ctx context.Context, plan *WarmupPlan) error {
+	return this.Exec (ctx, nil, `UPDATE warmup_plans SET name = $2, attr = $3 WHERE id = $1`, plan.Id, plan.Name, plan.Attr)
+}
+
+// updates the mask for a plan
+func (this *Coldbrew) WarmupPlanSetMask (ctx context.Context, plan *WarmupPlan, mask WarmupPlanMask) error {
+	if plan.Mask & mask == mask { return nil } // already good
+	plan.Mask |= mask // update it in real-time
+	
+	return this.Exec (ctx, nil, `UPDATE warmup_plans SET mask = mask | $1 WHERE id = $2`, mask, plan.Id)
+}
+
+//----- Mailmen -------------------------------------------------------------------------------------------------------//
+
+// where the mailman is in their warmup, nil if they never started one
+func (this *Coldbrew) MailmanWarmup (ctx context.Context, mailmanId *uuid.UUID) (*MailmanWarmup, error) {
+	warmup := &MailmanWarmup{}
+	err := this.DB.QueryRow (ctx, `SELECT mailman, plan, phase, phase_started, completed, status FROM mailman_warmups WHERE mailman = $1`, 
+									mailmanId).Scan (&warmup.Mailman, &warmup.Plan, &warmup.Phase, &warmup.PhaseStarted, 
+									&warmup.Completed, &warmup.Status)
+	
+	if this.ErrNoRows (err) { return nil, nil }
+	return warmup, errors.WithStack(err)
+}
+
+// puts the mailman at the start of the plan, this restarts it if they already had one
+func (this *Coldbrew) MailmanWarmupStart (ctx context.Context, warmup *MailmanWarmup) error {
+	warmup.Phase = 0
+	warmup.PhaseStarted = time.Now()
+	warmup.Completed = nil
+	warmup.Status.Set ("started")
+
+	return this.Exec (ctx, nil, `INSERT INTO mailman_warmups (mailman, plan, phase, phase_started, completed, status) 
+									VALUES ($1, $2, $3, $4, $5, $6)
+									ON CONFLICT (mailman) DO UPDATE SET plan = $2, phase = $3, phase_started = $4, completed = $5, status = $6`, 
+									warmup.Mailman, warmup.Plan, warmup.Phase, warmup.PhaseStarted, warmup.Completed, warmup.Status)
+}
+
+func (this *Coldbrew) MailmanWarmupUpdate (ctx context.Context, warmup *MailmanWarmup) error {
+	return this.Exec (ctx, nil, `UPDATE mailman_warmups SET phase = $2, phase_started = $3, completed = $4, status = $5 WHERE mailman = $1`, 
+									warmup.Mailman, warmup.Phase, warmup.PhaseStarted, warmup.Completed, warmup.Status)
+}
+
+// how the emails sent since the phase started have done
+func (this *Coldbrew) MailmanWarmupStats (ctx context.Context, mailmanId *uuid.UUID, since time.Time) (stats WarmupStats, err error) {
+	err = this.DB.QueryRow (ctx, `SELECT COUNT(*), COUNT(*) FILTER (WHERE status = ANY($3)), COUNT(*) FILTER (WHERE status = ANY($4))
+									FROM emails WHERE mailman = $1 AND sent_time >= $2`, mailmanId, since, 
+									[]EmailStatus { EmailStatus_delivered, EmailStatus_autoReply, EmailStatus_open, EmailStatus_click, EmailStatus_replied }, 
+									[]EmailStatus { EmailStatus_open, EmailStatus_click, EmailStatus_replied }).Scan(&stats.Sent, &stats.Delivered, &stats.Opened)
+	err = errors.WithStack (err)
+	return
+}
@@ -0,0 +1,35 @@
+package postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+	"time"
+)
+
+func TestQAWarmupPhaseDone (t *testing.T) {
+	phase := WarmupPhase { Days: 2, PerDay: 30, MinDeliveryRate: 0.9, MinOpenRate: 0.2 }
+	started := time.Date (2025, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	done, _ := phase.Done (started, started.Add (time.Hour * 30), WarmupStats { Sent: 60, Delivered: 60, Opened: 60 })
+	assert.False (t, done, "still has a day to go")
+
+	done, reason := phase.Done (started, started.Add (time.Hour * 48), WarmupStats { Sent: 60, Delivered: 50, Opened: 30 })
+	assert.False (t, done)
+	assert.Contains (t, reason, "delivery rate")
+
+	done, reason = phase.Done (started, started.Add (time.Hour * 48), WarmupStats { Sent: 60, Delivered: 58, Opened: 6 })
+	assert.False (t, done)
+	assert.Contains (t, reason, "open rate")
+
+	done, _ = phase.Done (started, started.Add (time.Hour * 48), WarmupStats { Sent: 60, Delivered: 58, Opened: 20 })
+	assert.True (t, done)
+
+	// nothing sent never passes a rate check
+	done, _ = phase.Done (started, started.Add (time.Hour * 72), WarmupStats{})
+	assert.False (t, done)
+
+	plan := DefaultWarmupPlan()
+	assert.NoError (t, plan.Attr.Valid())
+	assert.Error (t, (&WarmupPlanAttr { Phases: []WarmupPhase { { Days: 1, PerDay: 10, MinOpenRate: 2 } } }).Valid())
+}
@@ -56,6 +56,8 @@ func (this *SqlCFG) Connect () (*pgxpool.Pool, error) {
 		return nil, errors.Wrapf (err, "ip : %s", this.IP)
 	}
 
+	config.ConnConfig.Tracer = queryTracer{} // spans for every query
+
 	go func() {
 		cockDB, err = pgxpool.NewWithConfig (ctx, config)
 
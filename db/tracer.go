@@ -0,0 +1,44 @@
+/** ****************************************************************************************************************** **
+	Wraps every query in a span, pgx calls this for us once it's set on the connection config
+
+** ****************************************************************************************************************** **/
+
+package db
+
+import (
+	"coldbrew/tools/tracing"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"context"
+	"strings"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type queryTracer struct {}
+
+// names the span after the sql command, so they group nicely, ex: "db SELECT"
+func (this queryTracer) TraceQueryStart (ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	command, _, _ := strings.Cut (strings.TrimSpace (data.SQL), " ")
+
+	ctx, _ = tracing.Start (ctx, "db " + strings.ToUpper (command), trace.SpanKindClient,
+		attribute.String ("db.system", "postgresql"),
+		attribute.String ("db.statement", data.SQL),
+		attribute.String ("db.name", conn.Config().Database))
+	return ctx
+}
+
+func (this queryTracer) TraceQueryEnd (ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext (ctx)
+	defer span.End()
+
+	if data.Err != nil && data.Err != pgx.ErrNoRows { // no rows is an answer, not a failure
+		tracing.Error (span, data.Err)
+	}
+	span.SetAttributes (attribute.Int64 ("db.rows_affected", data.CommandTag.RowsAffected()))
+}
@@ -44,10 +44,94 @@ CREATE TABLE emails (
     "user"          UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
     status          TEXT NOT NULL,
     message_id      TEXT NOT NULL DEFAULT '',
+    send_state      TEXT NOT NULL DEFAULT 'queued',
+    attempts        INT NOT NULL DEFAULT 0,
+    last_error      TEXT NOT NULL DEFAULT '',
+    lease_owner     TEXT NOT NULL DEFAULT '',
+    lease_expires   TIMESTAMPTZ,
     mask            INT NOT NULL DEFAULT 0,
     created         TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
 CREATE INDEX idx_emails_message_id ON emails (message_id);
+CREATE INDEX idx_emails_unsent ON emails (target_time) WHERE sent_time IS NULL;
+CREATE INDEX idx_emails_send_state ON emails (send_state);
 CREATE INDEX idx_emails_target_time ON emails (target_time);
 CREATE INDEX idx_emails_sent_time ON emails (sent_time);
+
+CREATE TABLE warmup_plans (
+    id              UUID NOT NULL PRIMARY KEY,
+    name            TEXT NOT NULL,
+    attr            JSONB NOT NULL DEFAULT '{}',
+    mask            INT NOT NULL DEFAULT 0,
+    created         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_warmup_plans_name ON warmup_plans (name) WHERE mask & 1 = 0;
+
+-- where each mailman is in their warmup plan, a null plan is the built in default
+CREATE TABLE mailman_warmups (
+    mailman         UUID NOT NULL PRIMARY KEY REFERENCES mailmen (id) ON DELETE CASCADE,
+    plan            UUID REFERENCES warmup_plans (id),
+    phase           INT NOT NULL DEFAULT 0,
+    phase_started   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed       TIMESTAMPTZ,
+    status          TEXT NOT NULL DEFAULT '',
+    created         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- every time we hand an email to a provider, so the send caps can count them
+CREATE TABLE send_attempts (
+    id              UUID NOT NULL PRIMARY KEY,
+    email           UUID REFERENCES emails (id) ON DELETE SET NULL,
+    mailman         UUID NOT NULL,
+    domain          TEXT NOT NULL DEFAULT '',
+    account         TEXT NOT NULL DEFAULT '',
+    created         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_send_attempts_mailman ON send_attempts (mailman, created);
+CREATE INDEX idx_send_attempts_domain ON send_attempts (domain, created);
+CREATE INDEX idx_send_attempts_account ON send_attempts (account, created);
+CREATE INDEX idx_send_attempts_created ON send_attempts (created);
+
+CREATE TABLE email_events (
+    id              UUID NOT NULL PRIMARY KEY,
+    email           UUID REFERENCES emails (id) ON DELETE CASCADE,
+    provider        TEXT NOT NULL,
+    event_id        TEXT NOT NULL,
+    message_id      TEXT NOT NULL DEFAULT '',
+    type            TEXT NOT NULL,
+    event_time      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    reason          TEXT NOT NULL DEFAULT '',
+    url             TEXT NOT NULL DEFAULT '',
+    ip              TEXT NOT NULL DEFAULT '',
+    user_agent      TEXT NOT NULL DEFAULT '',
+    raw             JSONB NOT NULL DEFAULT '{}',
+    created         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (provider, event_id)
+);
+
+CREATE INDEX idx_email_events_email ON email_events (email);
+CREATE INDEX idx_email_events_type_time ON email_events (type, event_time);
+
+-- everything people send back to us, the ones that need a person stay unreviewed until someone looks at them
+CREATE TABLE email_replies (
+    id              UUID NOT NULL PRIMARY KEY,
+    email           UUID REFERENCES emails (id) ON DELETE SET NULL,
+    "user"          UUID REFERENCES users (id) ON DELETE SET NULL,
+    source          TEXT NOT NULL,
+    kind            TEXT NOT NULL,
+    message_id      TEXT NOT NULL DEFAULT '',
+    from_email      TEXT NOT NULL DEFAULT '',
+    to_email        TEXT NOT NULL DEFAULT '',
+    subject         TEXT NOT NULL DEFAULT '',
+    body_text       TEXT NOT NULL DEFAULT '',
+    attr            JSONB NOT NULL DEFAULT '{}',
+    reviewed        TIMESTAMPTZ,
+    created         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_email_replies_message_id ON email_replies (source, message_id) WHERE message_id <> '';
+CREATE INDEX idx_email_replies_unreviewed ON email_replies (created) WHERE reviewed IS NULL;
+CREATE INDEX idx_email_replies_email ON email_replies (email);
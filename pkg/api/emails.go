@@ -0,0 +1,95 @@
+/** ****************************************************************************************************************** **
+	Emails - admin view of the emails the QB gave up on sending, so they can be put back in the queue
+
+** ****************************************************************************************************************** **/
+
+package api
+
+import (
+	"coldbrew/db"
+	"coldbrew/db/postgres"
+	"coldbrew/tools"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"context"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+const deadEmailLimit = 500 // more than this and something bigger is wrong
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// what we return about an email
+type EmailResponse struct {
+	Id, Mailman, Template, User *uuid.UUID
+	Target time.Time
+	SendState postgres.EmailSendState
+	Attempts int
+	LastError tools.String
+}
+
+type EmailRequeueResponse struct {
+	Requeued int64
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func newEmailResponse (email *postgres.Email) *EmailResponse {
+	return &EmailResponse {
+		Id: email.Id,
+		Mailman: email.Mailman,
+		Template: email.Template,
+		User: email.User,
+		Target: email.Target,
+		SendState: email.SendState,
+		Attempts: email.Attempts,
+		LastError: email.LastError,
+	}
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- EMAILS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// lists the emails we stopped trying to send
+func (this *API) EmailsDead (ctx context.Context) ([]*EmailResponse, error) {
+	emails, err := this.db.EmailsDead (ctx, deadEmailLimit)
+	if err != nil { return nil, err }
+
+	ret := make([]*EmailResponse, 0, len(emails))
+	for _, email := range emails {
+		ret = append (ret, newEmailResponse (email))
+	}
+
+	return ret, nil
+}
+
+// puts a single dead email back in the queue
+func (this *API) EmailRequeue (ctx context.Context, emailId *uuid.UUID) error {
+	requeued, err := this.db.EmailRequeue (ctx, emailId)
+	if err != nil { return err }
+
+	if requeued == false {
+		return errors.WithStack (db.ErrKeyNotFound) // either doesn't exist or isn't dead
+	}
+
+	return nil
+}
+
+// puts all the dead emails back in the queue, usually after fixing whatever killed them
+func (this *API) EmailsRequeueDead (ctx context.Context) (*EmailRequeueResponse, error) {
+	cnt, err := this.db.EmailsRequeueDead (ctx)
+	if err != nil { return nil, err }
+
+	return &EmailRequeueResponse { Requeued: cnt }, nil
+}
@@ -0,0 +1,173 @@
+/** ****************************************************************************************************************** **
+	Mailmen - admin management of the senders we send emails through
+
+** ****************************************************************************************************************** **/
+
+package api
+
+import (
+	"coldbrew/db"
+	"coldbrew/db/postgres"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"context"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// what we return about a mailman, this never includes the api token or any other secrets
+type MailmanResponse struct {
+	Id *uuid.UUID
+	Attr postgres.MailmanAttr
+	HasAPIToken, HasSmtpPassword, HasSesSecretKey, HasWebhookKey, HasReplyImapPassword, Paused, TextWarm, HtmlWarm bool
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func newMailmanResponse (mailman *postgres.Mailman) *MailmanResponse {
+	return &MailmanResponse {
+		Id: mailman.Id,
+		Attr: mailman.Attr.Redacted(),
+		HasAPIToken: mailman.Attr.APIToken.Valid(),
+		HasSmtpPassword: mailman.Attr.SmtpPassword.Valid(),
+		HasSesSecretKey: mailman.Attr.SesSecretKey.Valid(),
+		HasWebhookKey: mailman.Attr.WebhookKey.Valid(),
+		HasReplyImapPassword: mailman.Attr.ReplyImapPassword.Valid(),
+		Paused: mailman.Mask & postgres.MailmanMask_paused > 0,
+		TextWarm: mailman.Mask & postgres.MailmanMask_textWarm > 0,
+		HtmlWarm: mailman.Mask & postgres.MailmanMask_htmlWarm > 0,
+	}
+}
+
+// gets the mailman, treating deleted ones as missing
+func (this *API) mailman (ctx context.Context, mailmanId *uuid.UUID) (*postgres.Mailman, error) {
+	mailman, err := this.db.Mailman (ctx, mailmanId)
+	if err != nil { return nil, err }
+
+	if mailman == nil || mailman.Mask & postgres.MailmanMask_deleted > 0 {
+		return nil, errors.WithStack (db.ErrKeyNotFound)
+	}
+
+	return mailman, nil
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- MAILMEN ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func (this *API) MailmanCreate (ctx context.Context, attr postgres.MailmanAttr, paused bool) (*MailmanResponse, error) {
+	if err := attr.Valid(); err != nil { return nil, err }
+
+	mailman := &postgres.Mailman {
+		Attr: attr,
+	}
+	if paused { mailman.Mask |= postgres.MailmanMask_paused }
+
+	if err := this.db.MailmanInsert (ctx, mailman); err != nil { return nil, err }
+
+	return newMailmanResponse (mailman), nil
+}
+
+func (this *API) MailmanList (ctx context.Context) ([]*MailmanResponse, error) {
+	mailmen, err := this.db.MailmanListAll (ctx)
+	if err != nil { return nil, err }
+
+	ret := make([]*MailmanResponse, 0, len(mailmen))
+	for _, mailman := range mailmen {
+		ret = append (ret, newMailmanResponse (mailman))
+	}
+
+	return ret, nil
+}
+
+func (this *API) MailmanGet (ctx context.Context, mailmanId *uuid.UUID) (*MailmanResponse, error) {
+	mailman, err := this.mailman (ctx, mailmanId)
+	if err != nil { return nil, err }
+
+	return newMailmanResponse (mailman), nil
+}
+
+// only the attributes that were passed in get updated, so the secrets can be left off
+func (this *API) MailmanUpdate (ctx context.Context, mailmanId *uuid.UUID, attr postgres.MailmanAttr) (*MailmanResponse, error) {
+	mailman, err := this.mailman (ctx, mailmanId)
+	if err != nil { return nil, err }
+
+	if len(attr.Provider) > 0 { mailman.Attr.Provider = attr.Provider }
+	if attr.IpPool.Valid() { mailman.Attr.IpPool = attr.IpPool }
+	if attr.FromEmail.Valid() { mailman.Attr.FromEmail = attr.FromEmail }
+	if attr.FromName.Valid() { mailman.Attr.FromName = attr.FromName }
+	if attr.ReplyEmail.Valid() { // the token setting goes with the reply email
+		mailman.Attr.ReplyEmail = attr.ReplyEmail
+		mailman.Attr.ReplyToken = attr.ReplyToken
+	}
+	if attr.ReplyName.Valid() { mailman.Attr.ReplyName = attr.ReplyName }
+	if attr.Category.Valid() { mailman.Attr.Category = attr.Category }
+	if attr.APIToken.Valid() { mailman.Attr.APIToken = attr.APIToken }
+	if attr.SmtpHost.Valid() { mailman.Attr.SmtpHost = attr.SmtpHost }
+	if attr.SmtpUser.Valid() { mailman.Attr.SmtpUser = attr.SmtpUser }
+	if attr.SmtpPort > 0 { mailman.Attr.SmtpPort = attr.SmtpPort }
+	if attr.SmtpPassword.Valid() { mailman.Attr.SmtpPassword = attr.SmtpPassword }
+	if attr.SesRegion.Valid() { mailman.Attr.SesRegion = attr.SesRegion }
+	if attr.SesAccessKey.Valid() { mailman.Attr.SesAccessKey = attr.SesAccessKey }
+	if attr.SesConfigurationSet.Valid() { mailman.Attr.SesConfigurationSet = attr.SesConfigurationSet }
+	if attr.SesSecretKey.Valid() { mailman.Attr.SesSecretKey = attr.SesSecretKey }
+	if attr.MailgunDomain.Valid() { // the region goes with the domain
+		mailman.Attr.MailgunDomain = attr.MailgunDomain
+		mailman.Attr.MailgunEU = attr.MailgunEU
+	}
+	if attr.PostmarkStream.Valid() { mailman.Attr.PostmarkStream = attr.PostmarkStream }
+	if attr.WebhookKey.Valid() { mailman.Attr.WebhookKey = attr.WebhookKey }
+	if attr.Account.Valid() { mailman.Attr.Account = attr.Account }
+	if attr.SendWindow != nil { 
+		mailman.Attr.SendWindow = attr.SendWindow 
+		if len(attr.SendWindow.Start) == 0 && len(attr.SendWindow.End) == 0 { mailman.Attr.SendWindow = nil } // an empty window turns it off
+	}
+	if attr.Throttle != nil {
+		mailman.Attr.Throttle = attr.Throttle
+		if len(attr.Throttle.Ramp) == 0 { mailman.Attr.Throttle = nil } // an empty ramp goes back to the qb's policy
+	}
+
+	if attr.ReplyImapHost.Valid() { mailman.Attr.ReplyImapHost = attr.ReplyImapHost }
+	if attr.ReplyImapUser.Valid() { mailman.Attr.ReplyImapUser = attr.ReplyImapUser }
+	if attr.ReplyImapPort > 0 { mailman.Attr.ReplyImapPort = attr.ReplyImapPort }
+	if attr.ReplyImapPassword.Valid() { mailman.Attr.ReplyImapPassword = attr.ReplyImapPassword }
+
+	// a negative cap removes it
+	if attr.MaxPerHour != 0 { mailman.Attr.MaxPerHour = max(attr.MaxPerHour, 0) }
+	if attr.MaxPerDay != 0 { mailman.Attr.MaxPerDay = max(attr.MaxPerDay, 0) }
+
+	if err := mailman.Attr.Valid(); err != nil { return nil, err }
+
+	if err := this.db.MailmanUpdate (ctx, mailman); err != nil { return nil, err }
+
+	return newMailmanResponse (mailman), nil
+}
+
+// pauses or resumes sending from this mailman
+func (this *API) MailmanPause (ctx context.Context, mailmanId *uuid.UUID, paused bool) (*MailmanResponse, error) {
+	mailman, err := this.mailman (ctx, mailmanId)
+	if err != nil { return nil, err }
+
+	if paused {
+		err = this.db.MailmanSetMask (ctx, mailman, postgres.MailmanMask_paused)
+	} else {
+		err = this.db.MailmanRemoveMask (ctx, mailman, postgres.MailmanMask_paused)
+	}
+	if err != nil { return nil, err }
+
+	return newMailmanResponse (mailman), nil
+}
+
+// soft delete, the emails this mailman already sent still reference it
+func (this *API) MailmanDelete (ctx context.Context, mailmanId *uuid.UUID) error {
+	mailman, err := this.mailman (ctx, mailmanId)
+	if err != nil { return err }
+
+	return this.db.MailmanSetMask (ctx, mailman, postgres.MailmanMask_deleted)
+}
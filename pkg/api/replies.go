@@ -0,0 +1,240 @@
+/** ****************************************************************************************************************** **
+	Replies - what people send back to us, threaded onto the email they're about
+	Replies count towards engagement right away, bounces and unsubscribe requests wait for someone to review them
+
+** ****************************************************************************************************************** **/
+
+package api
+
+import (
+	"coldbrew/db"
+	"coldbrew/db/postgres"
+	"coldbrew/tools"
+	"coldbrew/tools/logging"
+	"coldbrew/tools/mailer"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	json "github.com/json-iterator/go"
+
+	"context"
+	"log/slog"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// what to do about a reply once someone has looked at it
+type ReplyAction string
+const (
+	ReplyAction_none		= ReplyAction("") // nothing, it just comes off the list
+	ReplyAction_unsubscribe	= ReplyAction("unsubscribe")
+	ReplyAction_bounce		= ReplyAction("bounce")
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// what we return about a reply
+type ReplyResponse struct {
+	Id, Email, User *uuid.UUID
+	Source postgres.MailmanProvider
+	Kind postgres.EmailReplyKind
+	MessageId, From, To, Subject, Text tools.String
+	Headers map[string][]string
+	Attachments []postgres.EmailReplyAttachment
+	SpamScore float64
+	Reviewed *time.Time
+	Created time.Time
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func newReplyResponse (reply *postgres.EmailReply) *ReplyResponse {
+	return &ReplyResponse {
+		Id: reply.Id,
+		Email: reply.Email,
+		User: reply.User,
+		Source: reply.Source,
+		Kind: reply.Kind,
+		MessageId: reply.MessageId,
+		From: reply.FromEmail,
+		To: reply.ToEmail,
+		Subject: reply.Subject,
+		Text: reply.Text,
+		Headers: reply.Attr.Headers,
+		Attachments: reply.Attr.Attachments,
+		SpamScore: reply.Attr.SpamScore,
+		Reviewed: reply.Reviewed,
+		Created: reply.Created,
+	}
+}
+
+// who this is from as far as our users go, for a bounce that's who our original went to
+func replyAddress (msg *mailer.Received, kind postgres.EmailReplyKind) string {
+	if kind == postgres.EmailReplyKind_bounce {
+		if msg.Original == nil { return "" }
+		if list, _ := msg.Original.AddressList ("To"); len(list) > 0 { return list[0].Address }
+		return ""
+	}
+
+	if msg.From == nil { return "" }
+	return msg.From.Address
+}
+
+// counts it against the email the same as a reply we read over imap would
+func (this *API) replyRecord (ctx context.Context, email *postgres.Email, reply *postgres.EmailReply, msg *mailer.Received) error {
+	status := postgres.EmailStatus_replied
+	if reply.Kind == postgres.EmailReplyKind_autoReply { status = postgres.EmailStatus_autoReply }
+
+	event := &postgres.EmailEvent {
+		Provider: reply.Source,
+		EventId: reply.MessageId.String(),
+		MessageId: email.MessageId.String(),
+		Status: status,
+		Time: time.Now(),
+		Reason: reply.Subject,
+	}
+	if len(event.EventId) == 0 { event.EventId = reply.Id.String() }
+	if date, err := msg.Header.Date(); err == nil { event.Time = date }
+
+	var err error
+	event.Raw, err = json.Marshal (map[string]string {
+		"messageId": msg.MessageId,
+		"inReplyTo": msg.InReplyTo,
+		"from": reply.FromEmail.String(),
+		"subject": msg.Subject,
+	})
+	if err != nil { return errors.WithStack (err) }
+
+	_, err = this.db.EmailReplyRecord (ctx, email, event)
+	return err
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- REPLIES ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// saves an email someone sent back to us, matched up with the email and user it's about when we can
+// the same email coming in twice is ignored
+func (this *API) ReplyReceived (ctx context.Context, source postgres.MailmanProvider, msg *mailer.Received, to string, spamScore float64) error {
+	email, err := this.db.EmailForReply (ctx, msg.EmailId(), msg.ThreadIds(), msg.PlusTokens())
+	if err != nil { return err }
+
+	reply := &postgres.EmailReply {
+		Source: source,
+		Kind: postgres.EmailReplyKind(msg.Kind()),
+		Attr: postgres.EmailReplyAttr { Headers: msg.Header, SpamScore: spamScore },
+	}
+	reply.MessageId.Set (msg.MessageId)
+	reply.ToEmail.Set (to)
+	reply.Subject.Set (msg.Subject)
+	reply.Text.Set (msg.Text)
+	if msg.From != nil { reply.FromEmail.Set (msg.From.Address) }
+
+	for _, attachment := range msg.Attachments {
+		reply.Attr.Attachments = append (reply.Attr.Attachments, postgres.EmailReplyAttachment (*attachment))
+	}
+
+	if email != nil {
+		reply.Email, reply.User = email.Id, email.User
+	} else {
+		// it's not threaded onto anything, but we might still know who it's about
+		var address tools.String
+		address.Set (replyAddress (msg, reply.Kind))
+
+		if address.Valid() {
+			user, err := this.db.UserFromEmail (ctx, address)
+			if err != nil { return err }
+			if user != nil { reply.User = user.Id }
+		}
+	}
+
+	inserted, err := this.db.EmailReplyInsert (ctx, reply)
+	if err != nil || inserted == false { return err }
+
+	slog.Info ("reply received", slog.String("source", string(source)), slog.String("kind", string(reply.Kind)),
+				slog.String("from", reply.FromEmail.String()), slog.Bool("matched", email != nil))
+
+	// bounces and unsubscribes could be wrong, so those wait for someone to review them
+	if email == nil { return nil }
+	switch reply.Kind {
+	case postgres.EmailReplyKind_reply, postgres.EmailReplyKind_autoReply:
+		return this.replyRecord (ctx, email, reply, msg)
+	}
+
+	return nil
+}
+
+// lists the replies waiting on someone, or all of them
+func (this *API) ReplyList (ctx context.Context, kind postgres.EmailReplyKind, all bool) ([]*ReplyResponse, error) {
+	replies, err := this.db.EmailReplies (ctx, kind, all)
+	if err != nil { return nil, err }
+
+	ret := make([]*ReplyResponse, 0, len(replies))
+	for _, reply := range replies {
+		ret = append (ret, newReplyResponse (reply))
+	}
+
+	return ret, nil
+}
+
+func (this *API) ReplyGet (ctx context.Context, replyId *uuid.UUID) (*ReplyResponse, error) {
+	reply, err := this.db.EmailReply (ctx, replyId)
+	if err != nil { return nil, err }
+	if reply == nil { return nil, errors.WithStack (db.ErrKeyNotFound) }
+
+	return newReplyResponse (reply), nil
+}
+
+// takes it off the list, unsubscribing or bouncing the user first if that's what they decided
+func (this *API) ReplyReview (ctx context.Context, replyId *uuid.UUID, action ReplyAction) (*ReplyResponse, error) {
+	reply, err := this.db.EmailReply (ctx, replyId)
+	if err != nil { return nil, err }
+	if reply == nil { return nil, errors.WithStack (db.ErrKeyNotFound) }
+
+	if reply.Reviewed != nil { return nil, errors.Wrap (logging.ErrReturnToUser, "this reply was already reviewed") }
+
+	if action != ReplyAction_none {
+		status := postgres.EmailStatus(action)
+
+		var email *postgres.Email
+		if reply.Email != nil {
+			email, err = this.db.Email (ctx, reply.Email)
+			if err != nil { return nil, err }
+		}
+
+		if email != nil {
+			// the same as if the provider had told us, so the email and the user both get it
+			_, err = this.db.EmailReplyRecord (ctx, email, &postgres.EmailEvent {
+				Provider: reply.Source,
+				EventId: "review:" + reply.Id.String(),
+				MessageId: email.MessageId.String(),
+				Status: status,
+				Time: time.Now(),
+				Reason: reply.Subject,
+			})
+			if err != nil { return nil, err }
+
+		} else {
+			if reply.User == nil { return nil, errors.Wrap (logging.ErrReturnToUser, "we don't know which user this reply is about") }
+
+			user, err := this.db.User (ctx, reply.User)
+			if err != nil { return nil, err }
+			if user == nil { return nil, errors.Wrap (logging.ErrReturnToUser, "the user for this reply no longer exists") }
+
+			if err := this.db.UserUpdateStatus (ctx, user.Email, string(status)); err != nil { return nil, err }
+		}
+	}
+
+	reviewed, err := this.db.EmailReplyReviewed (ctx, reply)
+	if err != nil { return nil, err }
+	if reviewed == false { return nil, errors.Wrap (logging.ErrReturnToUser, "this reply was already reviewed") }
+
+	return newReplyResponse (reply), nil
+}
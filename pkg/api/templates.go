@@ -0,0 +1,214 @@
+/** ****************************************************************************************************************** **
+	Templates - admin management of the emails we send, with a render preview so bad ones never make it to the QB
+
+** ****************************************************************************************************************** **/
+
+package api
+
+import (
+	"coldbrew/db"
+	"coldbrew/db/postgres"
+	"coldbrew/tools"
+	"coldbrew/tools/logging"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"context"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// what we return about a template
+type TemplateResponse struct {
+	Id *uuid.UUID
+	Subject, Preview, Text, Html tools.String
+	Warmup, Paused bool
+}
+
+// the fully rendered version of a template for a single user
+type TemplatePreview struct {
+	Subject, Preview, Text, Html string
+}
+
+// content for creating a template
+type TemplateContent struct {
+	Subject, Preview, Text, Html tools.String
+}
+
+// content for updating a template, anything left off stays the same and an empty string clears it
+type TemplatePatch struct {
+	Subject, Preview, Text, Html *tools.String
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func newTemplateResponse (template *postgres.Template) *TemplateResponse {
+	return &TemplateResponse {
+		Id: template.Id,
+		Subject: template.Subject,
+		Preview: template.Preview,
+		Text: template.Body,
+		Html: template.Html,
+		Warmup: template.Mask & postgres.TemplateMask_warmup > 0,
+		Paused: template.Mask & postgres.TemplateMask_paused > 0,
+	}
+}
+
+// stand in user for rendering a template when we don't have a real one
+func sampleUser () *postgres.User {
+	user := &postgres.User{}
+	user.Email.Set ("sample@example.com")
+	user.Token.Set ("sample-token")
+	return user
+}
+
+// gets the template, treating deleted ones as missing
+func (this *API) template (ctx context.Context, templateId *uuid.UUID) (*postgres.Template, error) {
+	template, err := this.db.Template (ctx, templateId)
+	if err != nil { return nil, err }
+
+	if template == nil || template.Mask & postgres.TemplateMask_deleted > 0 {
+		return nil, errors.WithStack (db.ErrKeyNotFound)
+	}
+
+	return template, nil
+}
+
+// renders everything in the template, any failure here is something the user needs to fix
+func (this *API) templateRender (template *postgres.Template, user *postgres.User) (*TemplatePreview, error) {
+	var err error
+	ret := &TemplatePreview {
+		Preview: template.Preview.String(),
+	}
+
+	ret.Subject, err = template.GenerateSubject (this.apiUrl.String(), user)
+	if err != nil { return nil, errors.Wrapf (logging.ErrReturnToUser, "subject failed to render : %s", err.Error()) }
+
+	ret.Text, err = template.GenerateTextBody (this.apiUrl.String(), user)
+	if err != nil { return nil, errors.Wrapf (logging.ErrReturnToUser, "text body failed to render : %s", err.Error()) }
+
+	ret.Html, err = template.GenerateHTMLBody (this.apiUrl.String(), user)
+	if err != nil { return nil, errors.Wrapf (logging.ErrReturnToUser, "html body failed to render : %s", err.Error()) }
+
+	return ret, nil
+}
+
+// makes sure we have enough to send and that it all renders
+func (this *API) templateValid (template *postgres.Template) error {
+	if template.Subject.Valid() == false {
+		return errors.Wrap (logging.ErrReturnToUser, "Subject is required")
+	}
+
+	if template.Body.Valid() == false && template.Html.Valid() == false {
+		return errors.Wrap (logging.ErrReturnToUser, "Text or Html is required")
+	}
+
+	_, err := this.templateRender (template, sampleUser())
+	return err
+}
+
+// sets or clears the masks the user is allowed to control
+func (this *API) templateMask (ctx context.Context, template *postgres.Template, mask postgres.TemplateMask, on bool) error {
+	if on {
+		return this.db.TemplateSetMask (ctx, template, mask)
+	}
+	return this.db.TemplateRemoveMask (ctx, template, mask)
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- TEMPLATES -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func (this *API) TemplateCreate (ctx context.Context, content TemplateContent, warmup, paused bool) (*TemplateResponse, error) {
+	template := &postgres.Template {
+		Subject: content.Subject,
+		Preview: content.Preview,
+		Body: content.Text,
+		Html: content.Html,
+	}
+	if warmup { template.Mask |= postgres.TemplateMask_warmup }
+	if paused { template.Mask |= postgres.TemplateMask_paused }
+
+	if err := this.templateValid (template); err != nil { return nil, err }
+
+	if err := this.db.TemplateInsert (ctx, template); err != nil { return nil, err }
+
+	return newTemplateResponse (template), nil
+}
+
+func (this *API) TemplateList (ctx context.Context) ([]*TemplateResponse, error) {
+	templates, err := this.db.TemplateListAll (ctx)
+	if err != nil { return nil, err }
+
+	ret := make([]*TemplateResponse, 0, len(templates))
+	for _, template := range templates {
+		ret = append (ret, newTemplateResponse (template))
+	}
+
+	return ret, nil
+}
+
+func (this *API) TemplateGet (ctx context.Context, templateId *uuid.UUID) (*TemplateResponse, error) {
+	template, err := this.template (ctx, templateId)
+	if err != nil { return nil, err }
+
+	return newTemplateResponse (template), nil
+}
+
+// only the content that was passed in gets updated, warmup and paused are only changed when they're set
+func (this *API) TemplateUpdate (ctx context.Context, templateId *uuid.UUID, content TemplatePatch, warmup, paused *bool) (*TemplateResponse, error) {
+	template, err := this.template (ctx, templateId)
+	if err != nil { return nil, err }
+
+	if content.Subject != nil { template.Subject = *content.Subject }
+	if content.Preview != nil { template.Preview = *content.Preview }
+	if content.Text != nil { template.Body = *content.Text }
+	if content.Html != nil { template.Html = *content.Html }
+
+	// don't save anything that won't render
+	if err := this.templateValid (template); err != nil { return nil, err }
+
+	if err := this.db.TemplateUpdate (ctx, template); err != nil { return nil, err }
+
+	if warmup != nil {
+		if err := this.templateMask (ctx, template, postgres.TemplateMask_warmup, *warmup); err != nil { return nil, err }
+	}
+
+	if paused != nil {
+		if err := this.templateMask (ctx, template, postgres.TemplateMask_paused, *paused); err != nil { return nil, err }
+	}
+
+	return newTemplateResponse (template), nil
+}
+
+// soft delete, the emails already queued with this template still reference it
+func (this *API) TemplateDelete (ctx context.Context, templateId *uuid.UUID) error {
+	template, err := this.template (ctx, templateId)
+	if err != nil { return err }
+
+	return this.db.TemplateSetMask (ctx, template, postgres.TemplateMask_deleted)
+}
+
+// renders the template for a real user if we're given one, otherwise a sample one with whatever attributes were passed
+func (this *API) TemplatePreview (ctx context.Context, templateId, userId *uuid.UUID, email tools.String, attr postgres.UserAttr) (*TemplatePreview, error) {
+	template, err := this.template (ctx, templateId)
+	if err != nil { return nil, err }
+
+	user := sampleUser()
+	user.Attr = attr
+
+	if userId != nil {
+		user, err = this.db.User (ctx, userId)
+	} else if email.Valid() {
+		user, err = this.db.UserFromEmail (ctx, email)
+	}
+	if err != nil { return nil, err }
+	if user == nil { return nil, errors.Wrap (logging.ErrReturnToUser, "user not found") }
+
+	return this.templateRender (template, user)
+}
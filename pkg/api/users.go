@@ -7,6 +7,7 @@ package api
 
 import (
 	"coldbrew/tools"
+	"coldbrew/db/postgres"
 	
 	"context"
 )
@@ -15,6 +16,12 @@ import (
  //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
 
+// a user to add, along with any attributes to use as merge fields
+type NewUser struct {
+	Email tools.String
+	Attr postgres.UserAttr
+}
+
   //-----------------------------------------------------------------------------------------------------------------------//
  //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
@@ -23,9 +30,9 @@ import (
  //----- USERS -----------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
 
-func (this *API) AddUsers (ctx context.Context, warmup bool, emails tools.StringList, skipValidation bool) (interface{}, error) {
-	for _, email := range emails {
-		if err := this.db.UserInsert (ctx, warmup, email, skipValidation); err != nil { return nil, err }
+func (this *API) AddUsers (ctx context.Context, warmup bool, users []NewUser, skipValidation bool) (interface{}, error) {
+	for _, user := range users {
+		if err := this.db.UserInsert (ctx, warmup, user.Email, user.Attr, skipValidation); err != nil { return nil, err }
 	}
 	return nil, nil
 }
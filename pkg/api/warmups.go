@@ -0,0 +1,197 @@
+/** ****************************************************************************************************************** **
+	Warmups - admin management of the warmup plans, and where each mailman is in theirs
+
+** ****************************************************************************************************************** **/
+
+package api
+
+import (
+	"coldbrew/db"
+	"coldbrew/db/postgres"
+	"coldbrew/tools"
+	"coldbrew/tools/logging"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"context"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type WarmupPlanResponse struct {
+	Id *uuid.UUID // nil for the built in plan
+	Name tools.String
+	Phases []postgres.WarmupPhase
+}
+
+// where a mailman is in their plan
+type MailmanWarmupResponse struct {
+	Plan *WarmupPlanResponse
+	Phase int // index into the plan's phases
+	PhaseName tools.String
+	PhaseStarted time.Time
+	Completed *time.Time
+	Status tools.String
+	Started bool // false until the qb gets to this mailman
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func newWarmupPlanResponse (plan *postgres.WarmupPlan) *WarmupPlanResponse {
+	return &WarmupPlanResponse {
+		Id: plan.Id,
+		Name: plan.Name,
+		Phases: plan.Attr.Phases,
+	}
+}
+
+// gets the plan, treating deleted ones as missing
+func (this *API) warmupPlan (ctx context.Context, planId *uuid.UUID) (*postgres.WarmupPlan, error) {
+	plan, err := this.db.WarmupPlan (ctx, planId)
+	if err != nil { return nil, err }
+
+	if plan == nil || plan.Mask & postgres.WarmupPlanMask_deleted > 0 {
+		return nil, errors.WithStack (db.ErrKeyNotFound)
+	}
+
+	return plan, nil
+}
+
+// the plan a mailman would use, same order the qb looks for it
+func (this *API) mailmanWarmupPlan (ctx context.Context, planId *uuid.UUID) (*postgres.WarmupPlan, error) {
+	if planId != nil {
+		plan, err := this.db.WarmupPlan (ctx, planId)
+		if err != nil || plan != nil { return plan, err }
+	}
+
+	plan, err := this.db.WarmupPlanByName (ctx, postgres.WarmupPlan_default)
+	if err != nil || plan != nil { return plan, err }
+
+	return postgres.DefaultWarmupPlan(), nil
+}
+
+func (this *API) warmupPlanSave (ctx context.Context, plan *postgres.WarmupPlan, insert bool) error {
+	var err error
+	if insert {
+		err = this.db.WarmupPlanInsert (ctx, plan)
+	} else {
+		err = this.db.WarmupPlanUpdate (ctx, plan)
+	}
+
+	if this.db.ErrUniqueConstraint (err) {
+		return errors.Wrapf (logging.ErrReturnToUser, "there's already a plan named %s", plan.Name)
+	}
+	return err
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PLANS -----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func (this *API) WarmupPlanCreate (ctx context.Context, name tools.String, attr postgres.WarmupPlanAttr) (*WarmupPlanResponse, error) {
+	if name.Valid() == false { return nil, errors.Wrap (logging.ErrReturnToUser, "Name is required") }
+	if err := attr.Valid(); err != nil { return nil, err }
+
+	plan := &postgres.WarmupPlan {
+		Name: name,
+		Attr: attr,
+	}
+
+	if err := this.warmupPlanSave (ctx, plan, true); err != nil { return nil, err }
+
+	return newWarmupPlanResponse (plan), nil
+}
+
+func (this *API) WarmupPlanList (ctx context.Context) ([]*WarmupPlanResponse, error) {
+	plans, err := this.db.WarmupPlanList (ctx)
+	if err != nil { return nil, err }
+
+	ret := make([]*WarmupPlanResponse, 0, len(plans))
+	for _, plan := range plans {
+		ret = append (ret, newWarmupPlanResponse (plan))
+	}
+
+	return ret, nil
+}
+
+func (this *API) WarmupPlanGet (ctx context.Context, planId *uuid.UUID) (*WarmupPlanResponse, error) {
+	plan, err := this.warmupPlan (ctx, planId)
+	if err != nil { return nil, err }
+
+	return newWarmupPlanResponse (plan), nil
+}
+
+// the name and phases are only changed when they're passed in
+// mailmen already on this plan pick up the new phases on their next round
+func (this *API) WarmupPlanUpdate (ctx context.Context, planId *uuid.UUID, name tools.String, attr postgres.WarmupPlanAttr) (*WarmupPlanResponse, error) {
+	plan, err := this.warmupPlan (ctx, planId)
+	if err != nil { return nil, err }
+
+	if name.Valid() { plan.Name = name }
+	if len(attr.Phases) > 0 {
+		if err := attr.Valid(); err != nil { return nil, err }
+		plan.Attr = attr
+	}
+
+	if err := this.warmupPlanSave (ctx, plan, false); err != nil { return nil, err }
+
+	return newWarmupPlanResponse (plan), nil
+}
+
+// soft delete, mailmen already on it finish it out
+func (this *API) WarmupPlanDelete (ctx context.Context, planId *uuid.UUID) error {
+	plan, err := this.warmupPlan (ctx, planId)
+	if err != nil { return err }
+
+	return this.db.WarmupPlanSetMask (ctx, plan, postgres.WarmupPlanMask_deleted)
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- MAILMEN ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func (this *API) MailmanWarmupGet (ctx context.Context, mailmanId *uuid.UUID) (*MailmanWarmupResponse, error) {
+	mailman, err := this.mailman (ctx, mailmanId)
+	if err != nil { return nil, err }
+
+	warmup, err := this.db.MailmanWarmup (ctx, mailman.Id)
+	if err != nil { return nil, err }
+
+	ret := &MailmanWarmupResponse{}
+	if warmup == nil { warmup = &postgres.MailmanWarmup{} } // they'll get the default when they start
+	ret.Started = warmup.Mailman != nil
+
+	plan, err := this.mailmanWarmupPlan (ctx, warmup.Plan)
+	if err != nil { return nil, err }
+
+	ret.Plan = newWarmupPlanResponse (plan)
+	ret.Phase = warmup.Phase
+	ret.PhaseStarted = warmup.PhaseStarted
+	ret.Completed = warmup.Completed
+	ret.Status = warmup.Status
+	if warmup.Phase < len(plan.Attr.Phases) { ret.PhaseName = plan.Attr.Phases[warmup.Phase].Name }
+
+	return ret, nil
+}
+
+// puts the mailman on this plan from the start, even if they already finished one
+func (this *API) MailmanWarmupSet (ctx context.Context, mailmanId, planId *uuid.UUID) (*MailmanWarmupResponse, error) {
+	mailman, err := this.mailman (ctx, mailmanId)
+	if err != nil { return nil, err }
+
+	plan, err := this.warmupPlan (ctx, planId)
+	if err != nil { return nil, err }
+
+	if err := this.db.MailmanWarmupStart (ctx, &postgres.MailmanWarmup { Mailman: mailman.Id, Plan: plan.Id }); err != nil { return nil, err }
+
+	// back to text only until the plan says otherwise
+	if err := this.db.MailmanRemoveMask (ctx, mailman, postgres.MailmanMask_htmlWarm); err != nil { return nil, err }
+
+	return this.MailmanWarmupGet (ctx, mailman.Id)
+}
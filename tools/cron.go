@@ -0,0 +1,143 @@
+/** ****************************************************************************************************************** **
+	Standard 5 field cron expressions, minute hour day-of-month month day-of-week
+	Supports *, lists, ranges and steps. Prefix with CRON_TZ=<zone> to run in a time zone other than UTC
+	ex: "CRON_TZ=America/Chicago 0 6 * * 1-5" is 6am central on weekdays
+
+** ****************************************************************************************************************** **/
+
+package tools
+
+import (
+	"github.com/pkg/errors"
+
+	"strconv"
+	"strings"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type Cron struct {
+	minute, hour, dom, month, dow uint64 // bit sets of the allowed values
+	domAny, dowAny bool // cron treats the day fields differently when one is a *
+	loc *time.Location
+}
+
+// the allowed range for each of the fields, in order
+var cronBounds = [5][2]int { { 0, 59 }, { 0, 23 }, { 1, 31 }, { 1, 12 }, { 0, 7 } }
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// parses a single field into a bit set
+func cronField (field string, min, max int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split (field, ",") {
+		step := 1
+		if rng, s, ok := strings.Cut (part, "/"); ok {
+			n, err := strconv.Atoi (s)
+			if err != nil || n < 1 { return 0, errors.Errorf ("invalid cron step : %s", part) }
+			step, part = n, rng
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			a, b, isRange := strings.Cut (part, "-")
+
+			var err error
+			lo, err = strconv.Atoi (a)
+			if err != nil { return 0, errors.Errorf ("invalid cron value : %s", part) }
+
+			hi = lo
+			if isRange {
+				hi, err = strconv.Atoi (b)
+				if err != nil { return 0, errors.Errorf ("invalid cron range : %s", part) }
+			} else if step > 1 {
+				hi = max // 5/15 means starting at 5, every 15
+			}
+		}
+
+		if lo < min || hi > max || lo > hi { return 0, errors.Errorf ("cron value out of range : %s", part) }
+
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+func (this *Cron) dayMatches (t time.Time) bool {
+	dom := this.dom & (1 << uint(t.Day())) > 0
+	dow := this.dow & (1 << uint(t.Weekday())) > 0
+
+	// when both are restricted, either one matching is enough
+	if this.domAny || this.dowAny { return dom && dow }
+	return dom || dow
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func ParseCron (spec string) (*Cron, error) {
+	ret := &Cron { loc: time.UTC }
+
+	spec = strings.TrimSpace (spec)
+	if strings.HasPrefix (spec, "CRON_TZ=") {
+		zone, rest, _ := strings.Cut (spec, " ")
+
+		loc, err := time.LoadLocation (strings.TrimPrefix (zone, "CRON_TZ="))
+		if err != nil { return nil, errors.Wrapf (err, "cron : %s", spec) }
+
+		ret.loc, spec = loc, rest
+	}
+
+	fields := strings.Fields (spec)
+	if len(fields) != 5 { return nil, errors.Errorf ("cron needs 5 fields : %s", spec) }
+
+	out := [5]*uint64 { &ret.minute, &ret.hour, &ret.dom, &ret.month, &ret.dow }
+	for i, field := range fields {
+		bits, err := cronField (field, cronBounds[i][0], cronBounds[i][1])
+		if err != nil { return nil, errors.Wrap (err, spec) }
+		*out[i] = bits
+	}
+
+	if ret.dow & (1 << 7) > 0 { ret.dow |= 1 } // 7 is also sunday
+	ret.domAny = fields[2] == "*"
+	ret.dowAny = fields[4] == "*"
+
+	return ret, nil
+}
+
+// the next time after this one that matches the expression, zero if there isn't one (ex: feb 30th)
+func (this *Cron) Next (after time.Time) time.Time {
+	t := after.In (this.loc).Truncate (time.Minute).Add (time.Minute)
+	limit := t.AddDate (5, 0, 0)
+
+	for t.Before (limit) {
+		if this.month & (1 << uint(t.Month())) == 0 {
+			t = time.Date (t.Year(), t.Month() + 1, 1, 0, 0, 0, 0, this.loc)
+			continue
+		}
+		if this.dayMatches (t) == false {
+			t = time.Date (t.Year(), t.Month(), t.Day() + 1, 0, 0, 0, 0, this.loc)
+			continue
+		}
+		if this.hour & (1 << uint(t.Hour())) == 0 {
+			t = time.Date (t.Year(), t.Month(), t.Day(), t.Hour() + 1, 0, 0, 0, this.loc)
+			continue
+		}
+		if this.minute & (1 << uint(t.Minute())) == 0 {
+			t = t.Add (time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
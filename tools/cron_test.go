@@ -0,0 +1,51 @@
+package tools
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+	"time"
+)
+
+func TestQACronNext (t *testing.T) {
+	chicago, err := time.LoadLocation ("America/Chicago")
+	TestingStackTrace (t, err)
+
+	start := time.Date (2026, 10, 16, 12, 30, 0, 0, time.UTC) // a friday
+
+	tests := map[string]struct {
+		spec string
+		expected time.Time
+	} {
+		"every minute": { spec: "* * * * *", expected: time.Date (2026, 10, 16, 12, 31, 0, 0, time.UTC) },
+		"every 15": { spec: "*/15 * * * *", expected: time.Date (2026, 10, 16, 12, 45, 0, 0, time.UTC) },
+		"top of the hour": { spec: "0 * * * *", expected: time.Date (2026, 10, 16, 13, 0, 0, 0, time.UTC) },
+		"list": { spec: "10,40 12 * * *", expected: time.Date (2026, 10, 16, 12, 40, 0, 0, time.UTC) },
+		"tomorrow": { spec: "0 6 * * *", expected: time.Date (2026, 10, 17, 6, 0, 0, 0, time.UTC) },
+		"weekdays": { spec: "0 6 * * 1-5", expected: time.Date (2026, 10, 19, 6, 0, 0, 0, time.UTC) },
+		"sunday as 7": { spec: "0 6 * * 7", expected: time.Date (2026, 10, 18, 6, 0, 0, 0, time.UTC) },
+		"day of month": { spec: "0 0 1 * *", expected: time.Date (2026, 11, 1, 0, 0, 0, 0, time.UTC) },
+		"dom or dow": { spec: "0 0 1 * 6", expected: time.Date (2026, 10, 17, 0, 0, 0, 0, time.UTC) },
+		"next year": { spec: "0 0 1 1 *", expected: time.Date (2027, 1, 1, 0, 0, 0, 0, time.UTC) },
+		"time zone": { spec: "CRON_TZ=America/Chicago 0 6 * * *", expected: time.Date (2026, 10, 17, 6, 0, 0, 0, chicago) },
+	}
+
+	for name, tc := range tests {
+		cron, err := ParseCron (tc.spec)
+		TestingStackTrace (t, err)
+
+		assert.True (t, tc.expected.Equal (cron.Next (start)), "%s : %s : %s", name, tc.expected, cron.Next (start))
+	}
+
+	// never happens
+	cron, err := ParseCron ("0 0 30 2 *")
+	TestingStackTrace (t, err)
+	assert.True (t, cron.Next (start).IsZero())
+}
+
+func TestQACronInvalid (t *testing.T) {
+	for _, spec := range []string { "", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "a * * * *", "CRON_TZ=Nowhere/Else * * * * *" } {
+		_, err := ParseCron (spec)
+		assert.Error (t, err, spec)
+	}
+}
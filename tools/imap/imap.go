@@ -0,0 +1,414 @@
+/** ****************************************************************************************************************** **
+	Just enough of an IMAP4rev1 client to read through a mailbox, used by the warmup seeds and for reading replies
+
+	Everything goes through UIDs, so the sequence numbers shifting under us from a move or expunge doesn't matter
+
+** ****************************************************************************************************************** **/
+
+package imap
+
+import (
+	"github.com/pkg/errors"
+
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+const (
+	DefaultPort = 993 // imaps, anything else has to offer STARTTLS or we won't log in
+
+	Inbox = "INBOX"
+
+	FlagSeen = `\Seen`
+	FlagAnswered = `\Answered`
+	FlagDeleted = `\Deleted`
+
+	// the special use attribute servers put on their spam folder
+	AttrJunk = `\Junk`
+
+	// we're pulling in whole emails, nothing we deal with should be anywhere near this
+	maxLiteral = 25 << 20
+)
+
+var literalRegex = regexp.MustCompile (`\{(\d+)\+?\}$`)
+var uidRegex = regexp.MustCompile (`\bUID (\d+)\b`)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// where we log in
+type Account struct {
+	Host string
+	Port int
+	Username, Password string
+
+	TLSConfig *tls.Config `json:"-"` // optional, defaults to verifying the host
+}
+
+// a mailbox on the server, what the RFC calls them, most clients call them folders
+type Folder struct {
+	Name string
+	Attributes []string
+}
+
+// the server told us no
+type Error struct {
+	Status, Text string
+}
+
+func (this *Error) Error () string {
+	return fmt.Sprintf ("imap %s : %s", this.Status, this.Text)
+}
+
+// an open, logged in connection
+type Client struct {
+	conn net.Conn
+	r *bufio.Reader
+	tag int
+	caps map[string]bool
+}
+
+// a single line from the server, with any literals pulled out of it
+type response struct {
+	tag, text string // tag is * for untagged and + for continuations
+	literals [][]byte
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func (this *Account) addr () string {
+	port := this.Port
+	if port == 0 { port = DefaultPort }
+	return net.JoinHostPort (this.Host, fmt.Sprint(port))
+}
+
+func (this *Account) tlsConfig () *tls.Config {
+	if this.TLSConfig != nil { return this.TLSConfig }
+	return &tls.Config { ServerName: this.Host }
+}
+
+// reads a full response, literals can show up anywhere so we keep going until a line doesn't end in one
+func (this *Client) read () (*response, error) {
+	resp := &response{}
+	text := ""
+
+	for {
+		line, err := this.r.ReadString ('\n')
+		if err != nil { return nil, errors.Wrap (err, "imap read") }
+		line = strings.TrimRight (line, "\r\n")
+
+		match := literalRegex.FindStringSubmatch (line)
+		if match == nil {
+			text += line
+			break
+		}
+
+		size, _ := strconv.Atoi (match[1])
+		if size > maxLiteral { return nil, errors.Errorf ("imap literal too large : %d", size) }
+
+		literal := make([]byte, size)
+		if _, err := io.ReadFull (this.r, literal); err != nil { return nil, errors.Wrap (err, "imap read literal") }
+
+		text += line[:len(line) - len(match[0])] + "{}" // leave a marker where it was
+		resp.literals = append (resp.literals, literal)
+	}
+
+	resp.tag, resp.text, _ = strings.Cut (text, " ")
+	return resp, nil
+}
+
+// sends the command and returns the untagged responses that came back with it
+func (this *Client) command (format string, args ...interface{}) ([]*response, error) {
+	this.tag++
+	tag := fmt.Sprintf ("a%d", this.tag)
+	cmd := fmt.Sprintf (format, args...)
+
+	if _, err := fmt.Fprintf (this.conn, "%s %s\r\n", tag, cmd); err != nil { return nil, errors.Wrap (err, "imap write") }
+
+	untagged := make([]*response, 0)
+	for {
+		resp, err := this.read()
+		if err != nil { return nil, err }
+
+		switch resp.tag {
+		case "*":
+			untagged = append (untagged, resp)
+		case tag:
+			status, text, _ := strings.Cut (resp.text, " ")
+			if strings.EqualFold (status, "OK") { return untagged, nil }
+
+			// don't let the password end up in our logs
+			if strings.HasPrefix (cmd, "LOGIN ") { cmd = "LOGIN" }
+			return untagged, errors.Wrap (&Error { Status: strings.ToUpper (status), Text: text }, cmd)
+		}
+		// continuations we don't need, we never send literals
+	}
+}
+
+// asks the server what it can do, this changes after starttls and logging in
+func (this *Client) capabilities () error {
+	untagged, err := this.command ("CAPABILITY")
+	if err != nil { return err }
+
+	this.caps = make(map[string]bool)
+	for _, resp := range untagged {
+		if fields := strings.Fields (resp.text); len(fields) > 0 && strings.EqualFold (fields[0], "CAPABILITY") {
+			for _, cap := range fields[1:] {
+				this.caps[strings.ToUpper (cap)] = true
+			}
+		}
+	}
+	return nil
+}
+
+// the greeting is the first thing the server says
+func (this *Client) greeting () error {
+	resp, err := this.read()
+	if err != nil { return err }
+
+	if status, text, _ := strings.Cut (resp.text, " "); strings.EqualFold (status, "OK") == false {
+		return errors.WithStack (&Error { Status: strings.ToUpper (status), Text: text })
+	}
+	return nil
+}
+
+// pulls a section of the message, empty is the whole thing
+func (this *Client) fetch (uid uint32, section string) ([]byte, error) {
+	untagged, err := this.command ("UID FETCH %d BODY.PEEK[%s]", uid, section)
+	if err != nil { return nil, err }
+
+	for _, resp := range untagged {
+		if len(resp.literals) == 0 { continue } // flag updates can come through here too
+
+		if match := uidRegex.FindStringSubmatch (resp.text); match != nil && match[1] == strconv.FormatUint (uint64(uid), 10) {
+			return resp.literals[0], nil
+		}
+	}
+
+	return nil, errors.Errorf ("imap message not found : %d", uid)
+}
+
+func uidSet (uids []uint32) string {
+	set := make([]string, len(uids))
+	for i, uid := range uids {
+		set[i] = strconv.FormatUint (uint64(uid), 10)
+	}
+	return strings.Join (set, ",")
+}
+
+// splits a LIST response into its parts, ex: (\HasNoChildren \Junk) "/" "Spam"
+func parseList (resp *response) (*Folder, bool) {
+	text := resp.text
+	if len(text) < 5 || strings.EqualFold (text[:5], "LIST ") == false { return nil, false }
+	text = text[5:]
+
+	attrs, rest, ok := strings.Cut (strings.TrimPrefix (text, "("), ")")
+	if ok == false { return nil, false }
+
+	// the delimiter is quoted or NIL, then the name is whatever is left
+	rest = strings.TrimSpace (rest)
+	if strings.HasPrefix (rest, `"`) {
+		if end := strings.Index (rest[1:], `"`); end >= 0 { rest = rest[end + 2:] }
+	} else {
+		_, rest, _ = strings.Cut (rest, " ")
+	}
+	name := strings.TrimSpace (rest)
+
+	if name == "{}" && len(resp.literals) > 0 {
+		name = string(resp.literals[0])
+	} else {
+		name = Unquote (name)
+	}
+
+	return &Folder { Name: name, Attributes: strings.Fields (attrs) }, true
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// search criteria for messages that arrived on or after the day of this time, the server ignores the time part
+func Since (t time.Time) string {
+	return "SINCE " + t.Format ("2-Jan-2006")
+}
+
+// puts the string in quotes for a command
+func Quote (in string) string {
+	return `"` + strings.NewReplacer (`\`, `\\`, `"`, `\"`, "\r", "", "\n", "").Replace (in) + `"`
+}
+
+// reverses Quote, anything that isn't quoted comes back as is
+func Unquote (in string) string {
+	if len(in) < 2 || in[0] != '"' || in[len(in)-1] != '"' { return in }
+	return strings.NewReplacer (`\\`, `\`, `\"`, `"`).Replace (in[1:len(in)-1])
+}
+
+// connects and logs in, the context's deadline covers everything we do with the client
+func (this *Account) Dial (ctx context.Context) (*Client, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext (ctx, "tcp", this.addr())
+	if err != nil { return nil, errors.Wrapf (err, "imap dial : %s", this.addr()) }
+
+	if this.Port == 0 || this.Port == DefaultPort {
+		conn = tls.Client (conn, this.tlsConfig())
+	}
+
+	// same as smtp, the deadline has to live on the connection
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline (deadline)
+	}
+
+	c := &Client { conn: conn, r: bufio.NewReader (conn) }
+
+	err = func() error {
+		if err := c.greeting(); err != nil { return err }
+		if err := c.capabilities(); err != nil { return err }
+
+		if _, ok := conn.(*tls.Conn); ok == false && c.caps["STARTTLS"] {
+			if _, err := c.command ("STARTTLS"); err != nil { return err }
+
+			tlsConn := tls.Client (conn, this.tlsConfig())
+			c.conn, c.r = tlsConn, bufio.NewReader (tlsConn)
+			if err := c.capabilities(); err != nil { return err }
+		}
+
+		if _, ok := c.conn.(*tls.Conn); ok == false {
+			return errors.New ("server doesn't offer STARTTLS, not sending the password in the clear")
+		}
+
+		if _, err := c.command ("LOGIN %s %s", Quote (this.Username), Quote (this.Password)); err != nil { return err }
+
+		return c.capabilities() // some servers only tell us about MOVE once we're logged in
+	}()
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf (err, "imap : %s : %s", this.addr(), this.Username)
+	}
+
+	return c, nil
+}
+
+// logs out and closes the connection
+func (this *Client) Close () error {
+	this.command ("LOGOUT") // we're closing either way
+	return errors.WithStack (this.conn.Close())
+}
+
+// whether the server supports the extension, ex: MOVE
+func (this *Client) Has (capability string) bool {
+	return this.caps[strings.ToUpper (capability)]
+}
+
+// all the folders on the server
+func (this *Client) List () ([]*Folder, error) {
+	untagged, err := this.command (`LIST "" "*"`)
+	if err != nil { return nil, err }
+
+	folders := make([]*Folder, 0, len(untagged))
+	for _, resp := range untagged {
+		if folder, ok := parseList (resp); ok {
+			folders = append (folders, folder)
+		}
+	}
+	return folders, nil
+}
+
+// finds the spam folder, the special use attribute wins and then we go by the name
+// returns an empty string when there isn't one
+func (this *Client) Junk () (string, error) {
+	folders, err := this.List()
+	if err != nil { return "", err }
+
+	for _, folder := range folders {
+		for _, attr := range folder.Attributes {
+			if strings.EqualFold (attr, AttrJunk) { return folder.Name, nil }
+		}
+	}
+
+	for _, folder := range folders {
+		name := strings.ToLower (folder.Name)
+		if idx := strings.LastIndexAny (name, "/."); idx >= 0 { name = name[idx+1:] } // [Gmail]/Spam, INBOX.Junk
+
+		switch name {
+		case "spam", "junk", "junk e-mail", "junk email", "bulk mail":
+			return folder.Name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// opens the folder, everything after this works on it
+func (this *Client) Select (folder string) error {
+	_, err := this.command ("SELECT %s", Quote (folder))
+	return err
+}
+
+// uids of the messages in the selected folder matching the search, ex: UNSEEN HEADER Message-ID "<abc@example.com>"
+func (this *Client) Search (criteria string) ([]uint32, error) {
+	untagged, err := this.command ("UID SEARCH %s", criteria)
+	if err != nil { return nil, err }
+
+	uids := make([]uint32, 0)
+	for _, resp := range untagged {
+		fields := strings.Fields (resp.text)
+		if len(fields) == 0 || strings.EqualFold (fields[0], "SEARCH") == false { continue }
+
+		for _, field := range fields[1:] {
+			uid, err := strconv.ParseUint (field, 10, 32)
+			if err != nil { return nil, errors.Wrapf (err, "imap search : %s", resp.text) }
+			uids = append (uids, uint32(uid))
+		}
+	}
+	return uids, nil
+}
+
+// the full raw message, this doesn't mark it as seen
+func (this *Client) Fetch (uid uint32) ([]byte, error) {
+	return this.fetch (uid, "")
+}
+
+// just the headers, when that's all we need there's no reason to pull down the attachments
+func (this *Client) FetchHeader (uid uint32) ([]byte, error) {
+	return this.fetch (uid, "HEADER")
+}
+
+// adds the flags to the messages, ex: FlagSeen
+func (this *Client) AddFlags (uids []uint32, flags ...string) error {
+	if len(uids) == 0 { return nil }
+
+	_, err := this.command ("UID STORE %s +FLAGS.SILENT (%s)", uidSet (uids), strings.Join (flags, " "))
+	return err
+}
+
+// moves the messages to another folder
+// without the MOVE extension this copies and expunges, which also clears out anything else already marked as deleted
+func (this *Client) Move (uids []uint32, folder string) error {
+	if len(uids) == 0 { return nil }
+
+	if this.Has ("MOVE") {
+		_, err := this.command ("UID MOVE %s %s", uidSet (uids), Quote (folder))
+		return err
+	}
+
+	if _, err := this.command ("UID COPY %s %s", uidSet (uids), Quote (folder)); err != nil { return err }
+	if err := this.AddFlags (uids, FlagDeleted); err != nil { return err }
+
+	_, err := this.command ("EXPUNGE")
+	return err
+}
@@ -0,0 +1,116 @@
+package imap
+
+import (
+	"coldbrew/tools"
+	"coldbrew/tools/imap/imaptest"
+
+	"github.com/stretchr/testify/assert"
+
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMessage = "From: <from@example.com>\r\nTo: <seed@example.com>\r\nSubject: hello\r\nMessage-ID: <abc@example.com>\r\nX-Coldbrew-Email: 1234\r\n\r\nbody {5}\r\nhere\r\n"
+
+func testClient (t *testing.T, server *imaptest.Server) (*Client, func()) {
+	ctx, cancel := context.WithTimeout (context.Background(), time.Second * 10)
+
+	account := &Account { Host: "127.0.0.1", Port: server.Port(), Username: "seed", Password: `pa"ss`, TLSConfig: server.TLSConfig() }
+	c, err := account.Dial (ctx)
+	tools.TestingStackTrace (t, err)
+
+	return c, func() {
+		c.Close()
+		cancel()
+	}
+}
+
+func TestQAImapClient (t *testing.T) {
+	for _, noMove := range []bool { false, true } {
+		server, err := imaptest.NewServer ("seed", `pa"ss`)
+		tools.TestingStackTrace (t, err)
+		server.NoMove = noMove
+
+		server.Append (imaptest.Junk, []byte(testMessage))
+		server.Append (imaptest.Junk, []byte("Subject: someone else\r\n\r\nnot ours\r\n"))
+
+		c, done := testClient (t, server)
+		assert.Equal (t, noMove == false, c.Has ("move"))
+
+		junk, err := c.Junk()
+		tools.TestingStackTrace (t, err)
+		assert.Equal (t, imaptest.Junk, junk)
+
+		// find ours in spam and move it back to the inbox
+		tools.TestingStackTrace (t, c.Select (junk))
+		uids, err := c.Search (`HEADER X-Coldbrew-Email ""`)
+		tools.TestingStackTrace (t, err)
+		assert.Equal (t, []uint32 { 1 }, uids)
+
+		tools.TestingStackTrace (t, c.Move (uids, Inbox))
+		assert.Len (t, server.Messages (imaptest.Junk), 1, "the other one stays in spam")
+
+		tools.TestingStackTrace (t, c.Select (Inbox))
+		uids, err = c.Search (`UNSEEN HEADER Message-ID "<abc@example.com>"`)
+		tools.TestingStackTrace (t, err)
+		assert.Len (t, uids, 1)
+
+		// literals in the body can't throw off the parsing
+		raw, err := c.Fetch (uids[0])
+		tools.TestingStackTrace (t, err)
+		assert.Equal (t, testMessage, string(raw))
+
+		header, err := c.FetchHeader (uids[0])
+		tools.TestingStackTrace (t, err)
+		assert.Equal (t, testMessage[:strings.Index (testMessage, "\r\n\r\n") + 4], string(header))
+
+		recent, err := c.Search (Since (time.Now().Add (-time.Hour * 24)))
+		tools.TestingStackTrace (t, err)
+		assert.Equal (t, uids, recent)
+
+		tools.TestingStackTrace (t, c.AddFlags (uids, FlagSeen, FlagAnswered))
+		uids, err = c.Search ("UNANSWERED")
+		tools.TestingStackTrace (t, err)
+		assert.Len (t, uids, 0)
+
+		msgs := server.Messages (Inbox)
+		assert.True (t, msgs[0].HasFlag (FlagSeen))
+
+		done()
+		server.Close()
+	}
+}
+
+func TestQAImapLogin (t *testing.T) {
+	server, err := imaptest.NewServer ("seed", "right")
+	tools.TestingStackTrace (t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout (context.Background(), time.Second * 10)
+	defer cancel()
+
+	_, err = (&Account { Host: "127.0.0.1", Port: server.Port(), Username: "seed", Password: "wrong", TLSConfig: server.TLSConfig() }).Dial (ctx)
+	assert.Error (t, err)
+	assert.NotContains (t, err.Error(), "wrong", "the password shouldn't end up in the error")
+
+	// a certificate we don't trust doesn't get the password either
+	_, err = (&Account { Host: "127.0.0.1", Port: server.Port(), Username: "seed", Password: "right" }).Dial (ctx)
+	assert.Error (t, err)
+}
+
+func TestQAImapRequiresTLS (t *testing.T) {
+	server, err := imaptest.NewServer ("seed", "right")
+	tools.TestingStackTrace (t, err)
+	defer server.Close()
+	server.NoTLS = true
+
+	ctx, cancel := context.WithTimeout (context.Background(), time.Second * 10)
+	defer cancel()
+
+	_, err = (&Account { Host: "127.0.0.1", Port: server.Port(), Username: "seed", Password: "right" }).Dial (ctx)
+	if assert.Error (t, err) {
+		assert.Contains (t, err.Error(), "STARTTLS")
+	}
+}
@@ -0,0 +1,439 @@
+/** ****************************************************************************************************************** **
+	A local IMAP server for tests, same idea as httptest
+	Keeps everything in memory and only knows the commands our client sends
+
+** ****************************************************************************************************************** **/
+
+package imaptest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+const Junk = "Spam" // the spam folder every server starts with
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type Message struct {
+	UID uint32
+	Flags []string
+	Raw []byte
+	Date time.Time // when it got here, SINCE goes off this
+}
+
+func (this *Message) HasFlag (flag string) bool {
+	for _, f := range this.Flags {
+		if strings.EqualFold (f, flag) { return true }
+	}
+	return false
+}
+
+type folder struct {
+	attrs string
+	nextUID uint32
+	messages []*Message
+}
+
+type Server struct {
+	Username, Password string
+	NoMove bool // hides the MOVE extension, so the client has to copy and expunge
+	NoTLS bool // doesn't offer STARTTLS, so the client should refuse to log in
+
+	listener net.Listener
+	cert tls.Certificate // self signed for 127.0.0.1, TLSConfig is what trusts it
+	lock sync.Mutex
+	folders map[string]*folder
+	wg sync.WaitGroup
+}
+
+// a single connection
+type session struct {
+	*Server
+	conn net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+	selected string
+	loggedIn, tls bool
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// splits the command arguments, quoted strings and lists each come back as one
+func tokens (in string) []string {
+	ret := make([]string, 0)
+	for i := 0; i < len(in); {
+		switch in[i] {
+		case ' ':
+			i++
+
+		case '"':
+			var buf strings.Builder
+			for i++; i < len(in) && in[i] != '"'; i++ {
+				if in[i] == '\\' && i + 1 < len(in) { i++ }
+				buf.WriteByte (in[i])
+			}
+			ret = append (ret, buf.String())
+			i++
+
+		case '(':
+			end := strings.IndexByte (in[i:], ')')
+			if end < 0 { end = len(in) - i }
+			ret = append (ret, in[i+1:i+end])
+			i += end + 1
+
+		default:
+			end := strings.IndexByte (in[i:], ' ')
+			if end < 0 { end = len(in) - i }
+			ret = append (ret, in[i:i+end])
+			i += end
+		}
+	}
+	return ret
+}
+
+// the uids in a set like 1,2,5
+func parseSet (in string) map[uint32]bool {
+	ret := make(map[uint32]bool)
+	for _, part := range strings.Split (in, ",") {
+		uid, _ := strconv.ParseUint (part, 10, 32)
+		ret[uint32(uid)] = true
+	}
+	return ret
+}
+
+func (this *Server) serve (conn net.Conn) {
+	defer this.wg.Done()
+
+	s := &session { Server: this, conn: conn, r: bufio.NewReader (conn), w: bufio.NewWriter (conn) }
+	defer func() { s.conn.Close() }() // it's the tls one after STARTTLS
+
+	s.reply ("* OK IMAP4rev1 test server ready")
+
+	for {
+		line, err := s.r.ReadString ('\n')
+		if err != nil { return }
+
+		tag, cmd, _ := strings.Cut (strings.TrimRight (line, "\r\n"), " ")
+		if s.command (tag, cmd) == false { return }
+	}
+}
+
+func (this *session) reply (format string, args ...interface{}) {
+	fmt.Fprintf (this.w, format + "\r\n", args...)
+	this.w.Flush()
+}
+
+// a throwaway certificate for 127.0.0.1, good for the life of the test
+func selfSigned () (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey (elliptic.P256(), rand.Reader)
+	if err != nil { return tls.Certificate{}, err }
+
+	template := &x509.Certificate {
+		SerialNumber: big.NewInt (1),
+		NotBefore: time.Now().Add (-time.Hour),
+		NotAfter: time.Now().Add (time.Hour * 24),
+		IPAddresses: []net.IP { net.IPv4 (127, 0, 0, 1) },
+		KeyUsage: x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage { x509.ExtKeyUsageServerAuth },
+	}
+
+	der, err := x509.CreateCertificate (rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil { return tls.Certificate{}, err }
+
+	return tls.Certificate { Certificate: [][]byte { der }, PrivateKey: key }, nil
+}
+
+// runs a single command, returns false once the client has logged out
+func (this *session) command (tag, cmd string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	args := tokens (cmd)
+	if len(args) == 0 {
+		this.reply ("%s BAD empty command", tag)
+		return true
+	}
+
+	name := strings.ToUpper (args[0])
+	if name == "UID" && len(args) > 1 {
+		name = "UID " + strings.ToUpper (args[1])
+		args = args[1:]
+	}
+	args = args[1:]
+
+	if this.loggedIn == false && name != "CAPABILITY" && name != "STARTTLS" && name != "LOGIN" && name != "LOGOUT" {
+		this.reply ("%s NO log in first", tag)
+		return true
+	}
+
+	switch name {
+	case "CAPABILITY":
+		caps := "IMAP4rev1"
+		if this.NoMove == false { caps += " MOVE" }
+		if this.NoTLS == false && this.tls == false { caps += " STARTTLS" }
+		this.reply ("* CAPABILITY %s", caps)
+
+	case "STARTTLS":
+		if this.NoTLS || this.tls {
+			this.reply ("%s BAD unknown command", tag)
+			return true
+		}
+
+		// the client starts the handshake once it sees this, so everything after is over tls
+		this.reply ("%s OK begin tls negotiation now", tag)
+
+		conn := tls.Server (this.conn, &tls.Config { Certificates: []tls.Certificate { this.cert } })
+		this.conn, this.r, this.w, this.tls = conn, bufio.NewReader (conn), bufio.NewWriter (conn), true
+		return true
+
+	case "LOGIN":
+		if len(args) != 2 || args[0] != this.Username || args[1] != this.Password {
+			this.reply ("%s NO [AUTHENTICATIONFAILED] invalid credentials", tag)
+			return true
+		}
+		this.loggedIn = true
+
+	case "LOGOUT":
+		this.reply ("* BYE see ya")
+		this.reply ("%s OK LOGOUT completed", tag)
+		return false
+
+	case "NOOP":
+
+	case "LIST":
+		for name, f := range this.folders {
+			this.reply (`* LIST (%s) "/" "%s"`, f.attrs, name)
+		}
+
+	case "SELECT":
+		f, ok := this.folders[args[0]]
+		if ok == false {
+			this.reply ("%s NO no such mailbox", tag)
+			return true
+		}
+		this.selected = args[0]
+		this.reply ("* %d EXISTS", len(f.messages))
+
+	case "UID SEARCH":
+		uids := make([]string, 0)
+		for _, msg := range this.folder().messages {
+			if matches (msg, args) { uids = append (uids, fmt.Sprint (msg.UID)) }
+		}
+		this.reply ("* SEARCH %s", strings.Join (uids, " "))
+
+	case "UID FETCH":
+		set := parseSet (args[0])
+		for i, msg := range this.folder().messages {
+			if set[msg.UID] {
+				section, body := "", msg.Raw
+				if strings.Contains (strings.ToUpper (args[1]), "[HEADER]") {
+					section = "HEADER"
+					if idx := bytes.Index (body, []byte("\r\n\r\n")); idx >= 0 { body = body[:idx + 4] }
+				}
+
+				this.reply ("* %d FETCH (UID %d BODY[%s] {%d}", i + 1, msg.UID, section, len(body))
+				this.w.Write (body)
+				this.reply (")")
+			}
+		}
+
+	case "UID STORE":
+		set := parseSet (args[0])
+		for _, msg := range this.folder().messages {
+			if set[msg.UID] == false { continue }
+			for _, flag := range strings.Fields (args[2]) {
+				if msg.HasFlag (flag) == false { msg.Flags = append (msg.Flags, flag) }
+			}
+		}
+
+	case "UID COPY", "UID MOVE":
+		if name == "UID MOVE" && this.NoMove {
+			this.reply ("%s BAD unknown command", tag)
+			return true
+		}
+
+		dest, ok := this.folders[args[1]]
+		if ok == false {
+			this.reply ("%s NO [TRYCREATE] no such mailbox", tag)
+			return true
+		}
+
+		set := parseSet (args[0])
+		src := this.folder()
+		keep := make([]*Message, 0, len(src.messages))
+		for _, msg := range src.messages {
+			if set[msg.UID] {
+				dest.nextUID++
+				dest.messages = append (dest.messages, &Message { UID: dest.nextUID, Flags: append([]string{}, msg.Flags...), Raw: msg.Raw, Date: msg.Date })
+				if name == "UID MOVE" { continue }
+			}
+			keep = append (keep, msg)
+		}
+		src.messages = keep
+
+	case "EXPUNGE":
+		f := this.folder()
+		keep := make([]*Message, 0, len(f.messages))
+		for _, msg := range f.messages {
+			if msg.HasFlag (`\Deleted`) == false { keep = append (keep, msg) }
+		}
+		f.messages = keep
+
+	default:
+		this.reply ("%s BAD unknown command", tag)
+		return true
+	}
+
+	this.reply ("%s OK %s completed", tag, name)
+	return true
+}
+
+func (this *session) folder () *folder {
+	if f, ok := this.folders[this.selected]; ok { return f }
+	return &folder{}
+}
+
+// only the search keys we use
+func matches (msg *Message, args []string) bool {
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper (args[i]) {
+		case "ALL":
+		case "SEEN": if msg.HasFlag (`\Seen`) == false { return false }
+		case "UNSEEN": if msg.HasFlag (`\Seen`) { return false }
+		case "ANSWERED": if msg.HasFlag (`\Answered`) == false { return false }
+		case "UNANSWERED": if msg.HasFlag (`\Answered`) { return false }
+		case "UNDELETED": if msg.HasFlag (`\Deleted`) { return false }
+
+		case "SINCE":
+			if i + 1 >= len(args) { return false }
+			since, err := time.Parse ("2-Jan-2006", args[i+1])
+			i++
+			if err != nil || msg.Date.Before (since) { return false }
+
+		case "HEADER":
+			if i + 2 >= len(args) { return false }
+			key, val := args[i+1], args[i+2]
+			i += 2
+
+			parsed, err := mail.ReadMessage (bytes.NewReader (msg.Raw))
+			if err != nil { return false }
+
+			found := false
+			for _, header := range parsed.Header[textproto.CanonicalMIMEHeaderKey (key)] {
+				if strings.Contains (strings.ToLower (header), strings.ToLower (val)) { found = true }
+			}
+			if found == false { return false }
+
+		default:
+			return false // something we don't know how to do
+		}
+	}
+	return true
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// starts listening on a random local port, with an INBOX and a Spam folder
+func NewServer (username, password string) (*Server, error) {
+	cert, err := selfSigned()
+	if err != nil { return nil, err }
+
+	l, err := net.Listen ("tcp", "127.0.0.1:0")
+	if err != nil { return nil, err }
+
+	this := &Server {
+		Username: username,
+		Password: password,
+		listener: l,
+		cert: cert,
+		folders: map[string]*folder {
+			"INBOX": &folder { attrs: `\HasNoChildren` },
+			Junk: &folder { attrs: `\HasNoChildren \Junk` },
+		},
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil { return }
+
+			this.wg.Add(1)
+			go this.serve (conn)
+		}
+	}()
+
+	return this, nil
+}
+
+// what a client needs to trust our certificate
+func (this *Server) TLSConfig () *tls.Config {
+	pool := x509.NewCertPool()
+	if leaf, err := x509.ParseCertificate (this.cert.Certificate[0]); err == nil { pool.AddCert (leaf) }
+
+	return &tls.Config { RootCAs: pool, ServerName: "127.0.0.1" }
+}
+
+func (this *Server) Port () int {
+	return this.listener.Addr().(*net.TCPAddr).Port
+}
+
+// stops listening and waits for the open connections to finish
+func (this *Server) Close () {
+	this.listener.Close()
+	this.wg.Wait()
+}
+
+// drops the message into the folder, returns its uid
+func (this *Server) Append (folderName string, raw []byte, flags ...string) uint32 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	f, ok := this.folders[folderName]
+	if ok == false {
+		f = &folder { attrs: `\HasNoChildren` }
+		this.folders[folderName] = f
+	}
+
+	f.nextUID++
+	f.messages = append (f.messages, &Message { UID: f.nextUID, Flags: flags, Raw: raw, Date: time.Now() })
+	return f.nextUID
+}
+
+// copies of what's currently in the folder
+func (this *Server) Messages (folderName string) []Message {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	ret := make([]Message, 0)
+	if f, ok := this.folders[folderName]; ok {
+		for _, msg := range f.messages {
+			ret = append (ret, Message { UID: msg.UID, Flags: append([]string{}, msg.Flags...), Raw: msg.Raw, Date: msg.Date })
+		}
+	}
+	return ret
+}
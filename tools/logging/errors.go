@@ -7,6 +7,7 @@ package logging
 
 import (
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 	
 	"fmt"
 	"os"
@@ -22,6 +23,8 @@ import (
 const (
 	ThreadName			= "threadName" // for tracking the original name/function of the calling function
 	ThreadId			= "threadId" // for tracking thread ids through the response
+	TraceId				= "traceId" // from the open telemetry span, so logs line up with traces
+	SpanId				= "spanId"
 )
 
 var (
@@ -46,8 +49,28 @@ type stackTracer interface {
 type Logger struct {
 }
 
+// adds the trace and span ids to anything logged with a context that has them
+type traceHandler struct {
+	slog.Handler
+}
+
+func (this traceHandler) Handle (ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext (ctx); sc.IsValid() {
+		r.AddAttrs (slog.String(TraceId, sc.TraceID().String()), slog.String(SpanId, sc.SpanID().String()))
+	}
+	return this.Handler.Handle (ctx, r)
+}
+
+func (this traceHandler) WithAttrs (attrs []slog.Attr) slog.Handler {
+	return traceHandler { this.Handler.WithAttrs (attrs) }
+}
+
+func (this traceHandler) WithGroup (name string) slog.Handler {
+	return traceHandler { this.Handler.WithGroup (name) }
+}
+
 func (this *Logger) Init () {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions { Level: slog.LevelDebug }))
+	logger := slog.New(traceHandler { slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions { Level: slog.LevelDebug }) })
 	slog.SetDefault(logger) // so we just directly slog from somewhere without this original logger
 }
 
@@ -58,16 +81,18 @@ func (this *Logger) handleError (ctx context.Context, err error) {
 	if ctx != nil {
 		threadName, _ = ctx.Value(ThreadName).(string)
 		threadId, _ = ctx.Value(ThreadId).(string)
+	} else {
+		ctx = context.Background() // the trace handler needs one
 	}
 	
 	switch errors.Cause (err) {
 	case ErrNonFatal, ErrReturnToUser: 
 		// we don't need a stack for these types of errors
-		slog.Error (err.Error(), 
+		slog.ErrorContext (ctx, err.Error(), 
 			slog.String(ThreadName, threadName),
 			slog.String(ThreadId, threadId))
 	default:
-		slog.Error (err.Error(), 
+		slog.ErrorContext (ctx, err.Error(), 
 			slog.Any("stacktrace", StackTraceToArray (err)), 
 			slog.String(ThreadName, threadName),
 			slog.String(ThreadId, threadId))
@@ -0,0 +1,200 @@
+/** ****************************************************************************************************************** **
+	Generic email sending, so the flows don't care which provider a mailman is using
+
+	Each provider package (sendgrid, smtp, etc) implements the Sender interface
+
+** ****************************************************************************************************************** **/
+
+package mailer
+
+import (
+	"coldbrew/tools"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"context"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+	"sort"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// every email we send has this with the id of the emails row, it survives providers that replace the Message-ID
+const HeaderEmailId = "X-Coldbrew-Email"
+
+// plenty to tell what went wrong, without a provider's whole error page ending up in the database
+const maxFailReason = 1000
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- INTERFACES ------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// anything that can send an email for us
+type Sender interface {
+	// sends the message and returns the provider's id for it, so we can match up the webhooks later
+	Send (ctx context.Context, msg *Message) (string, error)
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// a single email to a single person
+type Message struct {
+	To string
+	FromEmail, FromName, ReplyEmail, ReplyName string
+	Subject, Text, Html string
+	Category string // providers that support tagging use this
+	Headers map[string]string // any extra headers to include
+}
+
+// the from address formatted for a header, including the name
+func (this *Message) FromHeader () string {
+	addr := mail.Address { Name: this.FromName, Address: this.FromEmail }
+	return addr.String()
+}
+
+// the reply to address formatted for a header, including the name
+func (this *Message) ReplyToHeader () string {
+	addr := mail.Address { Name: this.ReplyName, Address: this.ReplyEmail }
+	return addr.String()
+}
+
+// writes a single body part, quoted printable so long lines are ok
+func (this *Message) writePart (w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter (w)
+	if _, err := qp.Write ([]byte(body)); err != nil { return errors.WithStack (err) }
+	return errors.WithStack (qp.Close())
+}
+
+// builds the full RFC 5322 version of this message, for providers that want the raw email
+func (this *Message) MIME (messageId string) ([]byte, error) {
+	if len(this.Text) == 0 && len(this.Html) == 0 { return nil, errors.Errorf ("message has no body : %s", this.To) }
+
+	buf := new(bytes.Buffer)
+
+	header := make(textproto.MIMEHeader)
+	header.Set ("From", this.FromHeader())
+	header.Set ("To", (&mail.Address { Address: this.To }).String())
+	if len(this.ReplyEmail) > 0 { header.Set ("Reply-To", this.ReplyToHeader()) }
+	header.Set ("Subject", mime.QEncoding.Encode ("utf-8", headerSafe (this.Subject)))
+	header.Set ("Date", time.Now().Format(time.RFC1123Z))
+	header.Set ("Message-ID", "<" + messageId + ">")
+	header.Set ("MIME-Version", "1.0")
+
+	for key, val := range this.Headers {
+		header.Set (headerSafe (key), headerSafe (val))
+	}
+
+	// figure out what the body looks like
+	var body bytes.Buffer
+	if len(this.Text) > 0 && len(this.Html) > 0 {
+		mw := multipart.NewWriter (&body)
+		header.Set ("Content-Type", "multipart/alternative; boundary=" + mw.Boundary())
+
+		for _, part := range []struct { contentType, body string } { {"text/plain", this.Text}, {"text/html", this.Html} } {
+			pw, err := mw.CreatePart (textproto.MIMEHeader {
+				"Content-Type": { part.contentType + "; charset=utf-8" },
+				"Content-Transfer-Encoding": { "quoted-printable" },
+			})
+			if err != nil { return nil, errors.WithStack (err) }
+
+			if err := this.writePart (pw, part.body); err != nil { return nil, err }
+		}
+
+		if err := mw.Close(); err != nil { return nil, errors.WithStack (err) }
+
+	} else {
+		contentType, content := "text/plain", this.Text
+		if len(this.Html) > 0 { contentType, content = "text/html", this.Html }
+
+		header.Set ("Content-Type", contentType + "; charset=utf-8")
+		header.Set ("Content-Transfer-Encoding", "quoted-printable")
+
+		if err := this.writePart (&body, content); err != nil { return nil, err }
+	}
+
+	// headers go out in a stable order, it makes debugging a lot easier
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append (keys, key)
+	}
+	sort.Strings (keys)
+
+	for _, key := range keys {
+		for _, val := range header[key] {
+			fmt.Fprintf (buf, "%s: %s\r\n", key, val)
+		}
+	}
+	buf.WriteString ("\r\n")
+	buf.Write (body.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// new lines in a header would let the content inject headers of its own
+func headerSafe (in string) string {
+	return strings.NewReplacer ("\r", "", "\n", " ").Replace (in)
+}
+
+// generates a new message id using the domain we're sending from, without the angle brackets
+func NewMessageId (fromEmail string) string {
+	domain := "coldbrew.local"
+	if idx := strings.LastIndex (fromEmail, "@"); idx >= 0 && idx < len(fromEmail) - 1 {
+		domain = fromEmail[idx+1:]
+	}
+
+	return fmt.Sprintf ("%s@%s", uuid.New().String(), domain)
+}
+
+// what's safe to save about a failed send, for an http error that's only the status and what the provider said
+// the rest of it can have the email itself in there
+func FailReason (err error) string {
+	if err == nil { return "" }
+
+	reason := err.Error()
+
+	var httpErr *tools.HttpError
+	if errors.As (err, &httpErr) { reason = fmt.Sprintf ("%d : %s", httpErr.StatusCode, httpErr.Body) }
+
+	if len(reason) > maxFailReason { reason = reason[:maxFailReason] }
+	return reason
+}
+
+// whether the send failed in a way that trying again later could fix
+// rate limits, the provider having problems, temporary smtp failures and network issues are all worth another try
+func Retryable (err error) bool {
+	if err == nil { return false }
+
+	var httpErr *tools.HttpError
+	if errors.As (err, &httpErr) {
+		return httpErr.StatusCode == 429 || httpErr.StatusCode >= 500
+	}
+
+	var smtpErr *textproto.Error
+	if errors.As (err, &smtpErr) {
+		return smtpErr.Code >= 400 && smtpErr.Code < 500
+	}
+
+	var netErr net.Error
+	if errors.As (err, &netErr) { return true }
+
+	return errors.Is (err, context.DeadlineExceeded)
+}
@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"coldbrew/tools"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"context"
+	"net"
+	"net/textproto"
+	"testing"
+)
+
+func TestQARetryable (t *testing.T) {
+	assert.False (t, Retryable (nil))
+
+	assert.True (t, Retryable (errors.WithStack (&tools.HttpError { StatusCode: 429 })))
+	assert.True (t, Retryable (errors.Wrap (&tools.HttpError { StatusCode: 503 }, "sending")))
+	assert.False (t, Retryable (errors.WithStack (&tools.HttpError { StatusCode: 400 })))
+
+	assert.True (t, Retryable (errors.Wrap (&textproto.Error { Code: 421, Msg: "try again later" }, "smtp rcpt to")))
+	assert.False (t, Retryable (errors.Wrap (&textproto.Error { Code: 550, Msg: "no such user" }, "smtp rcpt to")))
+
+	assert.True (t, Retryable (errors.WithStack (&net.OpError { Op: "dial", Err: errors.New ("connection refused") })))
+	assert.True (t, Retryable (errors.WithStack (context.DeadlineExceeded)))
+
+	assert.False (t, Retryable (errors.New ("template didn't render")))
+}
+
+func TestQAFailReason (t *testing.T) {
+	assert.Equal (t, "", FailReason (nil))
+
+	err := errors.Wrap (&tools.HttpError { StatusCode: 401, Body: `{"errors":["bad key"]}`, Message: "Authorization: Bearer secret" }, "sending")
+	assert.Equal (t, `401 : {"errors":["bad key"]}`, FailReason (err), "nothing we sent is kept")
+
+	assert.Equal (t, "smtp rcpt to: no such user", FailReason (errors.Wrap (errors.New ("no such user"), "smtp rcpt to")))
+}
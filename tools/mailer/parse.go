@@ -0,0 +1,377 @@
+/** ****************************************************************************************************************** **
+	Reading emails that were sent to us, the other direction from Message
+
+** ****************************************************************************************************************** **/
+
+package mailer
+
+import (
+	"github.com/pkg/errors"
+
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// what kind of email someone sent back to us
+type ReceivedKind string
+const (
+	ReceivedKind_reply			= ReceivedKind("reply")
+	ReceivedKind_autoReply		= ReceivedKind("auto_reply") // out of office and the like
+	ReceivedKind_bounce			= ReceivedKind("bounce") // a delivery status notification, our email didn't make it
+	ReceivedKind_unsubscribe	= ReceivedKind("unsubscribe") // a person asking us to stop, in their own words
+)
+
+// someone asking to be taken off the list, only checked against what they wrote and not what they quoted
+var unsubscribeRegex = regexp.MustCompile (`\b(unsubscribe|remove me|take me off|stop (emailing|sending|contacting|messaging)|(do not|don't|dont) (email|contact|message)|opt(-| )?out|no longer (wish|want) to (receive|get))\b`)
+
+// where the quoted part of a reply starts
+var quoteRegex = regexp.MustCompile (`(?i)^(on .+ wrote:|-+ ?original message ?-+|from: .+|sent from my )`)
+
+// the prefixes clients stick on the subject of a reply or forward, ex: "RE: Fwd: Hello"
+var subjectPrefixRegex = regexp.MustCompile (`(?i)^\s*((re|fwd?|aw|sv)(\[\d+\])?\s*:\s*)+`)
+
+// for turning an html only reply into text, the quoted part goes in a blockquote or the client's own div
+var htmlQuoteRegex = regexp.MustCompile (`(?i)<blockquote\b|<div[^>]+(gmail_quote|yahoo_quoted|divRplyFwdMsg|moz-cite-prefix)`)
+var htmlDropRegex = regexp.MustCompile (`(?is)<(head|style|script)\b.*?</(head|style|script)>`)
+var htmlBreakRegex = regexp.MustCompile (`(?i)<(br|/p|/div|/li|/tr|/h[1-6])\b[^>]*>`)
+var htmlTagRegex = regexp.MustCompile (`(?s)<[^>]*>`)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// the parts of a received email we care about
+type Received struct {
+	Header mail.Header
+	MessageId, InReplyTo string // without the angle brackets
+	References []string
+	From, ReplyTo *mail.Address
+	Subject string
+	Text, Html string
+
+	// the headers of our email when it comes back attached, which is how bounces come back
+	Original mail.Header
+
+	Attachments []*Attachment // only what they are, we don't keep the content
+}
+
+// an attachment on a received email
+type Attachment struct {
+	Filename, Type, ContentId string
+	Size int64
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// undoes the transfer encoding on a single part
+func decodePart (encoding string, r io.Reader) ([]byte, error) {
+	switch strings.ToLower (strings.TrimSpace (encoding)) {
+	case "quoted-printable":
+		r = quotedprintable.NewReader (r)
+	case "base64":
+		r = base64.NewDecoder (base64.StdEncoding, newlineStripper { r })
+	}
+
+	body, err := io.ReadAll (r)
+	return body, errors.WithStack (err)
+}
+
+// base64 bodies come wrapped at 76 characters
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (this newlineStripper) Read (p []byte) (int, error) {
+	n, err := this.r.Read (p)
+	out := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[out] = b
+			out++
+		}
+	}
+	return out, err
+}
+
+// walks the parts looking for the first text and html bodies, attachments only get noted
+func (this *Received) body (contentType, encoding string, r io.Reader) error {
+	if len(contentType) == 0 { contentType = "text/plain" }
+
+	mediaType, params, err := mime.ParseMediaType (contentType)
+	if err != nil { mediaType = "text/plain" } // treat anything we can't read as text
+
+	if strings.HasPrefix (mediaType, "multipart/") {
+		mr := multipart.NewReader (r, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF { return nil }
+			if err != nil { return errors.WithStack (err) }
+
+			disposition, _, _ := mime.ParseMediaType (part.Header.Get ("Content-Disposition"))
+			if disposition == "attachment" {
+				if err := this.attachment (part); err != nil { return err }
+				continue
+			}
+
+			if err := this.body (part.Header.Get ("Content-Type"), part.Header.Get ("Content-Transfer-Encoding"), part); err != nil { return err }
+		}
+	}
+
+	if OriginalType (mediaType) {
+		content, err := decodePart (encoding, r)
+		if err != nil { return err }
+
+		this.SetOriginal (content)
+		return nil
+	}
+
+	if mediaType != "text/plain" && mediaType != "text/html" { return nil }
+
+	content, err := decodePart (encoding, r)
+	if err != nil { return err }
+
+	if mediaType == "text/html" && len(this.Html) == 0 {
+		this.Html = string(content)
+	} else if mediaType == "text/plain" && len(this.Text) == 0 {
+		this.Text = string(content)
+	}
+
+	return nil
+}
+
+// keeps track of the attachment, an email attached to a bounce is still our original
+func (this *Received) attachment (part *multipart.Part) error {
+	mediaType, _, err := mime.ParseMediaType (part.Header.Get ("Content-Type"))
+	if err != nil { mediaType = "application/octet-stream" }
+
+	content, err := decodePart (part.Header.Get ("Content-Transfer-Encoding"), part)
+	if err != nil { return err }
+
+	if OriginalType (mediaType) { this.SetOriginal (content) }
+
+	this.Attachments = append (this.Attachments, &Attachment {
+		Filename: part.FileName(),
+		Type: mediaType,
+		ContentId: CleanMessageId (part.Header.Get ("Content-Id")),
+		Size: int64(len(content)),
+	})
+	return nil
+}
+
+// close enough to what they'd see, without whatever they quoted
+func htmlText (in string) string {
+	if loc := htmlQuoteRegex.FindStringIndex (in); loc != nil { in = in[:loc[0]] }
+
+	in = htmlDropRegex.ReplaceAllString (in, "")
+	in = htmlBreakRegex.ReplaceAllString (in, "\n")
+	in = htmlTagRegex.ReplaceAllString (in, "")
+	return html.UnescapeString (in)
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// whether a part with this content type is an email, or the headers of one
+func OriginalType (mediaType string) bool {
+	switch strings.ToLower (mediaType) {
+	case "message/rfc822", "message/rfc822-headers", "text/rfc822-headers":
+		return true
+	}
+	return false
+}
+
+// reads the headers of an email that came back to us attached, only the first one counts
+func (this *Received) SetOriginal (content []byte) {
+	if this.Original != nil { return }
+
+	// it might only be the headers, without the blank line after them
+	if original, err := mail.ReadMessage (bytes.NewReader (append (content, "\r\n\r\n"...))); err == nil {
+		this.Original = original.Header
+	}
+}
+
+// trims the angle brackets off a message id
+func CleanMessageId (in string) string {
+	return strings.Trim (strings.TrimSpace (in), "<>")
+}
+
+// pulls apart a raw RFC 5322 email
+func Parse (raw []byte) (*Received, error) {
+	msg, err := mail.ReadMessage (bytes.NewReader (raw))
+	if err != nil { return nil, errors.WithStack (err) }
+
+	this := &Received {
+		Header: msg.Header,
+		MessageId: CleanMessageId (msg.Header.Get ("Message-ID")),
+	}
+
+	// some clients put more than one id in here, the first one is the one they're replying to
+	if ids := strings.Fields (msg.Header.Get ("In-Reply-To")); len(ids) > 0 { this.InReplyTo = CleanMessageId (ids[0]) }
+
+	for _, ref := range strings.Fields (msg.Header.Get ("References")) {
+		this.References = append (this.References, CleanMessageId (ref))
+	}
+
+	decoder := new(mime.WordDecoder)
+	this.Subject, err = decoder.DecodeHeader (msg.Header.Get ("Subject"))
+	if err != nil { this.Subject = msg.Header.Get ("Subject") } // better than nothing
+
+	// these are allowed to be missing or junk, we just won't have them
+	if list, _ := msg.Header.AddressList ("From"); len(list) > 0 { this.From = list[0] }
+	if list, _ := msg.Header.AddressList ("Reply-To"); len(list) > 0 { this.ReplyTo = list[0] }
+
+	// we're only handed the headers sometimes, and an empty multipart body isn't something the reader can handle
+	body := bufio.NewReader (msg.Body)
+	if _, err := body.Peek (1); err == io.EOF { return this, nil }
+
+	if err := this.body (msg.Header.Get ("Content-Type"), msg.Header.Get ("Content-Transfer-Encoding"), body); err != nil { return nil, err }
+
+	return this, nil
+}
+
+// who a reply to this should go to
+func (this *Received) ReplyAddress () *mail.Address {
+	if this.ReplyTo != nil { return this.ReplyTo }
+	return this.From
+}
+
+// whether a machine sent this on its own, out of office and vacation responders
+// those tell us the email got there, but nobody actually read it
+func (this *Received) AutoReply () bool {
+	// RFC 3834, anything other than no
+	if auto := strings.ToLower (strings.TrimSpace (this.Header.Get ("Auto-Submitted"))); len(auto) > 0 && auto != "no" { return true }
+
+	for _, key := range []string { "X-Autoreply", "X-Autorespond", "X-Autoresponder" } {
+		if len(this.Header.Get (key)) > 0 { return true }
+	}
+
+	switch strings.ToLower (strings.TrimSpace (this.Header.Get ("Precedence"))) {
+	case "auto_reply", "bulk", "junk":
+		return true
+	}
+
+	subject := strings.ToLower (this.Subject)
+	for _, prefix := range []string { "auto:", "automatic reply", "autoreply", "auto-reply", "auto reply", "out of office", "out of the office", 
+										"away from the office", "vacation", "abwesenheitsnotiz", "réponse automatique", "respuesta automática" } {
+		if strings.HasPrefix (subject, prefix) { return true }
+	}
+
+	return false
+}
+
+// whether this is a delivery status notification, the mail server telling us our email didn't make it
+func (this *Received) Bounce () bool {
+	if mediaType, params, err := mime.ParseMediaType (this.Header.Get ("Content-Type")); err == nil {
+		if mediaType == "multipart/report" && strings.EqualFold (params["report-type"], "delivery-status") { return true }
+	}
+
+	// not everyone sends a proper report
+	from := ""
+	if this.From != nil { from = strings.ToLower (this.From.Address) }
+	if strings.HasPrefix (from, "mailer-daemon@") || strings.HasPrefix (from, "postmaster@") {
+		subject := strings.ToLower (this.Subject)
+		for _, phrase := range []string { "undeliverable", "undelivered", "delivery status notification", "delivery failure", "mail delivery failed", 
+											"returned mail", "failure notice", "delivery has failed" } {
+			if strings.Contains (subject, phrase) { return true }
+		}
+	}
+
+	return false
+}
+
+// what they wrote, without the email they're replying to quoted underneath it
+func (this *Received) NewText () string {
+	text := this.Text
+	if len(strings.TrimSpace (text)) == 0 { text = htmlText (this.Html) } // some clients only send html
+
+	lines := strings.Split (strings.ReplaceAll (text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace (line)
+		if strings.HasPrefix (line, ">") || quoteRegex.MatchString (line) {
+			return strings.TrimSpace (strings.Join (lines[:i], "\n"))
+		}
+	}
+	return strings.TrimSpace (text)
+}
+
+// whether they're asking us to stop emailing them
+// the subject of a reply is still our subject, so it only counts when they wrote one of their own
+func (this *Received) Unsubscribe () bool {
+	text := this.NewText()
+	if subjectPrefixRegex.MatchString (this.Subject) == false { text = this.Subject + "\n" + text }
+
+	return unsubscribeRegex.MatchString (strings.ToLower (text))
+}
+
+// sorts it, a bounce is never a person and an auto reply saying "don't email me" isn't one either
+func (this *Received) Kind () ReceivedKind {
+	if this.Bounce() { return ReceivedKind_bounce }
+	if this.AutoReply() { return ReceivedKind_autoReply }
+	if this.Unsubscribe() { return ReceivedKind_unsubscribe }
+	return ReceivedKind_reply
+}
+
+// the id of our email, only there when our email came back attached to this one
+func (this *Received) EmailId () string {
+	if this.Original == nil { return "" }
+	return strings.TrimSpace (this.Original.Get (HeaderEmailId))
+}
+
+// every message id this could be about, the threading headers and then our original's
+// most providers give us their id, which is only the local part of the Message-ID, so those are in here too
+func (this *Received) ThreadIds () []string {
+	ids := append ([]string { this.InReplyTo }, this.References...)
+	if this.Original != nil { ids = append (ids, CleanMessageId (this.Original.Get ("Message-ID"))) }
+
+	ret := make([]string, 0, len(ids) * 2)
+	for _, id := range ids {
+		if len(id) == 0 { continue }
+		ret = append (ret, id)
+
+		if local, _, ok := strings.Cut (id, "@"); ok { ret = append (ret, local) }
+	}
+	return ret
+}
+
+// tokens from any plus addressed recipients, reply+token@example.com
+// the delivered to headers come first, they're what the mail server actually delivered it to
+func (this *Received) PlusTokens () []string {
+	ret := make([]string, 0)
+	for _, key := range []string { "Delivered-To", "X-Original-To", "To", "Cc" } {
+		for _, val := range this.Header[key] {
+			list, err := mail.ParseAddressList (val)
+			if err != nil { list = []*mail.Address { { Address: strings.Trim (strings.TrimSpace (val), "<>") } } } // delivered to is just the address
+
+			for _, addr := range list {
+				local, _, _ := strings.Cut (addr.Address, "@")
+				if _, token, ok := strings.Cut (local, "+"); ok && len(token) > 0 {
+					ret = append (ret, token)
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// adds the token to the address with plus addressing, reply@example.com -> reply+token@example.com
+func PlusAddress (address, token string) string {
+	idx := strings.LastIndex (address, "@")
+	if idx < 0 || len(token) == 0 { return address }
+	return address[:idx] + "+" + token + address[idx:]
+}
@@ -0,0 +1,118 @@
+package mailer
+
+import (
+	"coldbrew/tools"
+
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+)
+
+func TestQAParse (t *testing.T) {
+	msg := &Message {
+		To: "someone@example.com",
+		FromEmail: "news@example.com",
+		ReplyEmail: "replies@example.com",
+		Subject: "Héllo there",
+		Text: "plain body that is long enough to get wrapped by the quoted printable writer, plain body that is long enough",
+		Html: "<p>html body</p>",
+	}
+
+	raw, err := msg.MIME ("abc@example.com")
+	tools.TestingStackTrace (t, err)
+
+	received, err := Parse (raw)
+	tools.TestingStackTrace (t, err)
+
+	assert.Equal (t, "abc@example.com", received.MessageId)
+	assert.Equal (t, "Héllo there", received.Subject)
+	assert.Equal (t, msg.Text, received.Text)
+	assert.Equal (t, msg.Html, received.Html)
+	assert.Equal (t, "replies@example.com", received.ReplyAddress().Address)
+	assert.False (t, received.AutoReply())
+}
+
+func TestQAParseReply (t *testing.T) {
+	raw := "From: Someone <someone@example.com>\r\n" +
+			"To: replies+0123456789abcdef0123456789abcdef@example.com\r\n" +
+			"Delivered-To: replies+0123456789abcdef0123456789abcdef@example.com\r\n" +
+			"Subject: Re: Hello\r\n" +
+			"Message-ID: <reply@mail.example.com>\r\n" +
+			"In-Reply-To: <abc@example.com>\r\n" +
+			"References: <first@example.com> <abc@example.com>\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"Content-Transfer-Encoding: base64\r\n\r\n" +
+			"dGhhbmtzIGZv\r\ncg==\r\n"
+
+	received, err := Parse ([]byte(raw))
+	tools.TestingStackTrace (t, err)
+
+	assert.Equal (t, "abc@example.com", received.InReplyTo)
+	assert.Equal (t, []string { "first@example.com", "abc@example.com" }, received.References)
+	assert.Equal (t, "thanks for", received.Text)
+	assert.Equal (t, []string { "0123456789abcdef0123456789abcdef", "0123456789abcdef0123456789abcdef" }, received.PlusTokens())
+	assert.False (t, received.AutoReply())
+
+	// out of office gets caught by the header or the subject
+	received.Header["Auto-Submitted"] = []string { "auto-replied" }
+	assert.True (t, received.AutoReply())
+
+	received.Header["Auto-Submitted"] = []string { "no" }
+	received.Subject = "Automatic reply: Hello"
+	assert.True (t, received.AutoReply())
+
+	assert.Equal (t, "replies+abc@example.com", PlusAddress ("replies@example.com", "abc"))
+}
+
+func TestQAParseKind (t *testing.T) {
+	reply := &Received {
+		Header: map[string][]string{},
+		Subject: "Re: Hello",
+		Text: "Sounds good, let's talk tuesday.\n\nOn Mon, Jan 1, 2024 at 9:00 AM News <news@example.com> wrote:\n> Hello\n> Unsubscribe here",
+	}
+	assert.Equal (t, "Sounds good, let's talk tuesday.", reply.NewText())
+	assert.Equal (t, ReceivedKind_reply, reply.Kind(), "the unsubscribe in what they quoted doesn't count")
+
+	reply.Text = "Please remove me from your list.\n\n> Hello"
+	assert.Equal (t, ReceivedKind_unsubscribe, reply.Kind())
+
+	// our subject coming back on their reply isn't them asking
+	reply.Subject, reply.Text = "RE: Fwd: Unsubscribe anytime", "Sounds good"
+	assert.Equal (t, ReceivedKind_reply, reply.Kind())
+
+	reply.Subject, reply.Text = "Unsubscribe", ""
+	assert.Equal (t, ReceivedKind_unsubscribe, reply.Kind())
+
+	// some clients only send html
+	reply.Subject = "Re: Hello"
+	reply.Html = `<html><head><style>p { color: red; }</style></head><body><div>Sounds good&nbsp;&amp; thanks<br></div>` +
+					`<div class="gmail_quote"><div>On Mon, Jan 1, 2024 News wrote:</div><blockquote>Unsubscribe here</blockquote></div></body></html>`
+	assert.Equal (t, "Sounds good\u00a0& thanks", reply.NewText())
+	assert.Equal (t, ReceivedKind_reply, reply.Kind(), "the unsubscribe in what they quoted doesn't count")
+
+	reply.Html = `<p>Please take me off your list.</p><blockquote>Hello</blockquote>`
+	assert.Equal (t, ReceivedKind_unsubscribe, reply.Kind())
+
+	reply.Header["Auto-Submitted"] = []string { "auto-replied" }
+	assert.Equal (t, ReceivedKind_autoReply, reply.Kind())
+
+	// a proper delivery status notification, with our email attached
+	raw := "From: Mail Delivery System <MAILER-DAEMON@mx.example.com>\r\n" +
+			"Subject: Undelivered Mail Returned to Sender\r\n" +
+			"Content-Type: multipart/report; report-type=delivery-status; boundary=\"b\"\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain\r\n\r\nThe mail system could not deliver your message.\r\n" +
+			"--b\r\nContent-Type: message/delivery-status\r\n\r\nStatus: 5.1.1\r\n" +
+			"--b\r\nContent-Type: text/rfc822-headers\r\n\r\nMessage-ID: <abc@example.com>\r\nX-Coldbrew-Email: 1234\r\n" +
+			"--b--\r\n"
+
+	bounce, err := Parse ([]byte(raw))
+	tools.TestingStackTrace (t, err)
+	assert.Equal (t, ReceivedKind_bounce, bounce.Kind())
+	assert.Equal (t, "1234", bounce.EmailId())
+	assert.Equal (t, []string { "abc@example.com", "abc" }, bounce.ThreadIds())
+
+	// we only get the headers when reading over imap
+	header, err := Parse ([]byte("From: a@example.com\r\nContent-Type: multipart/alternative; boundary=b\r\n\r\n"))
+	tools.TestingStackTrace (t, err)
+	assert.Equal (t, "a@example.com", header.From.Address)
+}
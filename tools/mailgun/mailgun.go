@@ -0,0 +1,192 @@
+/** ****************************************************************************************************************** **
+	knows how to send emails via the mailgun api, and read the webhooks it sends back
+
+** ****************************************************************************************************************** **/
+
+package mailgun
+
+import (
+	"coldbrew/tools"
+	"coldbrew/tools/mailer"
+
+	"github.com/pkg/errors"
+	json "github.com/json-iterator/go"
+
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+const (
+	baseUrlUS		= "https://api.mailgun.net"
+	baseUrlEU		= "https://api.eu.mailgun.net"
+
+	// how old a webhook can be before we assume someone is replaying it
+	WebhookMaxAge	= time.Minute * 10
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// sends through a single mailgun sending domain
+type Sender struct {
+	APIKey, Domain string
+	EU bool // domains in the eu region use a different host
+
+	BaseUrl string // optional, for pointing at something other than mailgun
+}
+
+type sendResponse struct {
+	Id, Message string
+}
+
+// the body of a mailgun webhook
+type Webhook struct {
+	Signature struct {
+		Timestamp, Token, Signature string
+	}
+	EventData struct {
+		Id, Event, Severity, Reason, Recipient, Url, Ip string
+		Timestamp float64
+		Message struct {
+			Headers struct {
+				MessageId string `json:"message-id"`
+			}
+		}
+		DeliveryStatus struct {
+			Message, Description string
+		} `json:"delivery-status"`
+		ClientInfo struct {
+			UserAgent string `json:"user-agent"`
+		} `json:"client-info"`
+	} `json:"event-data"`
+}
+
+// when this happened
+func (this *Webhook) Time () time.Time {
+	sec, frac := math.Modf (this.EventData.Timestamp)
+	return time.Unix (int64(sec), int64(frac * 1e9))
+}
+
+// the most helpful explanation we have for failures
+func (this *Webhook) Reason () string {
+	if len(this.EventData.DeliveryStatus.Message) > 0 { return this.EventData.DeliveryStatus.Message }
+	if len(this.EventData.DeliveryStatus.Description) > 0 { return this.EventData.DeliveryStatus.Description }
+	return this.EventData.Reason
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func (this *Sender) baseUrl () string {
+	if len(this.BaseUrl) > 0 { return this.BaseUrl }
+	if this.EU { return baseUrlEU }
+	return baseUrlUS
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// sends the email, mailgun gives us back the message id it uses in its webhooks
+func (this *Sender) Send (ctx context.Context, msg *mailer.Message) (string, error) {
+	form := make(url.Values)
+	form.Set ("from", msg.FromHeader())
+	form.Set ("to", msg.To)
+	form.Set ("subject", msg.Subject)
+	if len(msg.Text) > 0 { form.Set ("text", msg.Text) }
+	if len(msg.Html) > 0 { form.Set ("html", msg.Html) }
+	if len(msg.ReplyEmail) > 0 { form.Set ("h:Reply-To", msg.ReplyToHeader()) }
+	if len(msg.Category) > 0 { form.Set ("o:tag", msg.Category) }
+	form.Set ("o:tracking", "yes")
+
+	for key, val := range msg.Headers {
+		form.Set ("h:" + key, val)
+	}
+
+	link := fmt.Sprintf ("%s/v3/%s/messages", this.baseUrl(), url.PathEscape (this.Domain))
+	req, err := http.NewRequestWithContext (ctx, http.MethodPost, link, strings.NewReader (form.Encode()))
+	if err != nil { return "", errors.Wrap (err, link) }
+
+	req.SetBasicAuth ("api", this.APIKey)
+	req.Header.Set ("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do (req)
+	if err != nil { return "", errors.WithStack (err) }
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll (resp.Body)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", errors.WithStack (&tools.HttpError { StatusCode: resp.StatusCode, Body: string(respBody),
+			Message: fmt.Sprintf ("ERROR %d returned from %s : %s : %s", resp.StatusCode, link, msg.To, string(respBody)) })
+	}
+
+	out := &sendResponse{}
+	if err := json.Unmarshal (respBody, out); err != nil { return "", errors.Wrapf (err, " :: %s", string(respBody)) }
+
+	// the webhooks give us the id without the angle brackets
+	return strings.Trim (out.Id, "<>"), nil
+}
+
+// checks the signature mailgun includes in every webhook against our signing key
+func (this *Webhook) Verify (signingKey string) bool {
+	if len(signingKey) == 0 || len(this.Signature.Signature) == 0 { return false }
+
+	h := hmac.New (sha256.New, []byte(signingKey))
+	h.Write ([]byte(this.Signature.Timestamp + this.Signature.Token))
+
+	expected := hex.EncodeToString (h.Sum (nil))
+	return hmac.Equal ([]byte(expected), []byte(this.Signature.Signature))
+}
+
+// makes sure the webhook is recent, otherwise a captured one could be sent to us again
+func (this *Webhook) Fresh (now time.Time) error {
+	sec, err := strconv.ParseInt (this.Signature.Timestamp, 10, 64)
+	if err != nil { return errors.Wrapf (err, "webhook timestamp : %s", this.Signature.Timestamp) }
+
+	age := now.Sub (time.Unix (sec, 0))
+	if age > WebhookMaxAge || age < -WebhookMaxAge {
+		return errors.Errorf ("webhook timestamp is outside the replay window : %s", this.Signature.Timestamp)
+	}
+	return nil
+}
+
+// the tokens from webhooks we've already taken, mailgun gives each one its own
+// only needs to cover the replay window, anything older fails the timestamp check anyway
+type Tokens struct {
+	lock sync.Mutex
+	seen map[string]time.Time
+}
+
+// records the token, returns false if we've already seen it
+func (this *Tokens) Add (token string, now time.Time) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.seen == nil { this.seen = make(map[string]time.Time) }
+
+	for key, at := range this.seen {
+		if now.Sub (at) > WebhookMaxAge * 2 { delete (this.seen, key) } // covers the clock skew in both directions
+	}
+
+	if _, ok := this.seen[token]; ok { return false }
+	this.seen[token] = now
+	return true
+}
@@ -0,0 +1,68 @@
+package mailgun
+
+import (
+	"coldbrew/tools"
+	"coldbrew/tools/mailer"
+
+	"github.com/stretchr/testify/assert"
+	json "github.com/json-iterator/go"
+
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQAMailgunSend (t *testing.T) {
+	var user, pass, from, tag string
+
+	server := httptest.NewServer (http.HandlerFunc (func (w http.ResponseWriter, r *http.Request) {
+		user, pass, _ = r.BasicAuth()
+		from, tag = r.FormValue ("from"), r.FormValue ("o:tag")
+		w.Write ([]byte(`{"id":"<20261018.abc@mg.example.com>","message":"Queued. Thank you."}`))
+	}))
+	defer server.Close()
+
+	sender := &Sender { APIKey: "key-123", Domain: "mg.example.com", BaseUrl: server.URL }
+
+	messageId, err := sender.Send (context.Background(), &mailer.Message {
+		To: "to@example.com", FromEmail: "from@example.com", FromName: "Cold Brew", Subject: "Hello", Text: "plain body", Category: "warmup",
+	})
+	tools.TestingStackTrace (t, err)
+
+	assert.Equal (t, "20261018.abc@mg.example.com", messageId)
+	assert.Equal (t, "api", user)
+	assert.Equal (t, "key-123", pass)
+	assert.Equal (t, `"Cold Brew" <from@example.com>`, from)
+	assert.Equal (t, "warmup", tag)
+}
+
+func TestQAMailgunVerify (t *testing.T) {
+	h := hmac.New (sha256.New, []byte("signing-key"))
+	h.Write ([]byte("1760788800" + "token"))
+
+	webhook := &Webhook{}
+	err := json.Unmarshal ([]byte(`{"signature":{"timestamp":"1760788800","token":"token","signature":"` + hex.EncodeToString (h.Sum (nil)) + `"},
+		"event-data":{"event":"failed","severity":"permanent","recipient":"a@example.com","message":{"headers":{"message-id":"abc@mg.example.com"}}}}`), webhook)
+	tools.TestingStackTrace (t, err)
+
+	assert.True (t, webhook.Verify ("signing-key"))
+	assert.False (t, webhook.Verify ("some-other-key"))
+	assert.False (t, webhook.Verify (""))
+	assert.Equal (t, "abc@mg.example.com", webhook.EventData.Message.Headers.MessageId)
+	assert.Equal (t, "permanent", webhook.EventData.Severity)
+
+	// replays
+	sent := time.Unix (1760788800, 0)
+	assert.NoError (t, webhook.Fresh (sent.Add (time.Minute)))
+	assert.Error (t, webhook.Fresh (sent.Add (time.Hour)))
+
+	tokens := &Tokens{}
+	assert.True (t, tokens.Add (webhook.Signature.Token, sent))
+	assert.False (t, tokens.Add (webhook.Signature.Token, sent.Add (time.Minute)))
+	assert.True (t, tokens.Add (webhook.Signature.Token, sent.Add (time.Hour)), "old tokens get dropped")
+}
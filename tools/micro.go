@@ -6,13 +6,19 @@
 package tools 
 
 import (
+	"coldbrew/tools/tracing"
+
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
 	"context"
 	json "github.com/json-iterator/go"
 	"net/http"
 	"net/url"
 	"bytes"
+	"fmt"
 	"io/ioutil"
 )
 
@@ -20,6 +26,22 @@ import (
  //----- CONSTS ----------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
 
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// returned when the other side responds with a 400 or worse, so callers can check what the status was
+// none of what we sent goes in here, our headers have the api keys in them
+type HttpError struct {
+	StatusCode int
+	Message string
+	Body string // what they sent back
+}
+
+func (this *HttpError) Error () string {
+	return this.Message
+}
+
   //-----------------------------------------------------------------------------------------------------------------------//
  //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
@@ -27,34 +49,57 @@ import (
 
 // high level send, handles in and out body parsing
 func MicroSend (ctx context.Context, requestType, link string, header http.Header, queryParams url.Values, in, out interface{}) ([]byte, error) {
+	_, respBody, err := MicroSendHeader (ctx, requestType, link, header, queryParams, in, out)
+	return respBody, err
+}
+
+// same as MicroSend, but also returns the response headers for the apis that put what we need in there
+func MicroSendHeader (ctx context.Context, requestType, link string, header http.Header, queryParams url.Values, in, out interface{}) (retHeader http.Header, respBody []byte, err error) {
 	var jstr []byte 
-	var err error 
 
 	if in != nil {
 		jstr, err = json.Marshal (in)
-		if err != nil { return nil, errors.WithStack (err) }
+		if err != nil { return nil, nil, errors.WithStack (err) }
 	}
 
 	req, err := http.NewRequestWithContext (ctx, requestType, link, bytes.NewBuffer(jstr))
-	if err != nil { return nil, errors.Wrap (err, link) }
+	if err != nil { return nil, nil, errors.Wrap (err, link) }
+
+	ctx, span := tracing.Start (ctx, requestType + " " + req.URL.Host, trace.SpanKindClient,
+		attribute.String ("http.request.method", requestType),
+		attribute.String ("url.path", req.URL.Path),
+		attribute.String ("server.address", req.URL.Host))
+	defer func() { 
+		tracing.Error (span, err)
+		span.End() 
+	}()
+	req = req.WithContext (ctx)
 
 	req.URL.RawQuery = queryParams.Encode() // set our query params
-	req.Header = header // set our header information
+	req.Header = header.Clone() // set our header information, copied so the trace headers don't end up in the caller's
+	if req.Header == nil { req.Header = make(http.Header) }
+	if tracing.Internal (req.URL.Hostname()) { // the providers don't get to see our trace
+		tracing.Inject (ctx, propagation.HeaderCarrier(req.Header))
+	}
 
 	// we're ready to actually do the request now
 	resp, err := http.DefaultClient.Do (req)
-	if err != nil { return nil, errors.WithStack (err) }
+	if err != nil { return nil, nil, errors.WithStack (err) }
 	defer resp.Body.Close()
 
-	respBody, _ := ioutil.ReadAll (resp.Body) // i don't think we need to check the error on this one
+	span.SetAttributes (attribute.Int ("http.response.status_code", resp.StatusCode))
+
+	respBody, _ = ioutil.ReadAll (resp.Body) // i don't think we need to check the error on this one
 
 	if resp.StatusCode >= http.StatusBadRequest { // 400 or worse
-		return respBody, errors.Errorf("ERROR %d returned from %s : %s : %+v : %s :: %s", resp.StatusCode, link, string(jstr), header, queryParams.Encode(), string(respBody))
+		// just the path, some apis take the key as a query param
+		return resp.Header, respBody, errors.WithStack (&HttpError { StatusCode: resp.StatusCode, Body: string(respBody),
+			Message: fmt.Sprintf("ERROR %d returned from %s%s :: %s", resp.StatusCode, req.URL.Host, req.URL.Path, string(respBody)) })
 	}
 
 	if out != nil && resp.StatusCode >= http.StatusOK && resp.StatusCode <= http.StatusCreated { // 200 or 201
 		err = errors.Wrapf (json.Unmarshal (respBody, out), " :: %s", string(respBody))
 	}
 
-	return respBody, err 
+	return resp.Header, respBody, err 
 }
@@ -0,0 +1,185 @@
+/** ****************************************************************************************************************** **
+	knows how to send emails via the postmark api, and read the webhooks it sends back
+
+** ****************************************************************************************************************** **/
+
+package postmark
+
+import (
+	"coldbrew/tools"
+	"coldbrew/tools/mailer"
+
+	"github.com/pkg/errors"
+	json "github.com/json-iterator/go"
+
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+const (
+	baseUrl				= "https://api.postmarkapp.com"
+	defaultStream		= "outbound"
+)
+
+const (
+	RecordType_delivery			= "Delivery"
+	RecordType_bounce			= "Bounce"
+	RecordType_spamComplaint	= "SpamComplaint"
+	RecordType_open				= "Open"
+	RecordType_click			= "Click"
+	RecordType_subscription		= "SubscriptionChange"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// sends through a single postmark server
+type Sender struct {
+	ServerToken string
+	MessageStream string // optional, defaults to the transactional outbound stream
+
+	BaseUrl string // optional, for pointing at something other than postmark
+}
+
+type header struct {
+	Name, Value string
+}
+
+type sendRequest struct {
+	From, To, Subject string
+	ReplyTo string `json:",omitempty"`
+	TextBody string `json:",omitempty"`
+	HtmlBody string `json:",omitempty"`
+	Tag string `json:",omitempty"`
+	Headers []header `json:",omitempty"`
+	TrackOpens bool
+	TrackLinks string
+	MessageStream string
+}
+
+type sendResponse struct {
+	ErrorCode int
+	Message, MessageID string
+}
+
+// the body of a postmark webhook, the fields that are set depend on the record type
+type Webhook struct {
+	RecordType, MessageID, Recipient, Email string
+	Type, Description, Details, OriginalLink string // bounces and clicks
+	SuppressSending bool // subscription changes
+	UserAgent string
+	Geo struct {
+		IP string
+	}
+	ReceivedAt, DeliveredAt, BouncedAt, ChangedAt time.Time
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// sends the email, postmark gives us back the message id it uses in its webhooks
+func (this *Sender) Send (ctx context.Context, msg *mailer.Message) (string, error) {
+	in := &sendRequest {
+		From: msg.FromHeader(),
+		To: msg.To,
+		Subject: msg.Subject,
+		TextBody: msg.Text,
+		HtmlBody: msg.Html,
+		Tag: msg.Category,
+		TrackOpens: true,
+		TrackLinks: "HtmlAndText",
+		MessageStream: this.MessageStream,
+	}
+	if len(in.MessageStream) == 0 { in.MessageStream = defaultStream }
+	if len(msg.ReplyEmail) > 0 { in.ReplyTo = msg.ReplyToHeader() }
+
+	for key, val := range msg.Headers {
+		in.Headers = append (in.Headers, header { Name: key, Value: val })
+	}
+	sort.Slice (in.Headers, func (i, j int) bool { return in.Headers[i].Name < in.Headers[j].Name })
+
+	body, err := json.Marshal (in)
+	if err != nil { return "", errors.WithStack (err) }
+
+	link := this.BaseUrl
+	if len(link) == 0 { link = baseUrl }
+	link += "/email"
+
+	req, err := http.NewRequestWithContext (ctx, http.MethodPost, link, bytes.NewReader (body))
+	if err != nil { return "", errors.Wrap (err, link) }
+
+	req.Header.Set ("Accept", "application/json")
+	req.Header.Set ("Content-Type", "application/json")
+	req.Header.Set ("X-Postmark-Server-Token", this.ServerToken)
+
+	resp, err := http.DefaultClient.Do (req)
+	if err != nil { return "", errors.WithStack (err) }
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll (resp.Body)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", errors.WithStack (&tools.HttpError { StatusCode: resp.StatusCode, Body: string(respBody),
+			Message: fmt.Sprintf ("ERROR %d returned from %s : %s : %s", resp.StatusCode, link, msg.To, string(respBody)) })
+	}
+
+	out := &sendResponse{}
+	if err := json.Unmarshal (respBody, out); err != nil { return "", errors.Wrapf (err, " :: %s", string(respBody)) }
+
+	// postmark can return a 200 with an error code in the body
+	if out.ErrorCode != 0 {
+		return "", errors.Errorf ("ERROR code %d returned from %s : %s : %s", out.ErrorCode, link, msg.To, out.Message)
+	}
+
+	return out.MessageID, nil
+}
+
+// who this event is about, deliveries and bounces use a different field than the rest
+func (this *Webhook) Address () string {
+	if len(this.Recipient) > 0 { return this.Recipient }
+	return this.Email
+}
+
+// when this happened, each record type has its own field for it
+func (this *Webhook) Time () time.Time {
+	for _, t := range []time.Time { this.DeliveredAt, this.BouncedAt, this.ReceivedAt, this.ChangedAt } {
+		if t.IsZero() == false { return t }
+	}
+	return time.Time{}
+}
+
+// the most helpful explanation we have for bounces
+func (this *Webhook) Reason () string {
+	if len(this.Details) > 0 { return this.Details }
+	return this.Description
+}
+
+// postmark webhooks use basic auth in the url we give them, the password is what we check
+func VerifyAuth (header, password string) bool {
+	if len(password) == 0 { return false }
+
+	encoded, ok := strings.CutPrefix (header, "Basic ")
+	if ok == false { return false }
+
+	decoded, err := base64.StdEncoding.DecodeString (encoded)
+	if err != nil { return false }
+
+	_, pass, ok := strings.Cut (string(decoded), ":")
+	if ok == false { return false }
+
+	return subtle.ConstantTimeCompare ([]byte(pass), []byte(password)) == 1
+}
@@ -0,0 +1,60 @@
+package postmark
+
+import (
+	"coldbrew/tools"
+	"coldbrew/tools/mailer"
+
+	"github.com/stretchr/testify/assert"
+	json "github.com/json-iterator/go"
+
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQAPostmarkSend (t *testing.T) {
+	var got sendRequest
+	var token string
+
+	server := httptest.NewServer (http.HandlerFunc (func (w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get ("X-Postmark-Server-Token")
+		body, _ := io.ReadAll (r.Body)
+		json.Unmarshal (body, &got)
+		w.Write ([]byte(`{"ErrorCode":0,"Message":"OK","MessageID":"b7bc2f4a-e38e-4336-af7d-e6c392c2f817"}`))
+	}))
+	defer server.Close()
+
+	sender := &Sender { ServerToken: "server-token", BaseUrl: server.URL }
+	msg := &mailer.Message {
+		To: "to@example.com", FromEmail: "from@example.com", Subject: "Hello", Html: "<p>html body</p>", Category: "warmup",
+		Headers: map[string]string { "X-Second": "2", "X-First": "1" },
+	}
+
+	messageId, err := sender.Send (context.Background(), msg)
+	tools.TestingStackTrace (t, err)
+
+	assert.Equal (t, "b7bc2f4a-e38e-4336-af7d-e6c392c2f817", messageId)
+	assert.Equal (t, "server-token", token)
+	assert.Equal (t, defaultStream, got.MessageStream)
+	assert.Equal (t, "warmup", got.Tag)
+	assert.Equal (t, []header { { "X-First", "1" }, { "X-Second", "2" } }, got.Headers)
+
+	// errors can come back with a 200
+	server.Config.Handler = http.HandlerFunc (func (w http.ResponseWriter, r *http.Request) {
+		w.Write ([]byte(`{"ErrorCode":406,"Message":"You tried to send to a recipient that has been marked as inactive."}`))
+	})
+	_, err = sender.Send (context.Background(), msg)
+	assert.Error (t, err)
+}
+
+func TestQAPostmarkVerifyAuth (t *testing.T) {
+	header := "Basic " + base64.StdEncoding.EncodeToString ([]byte("postmark:webhook-pass"))
+
+	assert.True (t, VerifyAuth (header, "webhook-pass"))
+	assert.False (t, VerifyAuth (header, "wrong"))
+	assert.False (t, VerifyAuth (header, ""))
+	assert.False (t, VerifyAuth ("", "webhook-pass"))
+}
@@ -0,0 +1,159 @@
+/** ****************************************************************************************************************** **
+	sendgrid's inbound parse webhook, emails sent to our reply domain get posted to us as a multipart form
+	https://www.twilio.com/docs/sendgrid/for-developers/parsing-email/setting-up-the-inbound-parse-webhook
+
+** ****************************************************************************************************************** **/
+
+package sendgrid
+
+import (
+	"coldbrew/tools/mailer"
+
+	"github.com/pkg/errors"
+	json "github.com/json-iterator/go"
+
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// we only want the headers out of an attached email, no reason to read a huge one
+const inboundMaxOriginal = 1 << 20
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// a single email posted to us by inbound parse
+type Inbound struct {
+	Headers, From, To, Cc, Subject, Text, Html string
+	Raw string // the whole email, only when the parse is set to post the raw message
+	SpamScore float64
+	Envelope InboundEnvelope
+	Attachments []*mailer.Attachment
+
+	original []byte // an email attached to this one, which is how bounces come back
+}
+
+// who the mail server says it was from and to, which can differ from the headers
+type InboundEnvelope struct {
+	To []string `json:"to"`
+	From string `json:"from"`
+}
+
+// what sendgrid tells us about each attachment in the attachment-info field
+type inboundAttachmentInfo struct {
+	Filename string `json:"filename"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	ContentId string `json:"content-id"`
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// inbound parse doesn't sign anything, so we put basic auth in the url we give it
+func VerifyInbound (header, password string) bool {
+	if len(password) == 0 { return false }
+
+	encoded, ok := strings.CutPrefix (header, "Basic ")
+	if ok == false { return false }
+
+	decoded, err := base64.StdEncoding.DecodeString (encoded)
+	if err != nil { return false }
+
+	_, pass, ok := strings.Cut (string(decoded), ":")
+	if ok == false { return false }
+
+	return subtle.ConstantTimeCompare ([]byte(pass), []byte(password)) == 1
+}
+
+// pulls the fields we care about out of the posted form
+func ParseInbound (form *multipart.Form) (*Inbound, error) {
+	value := func (key string) string {
+		if vals := form.Value[key]; len(vals) > 0 { return vals[0] }
+		return ""
+	}
+
+	ret := &Inbound {
+		Headers: value ("headers"),
+		From: value ("from"),
+		To: value ("to"),
+		Cc: value ("cc"),
+		Subject: value ("subject"),
+		Text: value ("text"),
+		Html: value ("html"),
+		Raw: value ("email"),
+	}
+
+	if len(ret.Headers) == 0 && len(ret.Raw) == 0 { return nil, errors.New ("inbound parse is missing the headers") }
+
+	if envelope := value ("envelope"); len(envelope) > 0 {
+		if err := json.Unmarshal ([]byte(envelope), &ret.Envelope); err != nil { return nil, errors.Wrapf (err, "inbound envelope : %s", envelope) }
+	}
+
+	ret.SpamScore, _ = strconv.ParseFloat (value ("spam_score"), 64) // not there unless spam checking is on
+
+	info := make(map[string]inboundAttachmentInfo)
+	if raw := value ("attachment-info"); len(raw) > 0 {
+		if err := json.Unmarshal ([]byte(raw), &info); err != nil { return nil, errors.Wrapf (err, "inbound attachment info : %s", raw) }
+	}
+
+	// attachment1, attachment2, ... attachment10, in the order they were on the email
+	keys := make([]string, 0, len(form.File))
+	for key := range form.File { keys = append (keys, key) }
+	sort.Slice (keys, func (i, j int) bool {
+		if len(keys[i]) != len(keys[j]) { return len(keys[i]) < len(keys[j]) }
+		return keys[i] < keys[j]
+	})
+
+	for _, key := range keys {
+		for _, file := range form.File[key] {
+			attachment := &mailer.Attachment { Filename: file.Filename, Type: file.Header.Get ("Content-Type"), Size: file.Size }
+			if details, ok := info[key]; ok {
+				if len(details.Filename) > 0 { attachment.Filename = details.Filename }
+				if len(details.Type) > 0 { attachment.Type = details.Type }
+				attachment.ContentId = mailer.CleanMessageId (details.ContentId)
+			}
+
+			mediaType, _, _ := mime.ParseMediaType (attachment.Type)
+			if mailer.OriginalType (mediaType) && ret.original == nil {
+				f, err := file.Open()
+				if err != nil { return nil, errors.Wrapf (err, "inbound attachment : %s", key) }
+				ret.original, err = io.ReadAll (io.LimitReader (f, inboundMaxOriginal))
+				f.Close()
+				if err != nil { return nil, errors.Wrapf (err, "inbound attachment : %s", key) }
+			}
+
+			ret.Attachments = append (ret.Attachments, attachment)
+		}
+	}
+
+	return ret, nil
+}
+
+// the email as if we had read it ourselves, so it can be matched and sorted like the ones from imap
+func (this *Inbound) Received () (*mailer.Received, error) {
+	if len(this.Raw) > 0 { return mailer.Parse ([]byte(this.Raw)) } // it's all in there
+
+	// sendgrid already split out the bodies, so we only need the headers parsed
+	ret, err := mailer.Parse ([]byte(strings.TrimRight (this.Headers, "\r\n") + "\r\n\r\n"))
+	if err != nil { return nil, err }
+
+	ret.Text, ret.Html = this.Text, this.Html
+	if len(ret.Subject) == 0 { ret.Subject = this.Subject }
+	ret.Attachments = this.Attachments
+	if this.original != nil { ret.SetOriginal (this.original) }
+
+	return ret, nil
+}
@@ -0,0 +1,76 @@
+package sendgrid
+
+import (
+	"coldbrew/tools"
+	"coldbrew/tools/mailer"
+
+	"github.com/stretchr/testify/assert"
+
+	"bytes"
+	"encoding/base64"
+	"mime/multipart"
+	"net/textproto"
+	"testing"
+)
+
+func TestQASendgridInbound (t *testing.T) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter (body)
+
+	fields := map[string]string {
+		"headers": "From: Mailer Daemon <MAILER-DAEMON@mx.example.com>\nTo: replies@example.com\nSubject: Undelivered Mail Returned to Sender\n" +
+					"Message-ID: <dsn@mx.example.com>\nContent-Type: multipart/report; report-type=delivery-status; boundary=\"xx\"\n",
+		"text": "This is the mail system. Your message could not be delivered.",
+		"subject": "Undelivered Mail Returned to Sender",
+		"to": "replies@example.com",
+		"envelope": `{"to":["replies+abc@example.com"],"from":"MAILER-DAEMON@mx.example.com"}`,
+		"spam_score": "0.4",
+		"attachment-info": `{"attachment1":{"filename":"original.eml","name":"original.eml","type":"message/rfc822","content-id":"<orig>"}}`,
+	}
+	for key, val := range fields {
+		tools.TestingStackTrace (t, w.WriteField (key, val))
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set ("Content-Disposition", `form-data; name="attachment1"; filename="original.eml"`)
+	header.Set ("Content-Type", "message/rfc822")
+	part, err := w.CreatePart (header)
+	tools.TestingStackTrace (t, err)
+	part.Write ([]byte("To: someone@example.com\r\nMessage-ID: <abc@example.com>\r\nX-Coldbrew-Email: 1234\r\n\r\nhello\r\n"))
+	tools.TestingStackTrace (t, w.Close())
+
+	form, err := multipart.NewReader (body, w.Boundary()).ReadForm (1 << 20)
+	tools.TestingStackTrace (t, err)
+
+	inbound, err := ParseInbound (form)
+	tools.TestingStackTrace (t, err)
+	assert.Equal (t, []string { "replies+abc@example.com" }, inbound.Envelope.To)
+	assert.Equal (t, 0.4, inbound.SpamScore)
+
+	if assert.Len (t, inbound.Attachments, 1) {
+		assert.Equal (t, "original.eml", inbound.Attachments[0].Filename)
+		assert.Equal (t, "orig", inbound.Attachments[0].ContentId)
+	}
+
+	msg, err := inbound.Received()
+	tools.TestingStackTrace (t, err)
+	assert.Equal (t, "dsn@mx.example.com", msg.MessageId)
+	assert.Equal (t, fields["text"], msg.Text)
+	assert.Equal (t, mailer.ReceivedKind_bounce, msg.Kind())
+
+	// the bounce threads back onto our email through the one attached to it
+	assert.Equal (t, "1234", msg.EmailId())
+	assert.Contains (t, msg.ThreadIds(), "abc@example.com")
+
+	// nothing to thread without the headers
+	_, err = ParseInbound (&multipart.Form { Value: map[string][]string { "text": { "hi" } } })
+	assert.Error (t, err)
+}
+
+func TestQASendgridVerifyInbound (t *testing.T) {
+	auth := "Basic " + base64.StdEncoding.EncodeToString ([]byte("sendgrid:secret"))
+	assert.True (t, VerifyInbound (auth, "secret"))
+	assert.False (t, VerifyInbound (auth, "other"))
+	assert.False (t, VerifyInbound (auth, ""), "no key turns everyone away")
+	assert.False (t, VerifyInbound ("", "secret"))
+}
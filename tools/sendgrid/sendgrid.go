@@ -7,21 +7,33 @@ package sendgrid
 
 import (
 	"coldbrew/tools"
+	"coldbrew/tools/mailer"
 	
 	"github.com/pkg/errors"
 
+	"context"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
   //-----------------------------------------------------------------------------------------------------------------------//
  //----- CONSTS ----------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
 
+const baseUrl = "https://api.sendgrid.com"
+
   //-----------------------------------------------------------------------------------------------------------------------//
  //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
 
+// sends through a single sendgrid account
+type Sender struct {
+	APIToken, IpPool string
+
+	BaseUrl string // optional, for pointing at something other than sendgrid
+}
+
 type sendgridContent struct {
 	Type string `json:"type"`
 	Value string `json:"value"`
@@ -34,7 +46,8 @@ type sendgridUser struct {
 
 type sendgridPersonalization struct {
 	To []sendgridUser `json:"to"`
-	Data map[string]string `json:"dynamic_template_data"`
+	Data map[string]string `json:"dynamic_template_data,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
 }
 
 type sendgridTracking struct {
@@ -52,60 +65,72 @@ type sendgridTracking struct {
  //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
 //-----------------------------------------------------------------------------------------------------------------------//
 
-// generic function to help send transactional emails through sendgrid
-func SendEmail (apiToken, email, category, subject, textBody, htmlBody, pool, fromEmail, fromName, replyName, replyEmail string) error {
-	ctx, cancel := tools.TimeDuration (60).Context ("sendEmail")
-	defer cancel()
-
+// sends the email through sendgrid
+func (this *Sender) Send (ctx context.Context, msg *mailer.Message) (string, error) {
 	header := make(http.Header)
     header.Add("Content-Type", "application/json")
-    header.Add("Authorization", "Bearer " + apiToken)
+    header.Add("Authorization", "Bearer " + this.APIToken)
 
 	var req struct {
 		Personalization []sendgridPersonalization `json:"personalizations"`
 		From sendgridUser `json:"from"`
-		Reply sendgridUser `json:"reply_to"`
+		Reply *sendgridUser `json:"reply_to,omitempty"`
 		Subject string `json:"subject"`
 		Tracking sendgridTracking `json:"tracking_settings"`
 		Content []sendgridContent `json:"content"`
-		Categories []string `json:"categories"`
+		Categories []string `json:"categories,omitempty"`
 		Pool string `json:"ip_pool_name,omitempty"`
 	}
 
-	req.From.Email = fromEmail
-	req.From.Name = fromName
-	req.Reply.Email = replyEmail
-	req.Reply.Name = replyName
-	req.Pool = pool
-	req.Subject = subject
+	req.From.Email = msg.FromEmail
+	req.From.Name = msg.FromName
+	if len(msg.ReplyEmail) > 0 {
+		req.Reply = &sendgridUser { Email: msg.ReplyEmail, Name: msg.ReplyName }
+	}
+	req.Pool = this.IpPool
+	req.Subject = msg.Subject
 	req.Tracking.Open.Enable = true 
 	req.Tracking.Click.Enable = true 
-	req.Categories = append (req.Categories, category)
+	if len(msg.Category) > 0 {
+		req.Categories = append (req.Categories, msg.Category)
+	}
 
-	if len(textBody) > 0 {
+	if len(msg.Text) > 0 {
 		req.Content = append (req.Content, sendgridContent {
 			Type: "text/plain",
-			Value: textBody,
+			Value: msg.Text,
 		})
 	}
-	if len(htmlBody) > 0 {
+	if len(msg.Html) > 0 {
 		req.Content = append (req.Content, sendgridContent {
 			Type: "text/html",
-			Value: htmlBody,
+			Value: msg.Html,
 		})
 	}
 
-	per := sendgridPersonalization{}
+	per := sendgridPersonalization {
+		Headers: msg.Headers,
+	}
 	per.To = append(per.To, sendgridUser {
-		Email: email,
+		Email: msg.To,
 	})
 
 	req.Personalization = append (req.Personalization, per)
 	
-	resp, err := tools.MicroSend (ctx, http.MethodPost, "https://api.sendgrid.com/v3/mail/send", header, make(url.Values), req, nil)
+	link := this.BaseUrl
+	if len(link) == 0 { link = baseUrl }
+
+	respHeader, resp, err := tools.MicroSendHeader (ctx, http.MethodPost, link + "/v3/mail/send", header, make(url.Values), req, nil)
 	if err != nil {
-		return errors.Wrapf(err, "%s", string(resp))
+		return "", errors.Wrapf(err, "%s", string(resp))
 	}
 
-	return nil 
+	return respHeader.Get ("X-Message-Id"), nil // sendgrid only gives us the message id in the response headers
+}
+
+// the webhooks add their own suffix to the id we got back when sending, this strips it off so they match
+// ex: 14c5d75ce93.dfd.64b469.filterdrecv-p3mdw1-756b745b58-kmzbl-18-5F5FC76C-9.0
+func MessageId (sgMessageId string) string {
+	id, _, _ := strings.Cut (sgMessageId, ".filter")
+	return id
 }
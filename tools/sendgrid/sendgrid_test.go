@@ -0,0 +1,40 @@
+package sendgrid
+
+import (
+	"coldbrew/tools"
+	"coldbrew/tools/mailer"
+
+	"github.com/stretchr/testify/assert"
+
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQASendgridSend (t *testing.T) {
+	var auth string
+
+	server := httptest.NewServer (http.HandlerFunc (func (w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get ("Authorization")
+		w.Header().Set ("X-Message-Id", "Dx9Pz1k8RtGQ0aDfCBoSbA")
+		w.WriteHeader (http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender := &Sender { APIToken: "SG.token", BaseUrl: server.URL }
+
+	messageId, err := sender.Send (context.Background(), &mailer.Message {
+		To: "to@example.com", FromEmail: "from@example.com", Subject: "Hello", Text: "plain body",
+	})
+	tools.TestingStackTrace (t, err)
+
+	assert.Equal (t, "Bearer SG.token", auth)
+	assert.Equal (t, "Dx9Pz1k8RtGQ0aDfCBoSbA", messageId)
+}
+
+func TestQASendgridMessageId (t *testing.T) {
+	assert.Equal (t, "Dx9Pz1k8RtGQ0aDfCBoSbA", MessageId ("Dx9Pz1k8RtGQ0aDfCBoSbA.filterdrecv-p3mdw1-756b745b58-kmzbl-18-5F5FC76C-9.0"))
+	assert.Equal (t, "14c5d75ce93.dfd.64b469", MessageId ("14c5d75ce93.dfd.64b469.filter0001.16648.5515E0B88.0"))
+	assert.Equal (t, "Dx9Pz1k8RtGQ0aDfCBoSbA", MessageId ("Dx9Pz1k8RtGQ0aDfCBoSbA"))
+}
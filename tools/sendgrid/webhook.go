@@ -0,0 +1,64 @@
+/** ****************************************************************************************************************** **
+	sendgrid's signed event webhook, so we know the events really came from them
+	https://www.twilio.com/docs/sendgrid/for-developers/tracking-events/getting-started-event-webhook-security-features
+
+** ****************************************************************************************************************** **/
+
+package sendgrid
+
+import (
+	"github.com/pkg/errors"
+
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"strconv"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+const (
+	HeaderSignature		= "X-Twilio-Email-Event-Webhook-Signature"
+	HeaderTimestamp		= "X-Twilio-Email-Event-Webhook-Timestamp"
+
+	// how old a webhook can be before we assume someone is replaying it
+	WebhookMaxAge		= time.Minute * 10
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// makes sure the webhook timestamp is recent, otherwise a captured request could be sent to us again
+func WebhookFresh (timestamp string, now time.Time) error {
+	sec, err := strconv.ParseInt (timestamp, 10, 64)
+	if err != nil { return errors.Wrapf (err, "webhook timestamp : %s", timestamp) }
+
+	age := now.Sub (time.Unix (sec, 0))
+	if age > WebhookMaxAge || age < -WebhookMaxAge {
+		return errors.Errorf ("webhook timestamp is outside the replay window : %s", timestamp)
+	}
+	return nil
+}
+
+// checks the signature against the public key from the sendgrid account's mail settings
+func VerifyWebhook (publicKey, signature, timestamp string, body []byte) bool {
+	der, err := base64.StdEncoding.DecodeString (publicKey)
+	if err != nil { return false }
+
+	key, err := x509.ParsePKIXPublicKey (der)
+	if err != nil { return false }
+
+	pub, ok := key.(*ecdsa.PublicKey)
+	if ok == false { return false }
+
+	sig, err := base64.StdEncoding.DecodeString (signature)
+	if err != nil { return false }
+
+	digest := sha256.Sum256 (append ([]byte(timestamp), body...))
+	return ecdsa.VerifyASN1 (pub, digest[:], sig)
+}
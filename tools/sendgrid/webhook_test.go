@@ -0,0 +1,44 @@
+package sendgrid
+
+import (
+	"coldbrew/tools"
+
+	"github.com/stretchr/testify/assert"
+
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestQASendgridVerifyWebhook (t *testing.T) {
+	key, err := ecdsa.GenerateKey (elliptic.P256(), rand.Reader)
+	tools.TestingStackTrace (t, err)
+
+	der, err := x509.MarshalPKIXPublicKey (&key.PublicKey)
+	tools.TestingStackTrace (t, err)
+	publicKey := base64.StdEncoding.EncodeToString (der)
+
+	body := []byte(`[{"email":"a@example.com","event":"spamreport","sg_message_id":"abc.filterdrecv-1"}]`)
+	timestamp := strconv.FormatInt (time.Now().Unix(), 10)
+
+	digest := sha256.Sum256 (append ([]byte(timestamp), body...))
+	sig, err := ecdsa.SignASN1 (rand.Reader, key, digest[:])
+	tools.TestingStackTrace (t, err)
+	signature := base64.StdEncoding.EncodeToString (sig)
+
+	assert.True (t, VerifyWebhook (publicKey, signature, timestamp, body))
+	assert.False (t, VerifyWebhook (publicKey, signature, timestamp, []byte(`[]`)))
+	assert.False (t, VerifyWebhook (publicKey, signature, "1", body))
+	assert.False (t, VerifyWebhook ("", signature, timestamp, body))
+
+	// replays
+	assert.NoError (t, WebhookFresh (timestamp, time.Now()))
+	assert.Error (t, WebhookFresh (timestamp, time.Now().Add (time.Hour)))
+	assert.Error (t, WebhookFresh ("not a number", time.Now()))
+}
@@ -0,0 +1,98 @@
+/** ****************************************************************************************************************** **
+	Send windows, the hours of the day emails are allowed to land in the recipient's time zone
+	ex: weekdays 08:00-17:00 means someone in Chicago gets it between 8am and 5pm central, monday through friday
+
+** ****************************************************************************************************************** **/
+
+package sendwindow
+
+import (
+	"github.com/pkg/errors"
+
+	"time"
+	_ "time/tzdata" // so the zones load even when the container doesn't have them
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type Window struct {
+	Days []time.Weekday // 0 is sunday, empty means every day
+	Start, End string // 24 hour local time, ex: "08:00" and "17:00", the end has to come after the start
+	Zone string // for recipients we don't know the time zone of, empty is UTC
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// minutes into the day
+func clock (str string) (int, error) {
+	t, err := time.Parse ("15:04", str)
+	if err != nil { return 0, errors.Errorf ("send window time should look like 08:00 : %s", str) }
+	return t.Hour() * 60 + t.Minute(), nil
+}
+
+func (this *Window) day (day time.Weekday) bool {
+	if len(this.Days) == 0 { return true }
+
+	for _, d := range this.Days {
+		if d == day { return true }
+	}
+	return false
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func (this *Window) Valid () error {
+	start, err := clock (this.Start)
+	if err != nil { return err }
+
+	end, err := clock (this.End)
+	if err != nil { return err }
+
+	if end <= start { return errors.Errorf ("send window has to end after it starts : %s - %s", this.Start, this.End) }
+
+	for _, d := range this.Days {
+		if d < time.Sunday || d > time.Saturday { return errors.Errorf ("send window day is invalid : %d", d) }
+	}
+
+	if _, err := time.LoadLocation (this.Zone); err != nil { return errors.Wrap (err, "send window zone") }
+	return nil
+}
+
+// the zone to use when the recipient's is nil
+func (this *Window) Location (loc *time.Location) *time.Location {
+	if loc != nil { return loc }
+
+	loc, err := time.LoadLocation (this.Zone)
+	if err != nil { return time.UTC } // Valid catches this
+	return loc
+}
+
+// the first time at or after this one that's inside the window for someone in this location
+// zero if the window never opens
+func (this *Window) Next (t time.Time, loc *time.Location) time.Time {
+	start, err := clock (this.Start)
+	if err != nil { return time.Time{} }
+	end, err := clock (this.End)
+	if err != nil { return time.Time{} }
+
+	local := t.In (this.Location (loc))
+	for i := 0; i < 8; i++ { // a week and a day covers every possible window
+		day := time.Date (local.Year(), local.Month(), local.Day() + i, 0, 0, 0, 0, local.Location())
+		if this.day (day.Weekday()) == false { continue }
+
+		// built from the wall clock rather than adding minutes to midnight, so a dst change doesn't shift the window an hour
+		opens := time.Date (day.Year(), day.Month(), day.Day(), start / 60, start % 60, 0, 0, day.Location())
+		closes := time.Date (day.Year(), day.Month(), day.Day(), end / 60, end % 60, 0, 0, day.Location())
+
+		if local.Before (opens) { return opens }
+		if local.Before (closes) { return local }
+	}
+
+	return time.Time{}
+}
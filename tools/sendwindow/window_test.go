@@ -0,0 +1,75 @@
+package sendwindow
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"testing"
+	"time"
+)
+
+func TestQAWindowNext (t *testing.T) {
+	window := &Window { Days: []time.Weekday { time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday }, 
+						Start: "08:00", End: "17:00", Zone: "America/Chicago" }
+	require.NoError (t, window.Valid())
+
+	chicago := Zone ("America/Chicago")
+	london := Zone ("Europe/London")
+
+	// wednesday 3am central waits until 8am
+	at := time.Date (2026, 10, 14, 3, 0, 0, 0, chicago)
+	assert.Equal (t, time.Date (2026, 10, 14, 8, 0, 0, 0, chicago), window.Next (at, chicago))
+
+	// inside the window goes right away
+	at = time.Date (2026, 10, 14, 9, 30, 0, 0, chicago)
+	assert.Equal (t, at, window.Next (at, chicago))
+
+	// friday after hours waits for monday
+	at = time.Date (2026, 10, 16, 17, 0, 0, 0, chicago)
+	assert.Equal (t, time.Date (2026, 10, 19, 8, 0, 0, 0, chicago), window.Next (at, chicago))
+
+	// 3pm central is 9pm in london, so they get it the next morning their time
+	at = time.Date (2026, 10, 14, 15, 0, 0, 0, chicago)
+	assert.Equal (t, time.Date (2026, 10, 15, 8, 0, 0, 0, london), window.Next (at, london))
+
+	// no zone for the user falls back to the window's
+	at = time.Date (2026, 10, 14, 3, 0, 0, 0, chicago)
+	assert.Equal (t, time.Date (2026, 10, 14, 8, 0, 0, 0, chicago), window.Next (at, nil))
+}
+
+func TestQAWindowNextDST (t *testing.T) {
+	window := &Window { Start: "08:00", End: "17:00" }
+	newYork := Zone ("America/New_York")
+
+	// clocks jump forward at 2am on march 8th, 8am is still 8am
+	at := time.Date (2026, 3, 8, 0, 30, 0, 0, newYork)
+	assert.Equal (t, time.Date (2026, 3, 8, 8, 0, 0, 0, newYork), window.Next (at, newYork))
+
+	// 4:30pm is still inside, 5pm isn't
+	at = time.Date (2026, 3, 8, 16, 30, 0, 0, newYork)
+	assert.Equal (t, at, window.Next (at, newYork))
+
+	at = time.Date (2026, 3, 8, 17, 0, 0, 0, newYork)
+	assert.Equal (t, time.Date (2026, 3, 9, 8, 0, 0, 0, newYork), window.Next (at, newYork))
+
+	// and back again in november
+	at = time.Date (2026, 11, 1, 0, 30, 0, 0, newYork)
+	assert.Equal (t, time.Date (2026, 11, 1, 8, 0, 0, 0, newYork), window.Next (at, newYork))
+}
+
+func TestQAWindowValid (t *testing.T) {
+	assert.NoError (t, (&Window { Start: "08:00", End: "17:00" }).Valid())
+	assert.Error (t, (&Window { Start: "17:00", End: "08:00" }).Valid())
+	assert.Error (t, (&Window { Start: "8am", End: "17:00" }).Valid())
+	assert.Error (t, (&Window { Start: "08:00", End: "17:00", Days: []time.Weekday { 7 } }).Valid())
+	assert.Error (t, (&Window { Start: "08:00", End: "17:00", Zone: "Mars/Olympus" }).Valid())
+}
+
+func TestQAGuessZone (t *testing.T) {
+	assert.Equal (t, "America/Los_Angeles", GuessZone ("US", "ca").String())
+	assert.Equal (t, "America/Chicago", GuessZone ("United States", "").String())
+	assert.Equal (t, "America/Vancouver", GuessZone ("CA", "BC").String())
+	assert.Equal (t, "Europe/London", GuessZone ("uk", "").String())
+	assert.Nil (t, GuessZone ("XX", ""))
+	assert.Nil (t, Zone ("not a zone"))
+}
@@ -0,0 +1,90 @@
+/** ****************************************************************************************************************** **
+	Best guess at someone's time zone from their country, and their state/province for the big countries
+	Countries and regions are the 2 letter codes
+
+** ****************************************************************************************************************** **/
+
+package sendwindow
+
+import (
+	"strings"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// other ways people write the countries we see the most
+var countryAliases = map[string]string {
+	"USA": "US", "UNITED STATES": "US", "UNITED STATES OF AMERICA": "US",
+	"UK": "GB", "UNITED KINGDOM": "GB", "ENGLAND": "GB",
+	"CANADA": "CA", "AUSTRALIA": "AU", "MEXICO": "MX", "BRAZIL": "BR",
+}
+
+// for countries that only have the one zone, or when we don't know the region
+var countryZones = map[string]string {
+	"US": "America/Chicago", "CA": "America/Toronto", "AU": "Australia/Sydney", "MX": "America/Mexico_City", "BR": "America/Sao_Paulo",
+	"GB": "Europe/London", "IE": "Europe/Dublin", "FR": "Europe/Paris", "DE": "Europe/Berlin", "ES": "Europe/Madrid",
+	"IT": "Europe/Rome", "NL": "Europe/Amsterdam", "BE": "Europe/Brussels", "CH": "Europe/Zurich", "AT": "Europe/Vienna",
+	"SE": "Europe/Stockholm", "NO": "Europe/Oslo", "DK": "Europe/Copenhagen", "FI": "Europe/Helsinki", "PL": "Europe/Warsaw",
+	"PT": "Europe/Lisbon", "TR": "Europe/Istanbul", "IL": "Asia/Jerusalem", "AE": "Asia/Dubai", "IN": "Asia/Kolkata",
+	"SG": "Asia/Singapore", "HK": "Asia/Hong_Kong", "CN": "Asia/Shanghai", "JP": "Asia/Tokyo", "KR": "Asia/Seoul",
+	"PH": "Asia/Manila", "NZ": "Pacific/Auckland", "ZA": "Africa/Johannesburg", "NG": "Africa/Lagos", "EG": "Africa/Cairo",
+	"AR": "America/Argentina/Buenos_Aires", "CO": "America/Bogota", "CL": "America/Santiago",
+}
+
+var regionZones = map[string]map[string]string {
+	"US": {
+		"CT": "America/New_York", "DE": "America/New_York", "DC": "America/New_York", "FL": "America/New_York", "GA": "America/New_York",
+		"IN": "America/New_York", "KY": "America/New_York", "ME": "America/New_York", "MD": "America/New_York", "MA": "America/New_York",
+		"MI": "America/New_York", "NH": "America/New_York", "NJ": "America/New_York", "NY": "America/New_York", "NC": "America/New_York",
+		"OH": "America/New_York", "PA": "America/New_York", "RI": "America/New_York", "SC": "America/New_York", "VT": "America/New_York",
+		"VA": "America/New_York", "WV": "America/New_York",
+		"AL": "America/Chicago", "AR": "America/Chicago", "IL": "America/Chicago", "IA": "America/Chicago", "KS": "America/Chicago",
+		"LA": "America/Chicago", "MN": "America/Chicago", "MS": "America/Chicago", "MO": "America/Chicago", "NE": "America/Chicago",
+		"ND": "America/Chicago", "OK": "America/Chicago", "SD": "America/Chicago", "TN": "America/Chicago", "TX": "America/Chicago",
+		"WI": "America/Chicago",
+		"CO": "America/Denver", "ID": "America/Denver", "MT": "America/Denver", "NM": "America/Denver", "UT": "America/Denver",
+		"WY": "America/Denver", "AZ": "America/Phoenix",
+		"CA": "America/Los_Angeles", "NV": "America/Los_Angeles", "OR": "America/Los_Angeles", "WA": "America/Los_Angeles",
+		"AK": "America/Anchorage", "HI": "Pacific/Honolulu",
+	},
+	"CA": {
+		"BC": "America/Vancouver", "AB": "America/Edmonton", "NT": "America/Edmonton", "SK": "America/Regina", "MB": "America/Winnipeg",
+		"ON": "America/Toronto", "QC": "America/Toronto", "NU": "America/Toronto", "NB": "America/Halifax", "NS": "America/Halifax",
+		"PE": "America/Halifax", "NL": "America/St_Johns", "YT": "America/Whitehorse",
+	},
+	"AU": {
+		"NSW": "Australia/Sydney", "ACT": "Australia/Sydney", "VIC": "Australia/Melbourne", "TAS": "Australia/Hobart",
+		"QLD": "Australia/Brisbane", "SA": "Australia/Adelaide", "NT": "Australia/Darwin", "WA": "Australia/Perth",
+	},
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// the zone for an explicit name like "America/Chicago", nil if it isn't one
+func Zone (name string) *time.Location {
+	name = strings.TrimSpace (name)
+	if len(name) == 0 { return nil }
+
+	loc, err := time.LoadLocation (name)
+	if err != nil { return nil }
+	return loc
+}
+
+// our best guess from the country and region, nil if we don't know the country
+func GuessZone (country, region string) *time.Location {
+	country = strings.ToUpper (strings.TrimSpace (country))
+	if alias, ok := countryAliases[country]; ok { country = alias }
+
+	if zone, ok := regionZones[country][strings.ToUpper (strings.TrimSpace (region))]; ok {
+		return Zone (zone)
+	}
+
+	zone, ok := countryZones[country]
+	if !ok { return nil }
+	return Zone (zone)
+}
@@ -0,0 +1,115 @@
+/** ****************************************************************************************************************** **
+	knows how to send emails via the amazon SES v2 api
+
+** ****************************************************************************************************************** **/
+
+package ses
+
+import (
+	"coldbrew/tools"
+	"coldbrew/tools/mailer"
+
+	"github.com/pkg/errors"
+	json "github.com/json-iterator/go"
+
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// sends through a single SES identity
+type Sender struct {
+	AccessKey, SecretKey, Region string
+	ConfigurationSet string // optional, this is what publishes the events to sns
+
+	Endpoint string // optional, for pointing at something other than aws
+}
+
+type sesTag struct {
+	Name string
+	Value string
+}
+
+type sesSendRequest struct {
+	FromEmailAddress string
+	Destination struct {
+		ToAddresses []string
+	}
+	ReplyToAddresses []string `json:",omitempty"`
+	Content struct {
+		Raw struct {
+			Data []byte // encoded as base64 which is what aws wants
+		}
+	}
+	ConfigurationSetName string `json:",omitempty"`
+	EmailTags []sesTag `json:",omitempty"`
+}
+
+type sesSendResponse struct {
+	MessageId string
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func (this *Sender) endpoint () string {
+	if len(this.Endpoint) > 0 { return this.Endpoint }
+	return fmt.Sprintf ("https://email.%s.amazonaws.com", this.Region)
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// sends the email as a raw message, so our headers make it through as-is
+func (this *Sender) Send (ctx context.Context, msg *mailer.Message) (string, error) {
+	raw, err := msg.MIME (mailer.NewMessageId (msg.FromEmail)) // ses replaces this message id with its own
+	if err != nil { return "", err }
+
+	in := &sesSendRequest {
+		FromEmailAddress: msg.FromEmail,
+		ConfigurationSetName: this.ConfigurationSet,
+	}
+	in.Destination.ToAddresses = []string { msg.To }
+	in.Content.Raw.Data = raw
+	if len(msg.ReplyEmail) > 0 {
+		in.ReplyToAddresses = []string { msg.ReplyEmail }
+	}
+	if len(msg.Category) > 0 {
+		in.EmailTags = append (in.EmailTags, sesTag { Name: "category", Value: msg.Category })
+	}
+
+	body, err := json.Marshal (in)
+	if err != nil { return "", errors.WithStack (err) }
+
+	link := this.endpoint() + "/v2/email/outbound-emails"
+	req, err := http.NewRequestWithContext (ctx, http.MethodPost, link, bytes.NewReader (body))
+	if err != nil { return "", errors.Wrap (err, link) }
+
+	req.Header.Set ("Content-Type", "application/json")
+	signV4 (req, body, this.AccessKey, this.SecretKey, this.Region, "ses", time.Now())
+
+	resp, err := http.DefaultClient.Do (req)
+	if err != nil { return "", errors.WithStack (err) }
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll (resp.Body)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", errors.WithStack (&tools.HttpError { StatusCode: resp.StatusCode, Body: string(respBody),
+			Message: fmt.Sprintf ("ERROR %d returned from %s : %s : %s", resp.StatusCode, link, msg.To, string(respBody)) })
+	}
+
+	out := &sesSendResponse{}
+	if err := json.Unmarshal (respBody, out); err != nil { return "", errors.Wrapf (err, " :: %s", string(respBody)) }
+
+	return out.MessageId, nil
+}
@@ -0,0 +1,44 @@
+
+package ses
+
+import (
+	"coldbrew/tools"
+	"coldbrew/tools/mailer"
+
+	"github.com/stretchr/testify/assert"
+	json "github.com/json-iterator/go"
+
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQASESSend (t *testing.T) {
+	var got sesSendRequest
+	var auth string
+
+	server := httptest.NewServer (http.HandlerFunc (func (w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get ("Authorization")
+		body, _ := io.ReadAll (r.Body)
+		json.Unmarshal (body, &got)
+		w.Write ([]byte(`{"MessageId":"0100-sent"}`))
+	}))
+	defer server.Close()
+
+	sender := &Sender { AccessKey: "AKID", SecretKey: "secret", Region: "us-east-1", ConfigurationSet: "events", Endpoint: server.URL }
+
+	messageId, err := sender.Send (context.Background(), &mailer.Message {
+		To: "to@example.com", FromEmail: "from@example.com", Subject: "Hello", Text: "plain body", Category: "warmup",
+	})
+	tools.TestingStackTrace (t, err)
+
+	assert.Equal (t, "0100-sent", messageId)
+	assert.True (t, strings.HasPrefix (auth, "AWS4-HMAC-SHA256 Credential=AKID/"), auth)
+	assert.Contains (t, auth, "/us-east-1/ses/aws4_request")
+	assert.Equal (t, "events", got.ConfigurationSetName)
+	assert.Equal (t, []string { "to@example.com" }, got.Destination.ToAddresses)
+	assert.Contains (t, string(got.Content.Raw.Data), "Subject: Hello")
+}
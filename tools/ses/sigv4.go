@@ -0,0 +1,136 @@
+/** ****************************************************************************************************************** **
+	AWS Signature Version 4, just enough of it to sign our calls to SES
+	https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_aws-signing.html
+
+** ****************************************************************************************************************** **/
+
+package ses
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+const sigv4Algorithm = "AWS4-HMAC-SHA256"
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func hmacSHA256 (key []byte, data string) []byte {
+	h := hmac.New (sha256.New, key)
+	h.Write ([]byte(data))
+	return h.Sum (nil)
+}
+
+func sha256Hex (data []byte) string {
+	h := sha256.Sum256 (data)
+	return hex.EncodeToString (h[:])
+}
+
+// aws wants everything escaped except the unreserved characters, which is a little stricter than go's version
+func sigv4Escape (in string) string {
+	return strings.ReplaceAll (url.QueryEscape (in), "+", "%20")
+}
+
+func canonicalQuery (query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append (keys, key)
+	}
+	sort.Strings (keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		vals := append ([]string{}, query[key]...)
+		sort.Strings (vals)
+		for _, val := range vals {
+			parts = append (parts, sigv4Escape (key) + "=" + sigv4Escape (val))
+		}
+	}
+	return strings.Join (parts, "&")
+}
+
+// returns the canonical headers block along with the list of signed header names
+func canonicalHeaders (req *http.Request) (string, string) {
+	headers := map[string]string { "host": req.URL.Host }
+	for key, vals := range req.Header {
+		trimmed := make([]string, 0, len(vals))
+		for _, val := range vals {
+			trimmed = append (trimmed, strings.Join (strings.Fields (val), " "))
+		}
+		headers[strings.ToLower (key)] = strings.Join (trimmed, ",")
+	}
+
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append (keys, key)
+	}
+	sort.Strings (keys)
+
+	var block strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf (&block, "%s:%s\n", key, headers[key])
+	}
+
+	return block.String(), strings.Join (keys, ";")
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// signs the request in place, setting the X-Amz-Date and Authorization headers
+// body needs to be the exact bytes being sent
+func signV4 (req *http.Request, body []byte, accessKey, secretKey, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format ("20060102T150405Z")
+	shortDate := now.Format ("20060102")
+
+	req.Header.Set ("X-Amz-Date", amzDate)
+	req.Header.Del ("Authorization") // in case this is a retry
+
+	uri := req.URL.EscapedPath()
+	if len(uri) == 0 { uri = "/" }
+
+	headers, signedHeaders := canonicalHeaders (req)
+
+	canonicalRequest := strings.Join ([]string {
+		req.Method,
+		uri,
+		canonicalQuery (req.URL.Query()),
+		headers,
+		signedHeaders,
+		sha256Hex (body),
+	}, "\n")
+
+	scope := strings.Join ([]string { shortDate, region, service, "aws4_request" }, "/")
+
+	stringToSign := strings.Join ([]string {
+		sigv4Algorithm,
+		amzDate,
+		scope,
+		sha256Hex ([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256 ([]byte("AWS4" + secretKey), shortDate)
+	key = hmacSHA256 (key, region)
+	key = hmacSHA256 (key, service)
+	key = hmacSHA256 (key, "aws4_request")
+
+	signature := hex.EncodeToString (hmacSHA256 (key, stringToSign))
+
+	req.Header.Set ("Authorization", fmt.Sprintf ("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigv4Algorithm, accessKey, scope, signedHeaders, signature))
+}
@@ -0,0 +1,23 @@
+
+package ses
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"net/http"
+	"testing"
+	"time"
+)
+
+// the get-vanilla case from the aws sigv4 test suite
+func TestQASignV4 (t *testing.T) {
+	req, _ := http.NewRequest (http.MethodGet, "https://example.amazonaws.com/", nil)
+
+	now, _ := time.Parse ("20060102T150405Z", "20150830T123600Z")
+	signV4 (req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+	assert.Equal (t, "20150830T123600Z", req.Header.Get ("X-Amz-Date"))
+	assert.Equal (t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get ("Authorization"))
+}
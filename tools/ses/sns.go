@@ -0,0 +1,276 @@
+/** ****************************************************************************************************************** **
+	SNS notifications, which is how SES tells us about what happened to the emails we sent
+	https://docs.aws.amazon.com/sns/latest/dg/sns-verify-signature-of-message.html
+
+** ****************************************************************************************************************** **/
+
+package ses
+
+import (
+	"github.com/pkg/errors"
+
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+const (
+	SNSType_notification		= "Notification"
+	SNSType_subscribe			= "SubscriptionConfirmation"
+	SNSType_unsubscribe			= "UnsubscribeConfirmation"
+)
+
+// sns only ever sends us urls on these hosts, anything else is someone pretending
+var snsHost = regexp.MustCompile (`^sns\.[a-z0-9\-]+\.amazonaws\.com(\.cn)?$`)
+
+// signing certs don't change often, no reason to download them for every webhook
+var snsCerts = struct {
+	sync.RWMutex
+	list map[string]*x509.Certificate
+} { list: make(map[string]*x509.Certificate) }
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// the envelope sns wraps everything in
+type SNSMessage struct {
+	Type, MessageId, TopicArn, Subject, Message, Timestamp string
+	Token, SubscribeURL string
+	SignatureVersion, Signature, SigningCertURL string
+}
+
+// SES event publishing uses eventType, the older identity notifications use notificationType
+type SESEvent struct {
+	EventType, NotificationType string
+	Mail struct {
+		MessageId string
+		Timestamp time.Time
+		Destination []string
+	}
+	Bounce struct {
+		Timestamp time.Time
+		BounceType, BounceSubType string
+		BouncedRecipients []struct {
+			EmailAddress, DiagnosticCode string
+		}
+	}
+	Complaint struct {
+		Timestamp time.Time
+		ComplainedRecipients []struct {
+			EmailAddress string
+		}
+	}
+	Delivery, Reject struct {
+		Timestamp time.Time
+		Reason string
+	}
+	Open struct {
+		Timestamp time.Time
+		IpAddress, UserAgent string
+	}
+	Click struct {
+		Timestamp time.Time
+		Link, IpAddress, UserAgent string
+	}
+}
+
+// what happened, regardless of which style of notification this was
+func (this *SESEvent) Type () string {
+	if len(this.EventType) > 0 { return this.EventType }
+	return this.NotificationType
+}
+
+// when this happened, falls back to when we sent it
+func (this *SESEvent) Time () time.Time {
+	for _, t := range []time.Time { this.Delivery.Timestamp, this.Bounce.Timestamp, this.Open.Timestamp, this.Click.Timestamp, this.Complaint.Timestamp } {
+		if t.IsZero() == false { return t }
+	}
+	return this.Mail.Timestamp
+}
+
+// why it failed for this recipient, if it failed
+func (this *SESEvent) Reason (recipient string) string {
+	for _, r := range this.Bounce.BouncedRecipients {
+		if r.EmailAddress == recipient { return r.DiagnosticCode }
+	}
+	return this.Reject.Reason
+}
+
+func (this *SESEvent) Ip () string {
+	if len(this.Click.IpAddress) > 0 { return this.Click.IpAddress }
+	return this.Open.IpAddress
+}
+
+func (this *SESEvent) UserAgent () string {
+	if len(this.Click.UserAgent) > 0 { return this.Click.UserAgent }
+	return this.Open.UserAgent
+}
+
+// the recipients this event is about, bounces and complaints can be a subset of who we sent to
+func (this *SESEvent) Recipients () []string {
+	ret := make([]string, 0, len(this.Mail.Destination))
+
+	switch this.Type() {
+	case "Bounce":
+		for _, r := range this.Bounce.BouncedRecipients {
+			ret = append (ret, r.EmailAddress)
+		}
+	case "Complaint":
+		for _, r := range this.Complaint.ComplainedRecipients {
+			ret = append (ret, r.EmailAddress)
+		}
+	}
+
+	if len(ret) == 0 {
+		ret = append (ret, this.Mail.Destination...)
+	}
+	return ret
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// makes sure a url that came in the message actually points at sns
+func snsURL (link string) (*url.URL, error) {
+	u, err := url.Parse (link)
+	if err != nil { return nil, errors.WithStack (err) }
+
+	if u.Scheme != "https" || snsHost.MatchString (u.Hostname()) == false {
+		return nil, errors.Errorf ("sns url isn't from aws : %s", link)
+	}
+	return u, nil
+}
+
+// the string that was signed, the fields and their order depend on the type
+func (this *SNSMessage) stringToSign () string {
+	var b strings.Builder
+	add := func (key, val string) {
+		b.WriteString (key + "\n" + val + "\n")
+	}
+
+	add ("Message", this.Message)
+	add ("MessageId", this.MessageId)
+
+	if this.Type == SNSType_notification {
+		if len(this.Subject) > 0 { add ("Subject", this.Subject) }
+	} else {
+		add ("SubscribeURL", this.SubscribeURL)
+	}
+
+	add ("Timestamp", this.Timestamp)
+
+	if this.Type != SNSType_notification {
+		add ("Token", this.Token)
+	}
+
+	add ("TopicArn", this.TopicArn)
+	add ("Type", this.Type)
+
+	return b.String()
+}
+
+func (this *SNSMessage) cert (ctx context.Context) (*x509.Certificate, error) {
+	u, err := snsURL (this.SigningCertURL)
+	if err != nil { return nil, err }
+
+	snsCerts.RLock()
+	cert, ok := snsCerts.list[u.String()]
+	snsCerts.RUnlock()
+	if ok { return cert, nil }
+
+	req, err := http.NewRequestWithContext (ctx, http.MethodGet, u.String(), nil)
+	if err != nil { return nil, errors.WithStack (err) }
+
+	resp, err := http.DefaultClient.Do (req)
+	if err != nil { return nil, errors.WithStack (err) }
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf ("sns signing cert returned %d : %s", resp.StatusCode, u.String())
+	}
+
+	body, err := io.ReadAll (resp.Body)
+	if err != nil { return nil, errors.WithStack (err) }
+
+	block, _ := pem.Decode (body)
+	if block == nil { return nil, errors.Errorf ("sns signing cert isn't pem : %s", u.String()) }
+
+	cert, err = x509.ParseCertificate (block.Bytes)
+	if err != nil { return nil, errors.Wrap (err, u.String()) }
+
+	snsCerts.Lock()
+	snsCerts.list[u.String()] = cert
+	snsCerts.Unlock()
+
+	return cert, nil
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// makes sure this message was actually signed by sns
+func (this *SNSMessage) Verify (ctx context.Context) error {
+	cert, err := this.cert (ctx)
+	if err != nil { return err }
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if ok == false { return errors.Errorf ("sns signing cert isn't rsa : %s", this.SigningCertURL) }
+
+	sig, err := base64.StdEncoding.DecodeString (this.Signature)
+	if err != nil { return errors.WithStack (err) }
+
+	var hash crypto.Hash
+	var digest []byte
+
+	switch this.SignatureVersion {
+	case "1":
+		h := sha1.Sum ([]byte(this.stringToSign()))
+		hash, digest = crypto.SHA1, h[:]
+	case "2":
+		h := sha256.Sum256 ([]byte(this.stringToSign()))
+		hash, digest = crypto.SHA256, h[:]
+	default:
+		return errors.Errorf ("unknown sns signature version : %s", this.SignatureVersion)
+	}
+
+	return errors.Wrapf (rsa.VerifyPKCS1v15 (pub, hash, digest, sig), "sns signature : %s", this.MessageId)
+}
+
+// confirms the subscription so sns starts sending us the notifications
+func (this *SNSMessage) Confirm (ctx context.Context) error {
+	u, err := snsURL (this.SubscribeURL)
+	if err != nil { return err }
+
+	req, err := http.NewRequestWithContext (ctx, http.MethodGet, u.String(), nil)
+	if err != nil { return errors.WithStack (err) }
+
+	resp, err := http.DefaultClient.Do (req)
+	if err != nil { return errors.WithStack (err) }
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll (resp.Body)
+		return errors.Errorf ("sns subscription confirm returned %d : %s : %s", resp.StatusCode, this.TopicArn, string(body))
+	}
+
+	return nil
+}
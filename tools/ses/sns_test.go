@@ -0,0 +1,97 @@
+
+package ses
+
+import (
+	"coldbrew/tools"
+
+	"github.com/stretchr/testify/assert"
+	json "github.com/json-iterator/go"
+
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signs the message with a throw away cert that we pre-load into the cache
+func testSignSNS (t *testing.T, msg *SNSMessage) {
+	key, err := rsa.GenerateKey (rand.Reader, 2048)
+	tools.TestingStackTrace (t, err)
+
+	tmpl := &x509.Certificate {
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name { CommonName: "sns.amazonaws.com" },
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate (rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	tools.TestingStackTrace (t, err)
+
+	cert, err := x509.ParseCertificate (der)
+	tools.TestingStackTrace (t, err)
+
+	msg.SigningCertURL = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-test.pem"
+	msg.SignatureVersion = "2"
+
+	snsCerts.Lock()
+	snsCerts.list[msg.SigningCertURL] = cert
+	snsCerts.Unlock()
+
+	digest := sha256.Sum256 ([]byte(msg.stringToSign()))
+	sig, err := rsa.SignPKCS1v15 (rand.Reader, key, crypto.SHA256, digest[:])
+	tools.TestingStackTrace (t, err)
+
+	msg.Signature = base64.StdEncoding.EncodeToString (sig)
+}
+
+func TestQASNSVerify (t *testing.T) {
+	msg := &SNSMessage {
+		Type: SNSType_notification,
+		MessageId: "abc",
+		TopicArn: "arn:aws:sns:us-east-1:123456789012:ses-events",
+		Message: `{"eventType":"Bounce","mail":{"messageId":"0100-abc","destination":["a@example.com","b@example.com"]},` +
+			`"bounce":{"bounceType":"Permanent","bouncedRecipients":[{"emailAddress":"b@example.com"}]}}`,
+		Timestamp: "2026-10-18T12:00:00.000Z",
+	}
+	testSignSNS (t, msg)
+
+	ctx, cancel := context.WithTimeout (context.Background(), time.Second * 10)
+	defer cancel()
+
+	tools.TestingStackTrace (t, msg.Verify (ctx))
+
+	// any change to the message should fail
+	msg.Message = strings.Replace (msg.Message, "Permanent", "Transient", 1)
+	assert.Error (t, msg.Verify (ctx))
+
+	// and we never trust a cert from somewhere other than sns
+	msg.SigningCertURL = "https://example.com/cert.pem"
+	assert.Error (t, msg.Verify (ctx))
+}
+
+func TestQASESEvent (t *testing.T) {
+	event := &SESEvent{}
+	err := json.Unmarshal ([]byte(`{"eventType":"Bounce","mail":{"messageId":"0100-abc","destination":["a@example.com","b@example.com"]},` +
+			`"bounce":{"bounceType":"Permanent","bouncedRecipients":[{"emailAddress":"b@example.com"}]}}`), event)
+	tools.TestingStackTrace (t, err)
+
+	assert.Equal (t, "Bounce", event.Type())
+	assert.Equal (t, "0100-abc", event.Mail.MessageId)
+	assert.Equal (t, []string { "b@example.com" }, event.Recipients())
+
+	// older style notifications
+	event = &SESEvent{}
+	err = json.Unmarshal ([]byte(`{"notificationType":"Delivery","mail":{"messageId":"0100-def","destination":["a@example.com"]}}`), event)
+	tools.TestingStackTrace (t, err)
+
+	assert.Equal (t, "Delivery", event.Type())
+	assert.Equal (t, []string { "a@example.com" }, event.Recipients())
+}
@@ -0,0 +1,126 @@
+/** ****************************************************************************************************************** **
+	knows how to send emails over plain smtp, for warming mailmen on our own postfix boxes
+
+** ****************************************************************************************************************** **/
+
+package smtp
+
+import (
+	"coldbrew/tools/mailer"
+
+	"github.com/pkg/errors"
+
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+const DefaultPort = 587 // submission port
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// sends through an smtp server using STARTTLS when it's offered and AUTH PLAIN when we have a username
+type Sender struct {
+	Host string
+	Port int
+	Username, Password string
+
+	TLSConfig *tls.Config // optional, defaults to verifying the host
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func (this *Sender) addr () string {
+	port := this.Port
+	if port == 0 { port = DefaultPort }
+	return net.JoinHostPort (this.Host, fmt.Sprint(port))
+}
+
+func (this *Sender) tlsConfig () *tls.Config {
+	if this.TLSConfig != nil { return this.TLSConfig }
+	return &tls.Config { ServerName: this.Host }
+}
+
+// connects and gets us to the point where we're ready to send mail
+func (this *Sender) client (ctx context.Context) (*smtp.Client, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext (ctx, "tcp", this.addr())
+	if err != nil { return nil, errors.Wrapf (err, "smtp dial : %s", this.addr()) }
+
+	// the smtp package doesn't know about contexts, so the deadline has to live on the connection
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline (deadline)
+	}
+
+	c, err := smtp.NewClient (conn, this.Host)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf (err, "smtp client : %s", this.addr())
+	}
+
+	hostname, _ := os.Hostname()
+	if len(hostname) == 0 { hostname = "localhost" }
+
+	if err := c.Hello (hostname); err != nil {
+		c.Close()
+		return nil, errors.Wrapf (err, "smtp ehlo : %s", this.addr())
+	}
+
+	if ok, _ := c.Extension ("STARTTLS"); ok {
+		if err := c.StartTLS (this.tlsConfig()); err != nil {
+			c.Close()
+			return nil, errors.Wrapf (err, "smtp starttls : %s", this.addr())
+		}
+	}
+
+	if len(this.Username) > 0 {
+		// PlainAuth refuses to send the password unless we're on tls or talking to localhost
+		if err := c.Auth (smtp.PlainAuth ("", this.Username, this.Password, this.Host)); err != nil {
+			c.Close()
+			return nil, errors.Wrapf (err, "smtp auth : %s : %s", this.addr(), this.Username)
+		}
+	}
+
+	return c, nil
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// sends the email, the message id we return is the one we put in the Message-ID header
+func (this *Sender) Send (ctx context.Context, msg *mailer.Message) (string, error) {
+	messageId := mailer.NewMessageId (msg.FromEmail)
+
+	body, err := msg.MIME (messageId)
+	if err != nil { return "", err }
+
+	c, err := this.client (ctx)
+	if err != nil { return "", err }
+	defer c.Close()
+
+	if err := c.Mail (msg.FromEmail); err != nil { return "", errors.Wrapf (err, "smtp mail from : %s", msg.FromEmail) }
+	if err := c.Rcpt (msg.To); err != nil { return "", errors.Wrapf (err, "smtp rcpt to : %s", msg.To) }
+
+	w, err := c.Data()
+	if err != nil { return "", errors.Wrap (err, "smtp data") }
+
+	if _, err := w.Write (body); err != nil { return "", errors.Wrap (err, "smtp data write") }
+	if err := w.Close(); err != nil { return "", errors.Wrapf (err, "smtp data close : %s", msg.To) }
+
+	// the message has been accepted at this point, so a failed quit isn't worth failing the send over
+	c.Quit()
+
+	return messageId, nil
+}
@@ -0,0 +1,148 @@
+
+package smtp
+
+import (
+	"coldbrew/tools"
+	"coldbrew/tools/mailer"
+
+	"github.com/stretchr/testify/assert"
+
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bare minimum smtp server, just enough to accept a single message
+type testServer struct {
+	listener net.Listener
+	auth, from, to, data string
+	done chan bool
+}
+
+func newTestServer (t *testing.T) *testServer {
+	l, err := net.Listen ("tcp", "127.0.0.1:0")
+	tools.TestingStackTrace (t, err)
+
+	this := &testServer { listener: l, done: make(chan bool) }
+	go this.serve()
+	return this
+}
+
+func (this *testServer) port () int {
+	return this.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (this *testServer) serve () {
+	defer close(this.done)
+
+	conn, err := this.listener.Accept()
+	if err != nil { return }
+	defer conn.Close()
+
+	tp := textproto.NewConn (conn)
+	tp.PrintfLine ("220 localhost ESMTP test")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil { return }
+
+		cmd := strings.ToUpper (strings.SplitN (line, " ", 2)[0])
+		switch cmd {
+		case "EHLO":
+			tp.PrintfLine ("250-localhost")
+			tp.PrintfLine ("250 AUTH PLAIN")
+		case "AUTH":
+			creds, _ := base64.StdEncoding.DecodeString (strings.TrimPrefix (line, "AUTH PLAIN "))
+			this.auth = string(creds)
+			tp.PrintfLine ("235 ok")
+		case "MAIL":
+			this.from = line
+			tp.PrintfLine ("250 ok")
+		case "RCPT":
+			this.to = line
+			tp.PrintfLine ("250 ok")
+		case "DATA":
+			tp.PrintfLine ("354 go ahead")
+			lines, _ := tp.ReadDotLines()
+			this.data = strings.Join (lines, "\n")
+			tp.PrintfLine ("250 queued")
+		case "QUIT":
+			tp.PrintfLine ("221 bye")
+			return
+		default:
+			tp.PrintfLine ("502 not here")
+		}
+	}
+}
+
+func TestQASmtpSend (t *testing.T) {
+	server := newTestServer (t)
+	defer server.listener.Close()
+
+	sender := &Sender {
+		Host: "127.0.0.1",
+		Port: server.port(),
+		Username: "user",
+		Password: "secret",
+	}
+
+	ctx, cancel := context.WithTimeout (context.Background(), time.Second * 10)
+	defer cancel()
+
+	messageId, err := sender.Send (ctx, &mailer.Message {
+		To: "to@example.com",
+		FromEmail: "from@example.com",
+		FromName: "From Person",
+		ReplyEmail: "reply@example.com",
+		Subject: "Hello there",
+		Text: "plain body",
+		Html: "<p>html body</p>",
+	})
+	tools.TestingStackTrace (t, err)
+
+	<-server.done
+
+	assert.True (t, strings.HasSuffix (messageId, "@example.com"), messageId)
+	assert.Equal (t, "\x00user\x00secret", server.auth)
+	assert.Equal (t, "MAIL FROM:<from@example.com>", strings.Split (server.from, " BODY")[0])
+	assert.Equal (t, "RCPT TO:<to@example.com>", server.to)
+
+	// make sure the message itself came through in one piece
+	msg, err := textproto.NewReader (bufio.NewReader (strings.NewReader (server.data + "\n"))).ReadMIMEHeader()
+	tools.TestingStackTrace (t, err)
+
+	assert.Equal (t, "<" + messageId + ">", msg.Get ("Message-Id"))
+	assert.Equal (t, "Hello there", msg.Get ("Subject"))
+	assert.Equal (t, `"From Person" <from@example.com>`, msg.Get ("From"))
+	assert.Equal (t, "<reply@example.com>", msg.Get ("Reply-To"))
+	assert.Contains (t, msg.Get ("Content-Type"), "multipart/alternative")
+	assert.Contains (t, server.data, "plain body")
+	assert.Contains (t, server.data, "<p>html body</p>")
+}
+
+func TestQASmtpSendRejected (t *testing.T) {
+	l, err := net.Listen ("tcp", "127.0.0.1:0")
+	tools.TestingStackTrace (t, err)
+	defer l.Close()
+
+	// a server that turns us away right away
+	go func() {
+		conn, err := l.Accept()
+		if err != nil { return }
+		defer conn.Close()
+		textproto.NewConn (conn).PrintfLine ("554 go away")
+	}()
+
+	sender := &Sender { Host: "127.0.0.1", Port: l.Addr().(*net.TCPAddr).Port }
+
+	ctx, cancel := context.WithTimeout (context.Background(), time.Second * 10)
+	defer cancel()
+
+	_, err = sender.Send (ctx, &mailer.Message { To: "to@example.com", FromEmail: "from@example.com", Text: "body" })
+	assert.Error (t, err)
+}
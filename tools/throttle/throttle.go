@@ -0,0 +1,138 @@
+/** ****************************************************************************************************************** **
+	Decides how fast a mailman should be sending. Volume ramps up by the day, steps back down when the
+	bounce/spam/block rates get too high, and pauses the mailman outright on a complaint spike
+
+** ****************************************************************************************************************** **/
+
+package throttle
+
+import (
+	"github.com/pkg/errors"
+
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// from this day on, send at most this many a day
+type Step struct {
+	Day, MaxSends int
+}
+
+// rates are a fraction of the sends, 0.02 is 2%. zero turns that check off
+type Rates struct {
+	Bounce, Spam, Block float64
+}
+
+type Policy struct {
+	Ramp []Step // sorted by day, the first one should be day 0
+	StepDown Rates // going over any of these drops back a step on the ramp, one step for each
+	Pause Rates // going over any of these pauses the mailman until someone looks at it
+	MinSample int // don't judge the rates until we've sent at least this many in the window
+	Window int // days of events the rates come from
+}
+
+// what happened for a mailman recently
+type Stats struct {
+	Day int // days since the mailman's first send
+	Sent, Bounced, Blocked, Spam int // in the window
+}
+
+type Decision struct {
+	PerDay int
+	Interval time.Duration // time between sends to hit the daily volume
+	Pause bool
+	Reason string
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+func (this Stats) rate (cnt int) float64 {
+	if this.Sent < 1 { return 0 }
+	return float64(cnt) / float64(this.Sent)
+}
+
+// the rates that are over the limits, named for the reason
+func (this Rates) over (stats Stats) []string {
+	ret := make([]string, 0, 3)
+	check := func (name string, cnt int, limit float64) {
+		if limit > 0 && stats.rate (cnt) > limit {
+			ret = append (ret, fmt.Sprintf ("%s rate %.2f%% over %.2f%%", name, stats.rate (cnt) * 100, limit * 100))
+		}
+	}
+
+	check ("spam", stats.Spam, this.Spam)
+	check ("bounce", stats.Bounced, this.Bounce)
+	check ("block", stats.Blocked, this.Block)
+	return ret
+}
+
+// where on the ramp we are for this day
+func (this *Policy) step (day int) int {
+	idx := 0
+	for i, step := range this.Ramp {
+		if step.Day <= day { idx = i }
+	}
+	return idx
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// a reasonable place to start, a new mailman goes from ~50 a day to one a minute over about 6 weeks
+func Default () Policy {
+	return Policy {
+		Ramp: []Step { { 0, 50 }, { 5, 100 }, { 11, 240 }, { 20, 480 }, { 30, 720 }, { 45, 1440 } },
+		StepDown: Rates { Bounce: 0.05, Spam: 0.001, Block: 0.02 },
+		Pause: Rates { Spam: 0.005 },
+		MinSample: 50,
+		Window: 7,
+	}
+}
+
+func (this *Policy) Valid () error {
+	if len(this.Ramp) == 0 { return errors.Errorf ("throttle ramp needs at least one step") }
+
+	sort.Slice (this.Ramp, func (i, j int) bool { return this.Ramp[i].Day < this.Ramp[j].Day })
+
+	for _, step := range this.Ramp {
+		if step.Day < 0 || step.MaxSends < 1 { return errors.Errorf ("throttle step is invalid : day %d : %d sends", step.Day, step.MaxSends) }
+	}
+	if this.Window < 1 { this.Window = 7 }
+	return nil
+}
+
+// the rate this mailman should be sending at, and why
+func (this *Policy) Decide (stats Stats) Decision {
+	idx := this.step (stats.Day)
+	reasons := []string { fmt.Sprintf ("day %d of the ramp", stats.Day) }
+
+	if stats.Sent >= this.MinSample {
+		if over := this.Pause.over (stats); len(over) > 0 {
+			return Decision { Pause: true, Reason: "paused : " + strings.Join (over, ", ") }
+		}
+
+		over := this.StepDown.over (stats)
+		if len(over) > 0 {
+			idx = max(idx - len(over), 0)
+			reasons = append (reasons, over...)
+		}
+	} else {
+		reasons = append (reasons, fmt.Sprintf ("only %d sends, not enough to judge", stats.Sent))
+	}
+
+	perDay := this.Ramp[idx].MaxSends
+	return Decision {
+		PerDay: perDay,
+		Interval: (time.Hour * 24) / time.Duration(perDay),
+		Reason: strings.Join (reasons, ", "),
+	}
+}
@@ -0,0 +1,61 @@
+package throttle
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"testing"
+	"time"
+)
+
+func TestQADecide (t *testing.T) {
+	policy := Default()
+	require.NoError (t, policy.Valid())
+
+	// brand new, not enough sends to judge anything
+	d := policy.Decide (Stats { Day: 0, Sent: 10, Spam: 5 })
+	assert.False (t, d.Pause)
+	assert.Equal (t, 50, d.PerDay)
+	assert.Equal (t, time.Hour * 24 / 50, d.Interval)
+
+	// clean and far along the ramp
+	d = policy.Decide (Stats { Day: 60, Sent: 5000, Bounced: 20 })
+	assert.Equal (t, 1440, d.PerDay)
+	assert.Equal (t, time.Minute, d.Interval)
+
+	// bounces step back one
+	d = policy.Decide (Stats { Day: 60, Sent: 1000, Bounced: 80 })
+	assert.Equal (t, 720, d.PerDay)
+	assert.Contains (t, d.Reason, "bounce")
+
+	// a provider blocking us steps back on its own
+	d = policy.Decide (Stats { Day: 60, Sent: 1000, Blocked: 30 })
+	assert.Equal (t, 720, d.PerDay)
+	assert.Contains (t, d.Reason, "block")
+
+	// bounces and blocks step back two
+	d = policy.Decide (Stats { Day: 60, Sent: 1000, Bounced: 80, Blocked: 30 })
+	assert.Equal (t, 480, d.PerDay)
+
+	// can't go below the first step
+	d = policy.Decide (Stats { Day: 1, Sent: 100, Bounced: 50 })
+	assert.Equal (t, 50, d.PerDay)
+
+	// complaint spike
+	d = policy.Decide (Stats { Day: 60, Sent: 1000, Spam: 10 })
+	assert.True (t, d.Pause)
+	assert.Contains (t, d.Reason, "spam")
+}
+
+func TestQAPolicyValid (t *testing.T) {
+	policy := Policy { Ramp: []Step { { 10, 200 }, { 0, 20 } } }
+	require.NoError (t, policy.Valid())
+	assert.Equal (t, 0, policy.Ramp[0].Day) // sorted
+	assert.Equal (t, 7, policy.Window)
+
+	assert.Equal (t, 20, policy.Decide (Stats { Day: 3 }).PerDay)
+	assert.Equal (t, 200, policy.Decide (Stats { Day: 10 }).PerDay)
+
+	assert.Error (t, (&Policy{}).Valid())
+	assert.Error (t, (&Policy { Ramp: []Step { { 0, 0 } } }).Valid())
+}
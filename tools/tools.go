@@ -15,9 +15,6 @@ import (
 	"math"
 	json "github.com/json-iterator/go"
 	"time"
-	"os"
-	"bytes"
-	"text/template"
 )
 
   //-----------------------------------------------------------------------------------------------------------------------//
@@ -56,7 +53,12 @@ func (this TimeDuration) Redis () string {
 }
 
 func (this TimeDuration) Context (threadName string) (context.Context, func()) {
-	ctx, cancel := context.WithTimeout(context.Background(), this.Duration())
+	return this.ContextFrom (context.Background(), threadName)
+}
+
+// same as Context, but cancelled when the parent is too
+func (this TimeDuration) ContextFrom (parent context.Context, threadName string) (context.Context, func()) {
+	ctx, cancel := context.WithTimeout(parent, this.Duration())
 
 	if len(threadName) > 0 {
 		ctx = context.WithValue(ctx, logging.ThreadName, threadName) // add this to our context
@@ -95,26 +97,3 @@ func (this TimeDuration) CountDown () string {
 	return "now"
 }
 
-func GenText (dir, filename string, data interface{}) (string, error) {
-	loc := os.Getenv ("TEMPLATES")
-	if len(loc) == 0 { return "", errors.Errorf ("environment variable TEMPLATES not set") }
-
-	if len(dir) > 0 {
-		loc += "/" + dir
-	}
-	loc += "/" + filename // final location
-
-	txt, err := template.New(filename).ParseFiles(loc) // get the text version
-	if err != nil { return "", errors.Wrapf (err, "template not found : %s", loc) }
-
-	txtBuf := new(bytes.Buffer)
-
-	err = txt.Execute (txtBuf, data)
-	if err != nil { 
-		str, _ := json.Marshal (data)
-		return "", errors.Wrapf (err, "%s :: %s\n", filename, str) 
-	}
-
-	return txtBuf.String(), nil 
-}
-
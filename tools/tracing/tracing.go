@@ -0,0 +1,144 @@
+/** ****************************************************************************************************************** **
+	OpenTelemetry tracing. Spans go to an OTLP collector, or to stdout when testing locally
+	Until Init is called everything here is a no-op, so it's safe to start spans from anywhere
+
+** ****************************************************************************************************************** **/
+
+package tracing
+
+import (
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"context"
+	"os"
+	"strings"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type Exporter string
+const (
+	Exporter_none	= Exporter("")
+	Exporter_otlp	= Exporter("otlp") // http to a collector, defaults to localhost:4318
+	Exporter_stdout	= Exporter("stdout")
+)
+
+const tracerName = "coldbrew"
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+type CFG struct {
+	Exporter Exporter
+	Endpoint string // host:port of the collector, otherwise the OTEL_EXPORTER_OTLP_ENDPOINT env is used
+	Insecure bool // for a local collector without tls
+	SampleRatio float64 // 0 means sample everything
+	Internal []string // hosts of our own services, the only ones we send our trace headers to
+}
+
+// set from the config, anywhere else is a third party that has no business seeing our trace ids
+var internalHosts = make(map[string]bool)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// sets up the global tracer, the callback flushes anything we haven't exported yet
+func Init (ctx context.Context, cfg CFG, serviceName string) (func (context.Context) error, error) {
+	// we always pass along traces we're given, even if we're not recording our own
+	otel.SetTextMapPropagator (propagation.NewCompositeTextMapPropagator (propagation.TraceContext{}, propagation.Baggage{}))
+
+	for _, host := range cfg.Internal {
+		internalHosts[strings.ToLower (host)] = true
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch cfg.Exporter {
+	case Exporter_none:
+		return func (context.Context) error { return nil }, nil // tracing is off
+
+	case Exporter_otlp:
+		opts := make([]otlptracehttp.Option, 0, 2)
+		if len(cfg.Endpoint) > 0 { opts = append (opts, otlptracehttp.WithEndpoint (cfg.Endpoint)) }
+		if cfg.Insecure { opts = append (opts, otlptracehttp.WithInsecure()) }
+
+		exporter, err = otlptracehttp.New (ctx, opts...)
+
+	case Exporter_stdout:
+		exporter, err = stdouttrace.New (stdouttrace.WithWriter (os.Stdout))
+
+	default:
+		return nil, errors.Errorf ("unknown tracing exporter : %s", cfg.Exporter)
+	}
+	if err != nil { return nil, errors.WithStack (err) }
+
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio > 0 && cfg.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased (cfg.SampleRatio)
+	}
+
+	provider := sdktrace.NewTracerProvider (
+		sdktrace.WithBatcher (exporter),
+		sdktrace.WithSampler (sdktrace.ParentBased (sampler)), // follow whatever the caller decided
+		sdktrace.WithResource (resource.NewSchemaless (semconv.ServiceName (serviceName))),
+	)
+
+	otel.SetTracerProvider (provider)
+
+	return func (ctx context.Context) error {
+		return errors.WithStack (provider.Shutdown (ctx))
+	}, nil
+}
+
+// starts a span off whatever is in the context, always call End on the span
+func Start (ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer (tracerName).Start (ctx, name, trace.WithSpanKind (kind), trace.WithAttributes (attrs...))
+}
+
+// marks the span as failed when there's an error, returns the error so it can wrap a return
+func Error (span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError (err)
+		span.SetStatus (codes.Error, err.Error())
+	}
+	return err
+}
+
+// the trace id for the context, empty when there isn't one
+func TraceId (ctx context.Context) string {
+	if ctx == nil { return "" }
+
+	sc := trace.SpanContextFromContext (ctx)
+	if sc.HasTraceID() == false { return "" }
+	return sc.TraceID().String()
+}
+
+// whether the host is one of our own services, so it's ok to pass our trace along to it
+func Internal (host string) bool {
+	return internalHosts[strings.ToLower (host)]
+}
+
+// puts the trace into outbound request headers
+func Inject (ctx context.Context, carrier propagation.TextMapCarrier) {
+	otel.GetTextMapPropagator().Inject (ctx, carrier)
+}
+
+// pulls a trace out of inbound request headers
+func Extract (ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract (ctx, carrier)
+}
@@ -0,0 +1,368 @@
+/** ****************************************************************************************************************** **
+	Plays the part of a happy recipient for our warmup emails
+	Logs into the seed inboxes, pulls our emails out of spam, opens them, clicks the link and writes back
+
+	That's what the example template asks a person to do, the providers watch for all of it when deciding on reputation
+
+** ****************************************************************************************************************** **/
+
+package warmbot
+
+import (
+	"coldbrew/tools/imap"
+	"coldbrew/tools/mailer"
+	"coldbrew/tools/smtp"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- CONSTS ----------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// what we say back when the config doesn't give us anything
+var DefaultReplies = []string {
+	"Thanks, got it!",
+	"Thanks for sending this over.",
+	"Got it, appreciate it.",
+	"Thanks! Talk soon.",
+	"Received, thank you.",
+}
+
+var linkRegex = regexp.MustCompile (`https?://[^\s"'<>]+`)
+
+// everything we send has this header, so it's what we look for
+var searchOurs = fmt.Sprintf (`HEADER %s ""`, mailer.HeaderEmailId)
+
+const (
+	clickTimeout = time.Second * 15 // a link slower than this isn't worth waiting on
+	maxRedirects = 5 // how far we'll look behind a tracking link for where it really goes
+)
+
+// the links come out of emails and anyone can send those, so they don't get to point us at our own network
+var safeClient = &http.Client {
+	Timeout: clickTimeout,
+	Transport: &http.Transport {
+		DialContext: (&net.Dialer { Timeout: clickTimeout, Control: publicOnly }).DialContext,
+		TLSHandshakeTimeout: clickTimeout,
+	},
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- STRUCTS ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// an inbox belonging to one of our warmup users
+type Seed struct {
+	Email, Name string // who the replies come from, this is the warmup user's address
+	Imap imap.Account
+	Smtp smtp.Sender
+	Junk string // the spam folder, we look for it when this is empty
+}
+
+// what we did with a single email
+type Engagement struct {
+	EmailId string // from our header, the id of the emails row
+	Link string // the link we clicked, empty if there wasn't one
+	Clicked, Replied bool
+}
+
+// what happened on a single pass through the seed
+type Result struct {
+	Rescued int // pulled out of spam
+	Ignored int // had our header but wasn't one of our warmups, left alone
+	Engaged []*Engagement
+}
+
+type Bot struct {
+	Replies []string // one of these gets picked for each reply, defaults to DefaultReplies
+
+	// the address a warmup email was sent from, empty when the id isn't one of our warmup emails
+	// anyone can put our header on an email, so this is how we know it's really ours
+	Warmup func (ctx context.Context, emailId uuid.UUID) (string, error)
+
+	Hosts []string // the only hosts we click links to, our api and the providers' click tracking. subdomains count too
+	Client *http.Client // for clicking the links, defaults to one that won't connect to private addresses
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- PRIVATE ---------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// refuses to dial anything that isn't on the public internet, this runs after dns so a name can't sneak us in either
+func publicOnly (network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort (address)
+	if err != nil { return errors.WithStack (err) }
+
+	ip := net.ParseIP (host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || 
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return errors.Errorf ("refusing to connect to a private address : %s", host)
+	}
+	return nil
+}
+
+// the unsubscribe link is off limits, that's the opposite of what we're going for
+func unsubscribe (link string) bool {
+	return strings.Contains (strings.ToLower (link), "unsubscribe")
+}
+
+func (this *Bot) client () *http.Client {
+	if this.Client != nil { return this.Client }
+	return safeClient
+}
+
+// whether the link goes to one of our hosts, or one of their subdomains
+func (this *Bot) allowed (link string) bool {
+	u, err := url.Parse (link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") { return false }
+
+	host := strings.ToLower (u.Hostname())
+	for _, allowed := range this.Hosts {
+		allowed = strings.ToLower (allowed)
+		if host == allowed || strings.HasSuffix (host, "." + allowed) { return true }
+	}
+	return false
+}
+
+// the links in the email we're allowed to click, providers rewrite these to track the click
+func (this *Bot) links (msg *mailer.Received) []string {
+	ret := make([]string, 0, 4)
+	seen := make(map[string]bool)
+
+	for _, body := range []string { msg.Html, msg.Text } {
+		for _, found := range linkRegex.FindAllString (body, -1) {
+			found = strings.TrimRight (html.UnescapeString (found), ".,;:!?)")
+			if seen[found] || unsubscribe (found) || this.allowed (found) == false { continue }
+
+			seen[found] = true
+			ret = append (ret, found)
+		}
+	}
+	return ret
+}
+
+// where the link ends up, without going there. we only walk the redirects through our own hosts, and never into an unsubscribe
+func (this *Bot) resolve (ctx context.Context, link string) (string, error) {
+	client := *this.client()
+	client.CheckRedirect = func (*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	for i := 0; i < maxRedirects && this.allowed (link) && unsubscribe (link) == false; i++ {
+		req, err := http.NewRequestWithContext (ctx, http.MethodHead, link, nil)
+		if err != nil { return "", errors.Wrapf (err, "resolve : %s", link) }
+
+		resp, err := client.Do (req)
+		if err != nil { return "", errors.Wrapf (err, "resolve : %s", link) }
+		resp.Body.Close()
+
+		next, err := resp.Location()
+		if err != nil { return link, nil } // not a redirect, this is where it goes
+
+		link = next.String()
+	}
+
+	return link, nil
+}
+
+// the link we want to click. with the provider's tracking on, every link looks the same
+// so we look behind each one first, clicking through to our own unsubscribe page is the last thing we want
+func (this *Bot) link (ctx context.Context, msg *mailer.Received) (string, error) {
+	for _, found := range this.links (msg) {
+		target, err := this.resolve (ctx, found)
+		if err != nil { return "", err }
+		if unsubscribe (target) { continue }
+
+		return found, nil
+	}
+	return "", nil
+}
+
+// follows the link like a browser would, we don't care what comes back
+// anything that redirects off of our hosts stops there, the tracking has already counted the click by then
+func (this *Bot) click (ctx context.Context, link string) error {
+	req, err := http.NewRequestWithContext (ctx, http.MethodGet, link, nil)
+	if err != nil { return errors.Wrapf (err, "click : %s", link) }
+	req.Header.Set ("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36")
+
+	client := *this.client()
+	client.CheckRedirect = func (req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects || this.allowed (req.URL.String()) == false || unsubscribe (req.URL.String()) { 
+			return http.ErrUseLastResponse 
+		}
+		return nil
+	}
+
+	resp, err := client.Do (req)
+	if err != nil { return errors.Wrapf (err, "click : %s", link) }
+	defer resp.Body.Close()
+
+	io.Copy (io.Discard, io.LimitReader (resp.Body, 1 << 20))
+
+	if resp.StatusCode >= 400 { return errors.Errorf ("click : %s : %d", link, resp.StatusCode) }
+	return nil
+}
+
+// whether this is really one of our warmup emails, it has to be from the address we sent it from
+func (this *Bot) ours (ctx context.Context, c *imap.Client, uid uint32) (bool, error) {
+	raw, err := c.FetchHeader (uid)
+	if err != nil { return false, err }
+
+	msg, err := mailer.Parse (raw)
+	if err != nil { return false, nil } // nothing we sent
+
+	emailId, err := uuid.Parse (strings.TrimSpace (msg.Header.Get (mailer.HeaderEmailId)))
+	if err != nil { return false, nil }
+
+	from, err := this.Warmup (ctx, emailId)
+	if err != nil || len(from) == 0 { return false, err }
+
+	return msg.From != nil && strings.EqualFold (msg.From.Address, from), nil
+}
+
+// writes back to whoever sent it, threaded onto the original
+func (this *Bot) reply (ctx context.Context, seed *Seed, msg *mailer.Received) error {
+	to := msg.ReplyAddress()
+	if to == nil { return errors.Errorf ("no one to reply to : %s", msg.MessageId) }
+
+	replies := this.Replies
+	if len(replies) == 0 { replies = DefaultReplies }
+
+	subject := msg.Subject
+	if strings.HasPrefix (strings.ToLower (subject), "re:") == false { subject = "Re: " + subject }
+
+	reply := &mailer.Message {
+		To: to.Address,
+		FromEmail: seed.Email,
+		FromName: seed.Name,
+		Subject: subject,
+		Text: replies[rand.Intn (len(replies))],
+		Headers: make(map[string]string),
+	}
+
+	if len(msg.MessageId) > 0 {
+		refs := make([]string, 0, len(msg.References) + 1)
+		for _, ref := range msg.References {
+			refs = append (refs, "<" + ref + ">")
+		}
+		refs = append (refs, "<" + msg.MessageId + ">")
+
+		reply.Headers["In-Reply-To"] = "<" + msg.MessageId + ">"
+		reply.Headers["References"] = strings.Join (refs, " ")
+	}
+
+	_, err := seed.Smtp.Send (ctx, reply)
+	return err
+}
+
+// does everything for one email in the inbox
+func (this *Bot) engage (ctx context.Context, seed *Seed, c *imap.Client, uid uint32) (*Engagement, error) {
+	raw, err := c.Fetch (uid)
+	if err != nil { return nil, err }
+
+	msg, err := mailer.Parse (raw)
+	if err != nil { return nil, err }
+
+	ret := &Engagement { EmailId: msg.Header.Get (mailer.HeaderEmailId) }
+
+	// opening it
+	if err := c.AddFlags ([]uint32 { uid }, imap.FlagSeen); err != nil { return nil, err }
+
+	// a link that doesn't work shouldn't stop us from replying
+	ret.Link, err = this.link (ctx, msg)
+	if err != nil {
+		slog.Warn ("warmup link failed", slog.String("seed", seed.Email), slog.String("email", ret.EmailId), slog.String("error", err.Error()))
+	}
+
+	if len(ret.Link) > 0 {
+		if err := this.click (ctx, ret.Link); err != nil {
+			slog.Warn ("warmup click failed", slog.String("seed", seed.Email), slog.String("email", ret.EmailId), slog.String("error", err.Error()))
+		} else {
+			ret.Clicked = true
+		}
+	}
+
+	if err := this.reply (ctx, seed, msg); err != nil { return ret, err }
+	ret.Replied = true
+
+	// answered is how we know we're done with it, so this goes last
+	return ret, c.AddFlags ([]uint32 { uid }, imap.FlagAnswered)
+}
+
+  //-----------------------------------------------------------------------------------------------------------------------//
+ //----- FUNCTIONS -------------------------------------------------------------------------------------------------------//
+//-----------------------------------------------------------------------------------------------------------------------//
+
+// a single pass through the seed's inbox, anything we've already replied to is left alone
+// an email that fails gets tried again on the next pass
+func (this *Bot) Run (ctx context.Context, seed *Seed) (*Result, error) {
+	if this.Warmup == nil { return nil, errors.New ("warmbot needs Warmup to tell which emails are ours") }
+
+	c, err := seed.Imap.Dial (ctx)
+	if err != nil { return nil, err }
+	defer c.Close()
+
+	ret := &Result{}
+
+	junk := seed.Junk
+	if len(junk) == 0 {
+		junk, err = c.Junk()
+		if err != nil { return nil, err }
+	}
+
+	// anything of ours in spam goes back to the inbox
+	if len(junk) > 0 {
+		if err := c.Select (junk); err != nil { return nil, err }
+
+		uids, err := c.Search (searchOurs)
+		if err != nil { return nil, err }
+
+		// rescuing something pretending to be us would only teach the provider that spam is fine
+		rescue := make([]uint32, 0, len(uids))
+		for _, uid := range uids {
+			ours, err := this.ours (ctx, c, uid)
+			if err != nil { return nil, err }
+			if ours { rescue = append (rescue, uid) }
+		}
+
+		if err := c.Move (rescue, imap.Inbox); err != nil { return nil, err }
+		ret.Rescued = len(rescue)
+	}
+
+	if err := c.Select (imap.Inbox); err != nil { return nil, err }
+
+	uids, err := c.Search ("UNANSWERED " + searchOurs)
+	if err != nil { return nil, err }
+
+	for _, uid := range uids {
+		if ctx.Err() != nil { break } // out of time, the rest can wait for the next pass
+
+		// left where it is, so someone can take a look
+		ours, err := this.ours (ctx, c, uid)
+		if err != nil { return ret, errors.Wrapf (err, "seed : %s : %d", seed.Email, uid) }
+		if ours == false {
+			ret.Ignored++
+			continue
+		}
+
+		engagement, err := this.engage (ctx, seed, c, uid)
+		if engagement != nil { ret.Engaged = append (ret.Engaged, engagement) }
+		if err != nil { return ret, errors.Wrapf (err, "seed : %s : %d", seed.Email, uid) }
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,234 @@
+package warmbot
+
+import (
+	"coldbrew/tools"
+	"coldbrew/tools/imap"
+	"coldbrew/tools/imap/imaptest"
+	"coldbrew/tools/mailer"
+	"coldbrew/tools/smtp"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// takes every message it's given and keeps the data
+type testSmtp struct {
+	listener net.Listener
+	lock sync.Mutex
+	data []string
+}
+
+func newTestSmtp (t *testing.T) *testSmtp {
+	l, err := net.Listen ("tcp", "127.0.0.1:0")
+	tools.TestingStackTrace (t, err)
+
+	this := &testSmtp { listener: l }
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil { return }
+			go this.serve (conn)
+		}
+	}()
+	return this
+}
+
+func (this *testSmtp) serve (conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn (conn)
+	tp.PrintfLine ("220 localhost ESMTP test")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil { return }
+
+		switch strings.ToUpper (strings.SplitN (line, " ", 2)[0]) {
+		case "EHLO":
+			tp.PrintfLine ("250 localhost")
+		case "DATA":
+			tp.PrintfLine ("354 go ahead")
+			lines, _ := tp.ReadDotLines()
+			this.lock.Lock()
+			this.data = append (this.data, strings.Join (lines, "\r\n"))
+			this.lock.Unlock()
+			tp.PrintfLine ("250 queued")
+		case "QUIT":
+			tp.PrintfLine ("221 bye")
+			return
+		default:
+			tp.PrintfLine ("250 ok")
+		}
+	}
+}
+
+// what one of our warmup emails looks like by the time it gets to the seed
+func testEmail (t *testing.T, emailId, link string) []byte {
+	return testEmailFrom (t, "mailman@example.com", emailId, `<p>Please <a href="` + link + `">click</a></p>`, link)
+}
+
+func testEmailFrom (t *testing.T, from, emailId, body, link string) []byte {
+	msg := &mailer.Message {
+		To: "seed@example.com",
+		FromEmail: from,
+		FromName: "Mailman",
+		ReplyEmail: "replies@example.com",
+		Subject: "Warming up",
+		Text: "Please click " + link + " and reply.\nUnsubscribe http://example.com/unsubscribe/abc",
+		Html: body + `<a href="http://example.com/unsubscribe/abc">unsubscribe</a>`,
+		Headers: map[string]string { mailer.HeaderEmailId: emailId },
+	}
+
+	raw, err := msg.MIME ("original@example.com")
+	tools.TestingStackTrace (t, err)
+	return raw
+}
+
+func TestQAWarmbotRun (t *testing.T) {
+	server, err := imaptest.NewServer ("seed@example.com", "secret")
+	tools.TestingStackTrace (t, err)
+	defer server.Close()
+
+	mta := newTestSmtp (t)
+	defer mta.listener.Close()
+
+	clicks := make(chan string, 10)
+	site := httptest.NewServer (http.HandlerFunc (func (w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet { clicks <- r.URL.RequestURI() }
+	}))
+	defer site.Close()
+
+	email1, email2, spoofed := uuid.New(), uuid.New(), uuid.New()
+
+	server.Append (imap.Inbox, testEmail (t, email1.String(), site.URL + "/click?a=1&amp;b=2"))
+	server.Append (imaptest.Junk, testEmail (t, email2.String(), site.URL + "/click?a=2"))
+	server.Append (imap.Inbox, []byte("From: <friend@example.com>\r\nSubject: not ours\r\n\r\nhi\r\n"))
+
+	// our header on someone else's email, or on an email we never sent, doesn't get them anything
+	server.Append (imap.Inbox, testEmailFrom (t, "spammer@example.com", email1.String(), "", site.URL + "/spam"))
+	server.Append (imaptest.Junk, testEmail (t, spoofed.String(), site.URL + "/spam"))
+	server.Append (imap.Inbox, testEmail (t, "not-a-uuid", site.URL + "/spam"))
+
+	seed := &Seed {
+		Email: "seed@example.com",
+		Imap: imap.Account { Host: "127.0.0.1", Port: server.Port(), Username: "seed@example.com", Password: "secret", TLSConfig: server.TLSConfig() },
+		Smtp: smtp.Sender { Host: "127.0.0.1", Port: mta.listener.Addr().(*net.TCPAddr).Port },
+	}
+	bot := &Bot { 
+		Replies: []string { "thanks!" },
+		Warmup: func (ctx context.Context, emailId uuid.UUID) (string, error) {
+			if emailId == email1 || emailId == email2 { return "Mailman@example.com", nil }
+			return "", nil
+		},
+		Hosts: []string { "127.0.0.1" },
+		Client: site.Client(), // the default won't talk to localhost
+	}
+
+	ctx, cancel := context.WithTimeout (context.Background(), time.Second * 10)
+	defer cancel()
+
+	result, err := bot.Run (ctx, seed)
+	tools.TestingStackTrace (t, err)
+
+	assert.Equal (t, 1, result.Rescued)
+	assert.Equal (t, 2, result.Ignored)
+	assert.Len (t, server.Messages (imaptest.Junk), 1, "the spoofed one stays in spam")
+	if assert.Len (t, result.Engaged, 2) {
+		assert.Equal (t, email1.String(), result.Engaged[0].EmailId)
+		assert.True (t, result.Engaged[0].Clicked)
+		assert.True (t, result.Engaged[0].Replied)
+		assert.Equal (t, email2.String(), result.Engaged[1].EmailId)
+	}
+
+	assert.Equal (t, "/click?a=1&b=2", <-clicks, "html entities are undone before clicking")
+	assert.Equal (t, "/click?a=2", <-clicks)
+	assert.Len (t, clicks, 0, "none of the spoofed links were clicked")
+
+	// both of ours are read and answered, the others weren't touched
+	for _, msg := range server.Messages (imap.Inbox) {
+		ours := strings.Contains (string(msg.Raw), mailer.HeaderEmailId) && strings.Contains (string(msg.Raw), "mailman@example.com") &&
+				strings.Contains (string(msg.Raw), "/spam") == false
+		assert.Equal (t, ours, msg.HasFlag (imap.FlagSeen))
+		assert.Equal (t, ours, msg.HasFlag (imap.FlagAnswered))
+	}
+
+	// the replies go to the reply-to and thread onto the original
+	if assert.Len (t, mta.data, 2) {
+		reply, err := mailer.Parse ([]byte(mta.data[0]))
+		tools.TestingStackTrace (t, err)
+
+		to, err := reply.Header.AddressList ("To")
+		tools.TestingStackTrace (t, err)
+		assert.Equal (t, "replies@example.com", to[0].Address)
+		assert.Equal (t, "Re: Warming up", reply.Subject)
+		assert.Equal (t, "original@example.com", reply.InReplyTo)
+		assert.Equal (t, "thanks!", reply.Text)
+	}
+
+	// a second pass has nothing left to do
+	result, err = bot.Run (ctx, seed)
+	tools.TestingStackTrace (t, err)
+	assert.Equal (t, 0, result.Rescued)
+	assert.Len (t, result.Engaged, 0)
+}
+
+func TestQAWarmbotLink (t *testing.T) {
+	requests := make(chan string, 20)
+	site := httptest.NewServer (http.HandlerFunc (func (w http.ResponseWriter, r *http.Request) {
+		requests <- r.Method + " " + r.URL.Path
+		switch r.URL.Path {
+		case "/track/1": http.Redirect (w, r, "/unsubscribe/abc", http.StatusFound) // the tracking hides where it goes
+		case "/track/2": http.Redirect (w, r, "https://elsewhere.example.com/page", http.StatusFound)
+		}
+	}))
+	defer site.Close()
+
+	bot := &Bot { Hosts: []string { "127.0.0.1" }, Client: site.Client() }
+
+	msg, err := mailer.Parse (testEmailFrom (t, "mailman@example.com", uuid.New().String(), 
+								`<a href="https://tracker.example.com/x">not ours</a><a href="` + site.URL + `/track/1">a</a><a href="` + site.URL + `/track/2">b</a>`, ""))
+	tools.TestingStackTrace (t, err)
+
+	ctx, cancel := context.WithTimeout (context.Background(), time.Second * 10)
+	defer cancel()
+
+	link, err := bot.link (ctx, msg)
+	tools.TestingStackTrace (t, err)
+	assert.Equal (t, site.URL + "/track/2", link)
+
+	// clicking stops at the tracking, we don't go off to wherever it points
+	tools.TestingStackTrace (t, bot.click (ctx, link))
+
+	close (requests)
+	seen := make([]string, 0)
+	for req := range requests { seen = append (seen, req) }
+	assert.Equal (t, []string { "HEAD /track/1", "HEAD /track/2", "GET /track/2" }, seen)
+}
+
+func TestQAWarmbotPrivate (t *testing.T) {
+	site := httptest.NewServer (http.HandlerFunc (func (w http.ResponseWriter, r *http.Request) {}))
+	defer site.Close()
+
+	ctx, cancel := context.WithTimeout (context.Background(), time.Second * 10)
+	defer cancel()
+
+	// the default client is what runs in production, it won't touch our own network
+	err := (&Bot { Hosts: []string { "127.0.0.1" } }).click (ctx, site.URL)
+	if assert.Error (t, err) {
+		assert.Contains (t, err.Error(), "private address")
+	}
+
+	// and without Warmup we can't tell what's ours
+	_, err = (&Bot{}).Run (ctx, &Seed{})
+	assert.Error (t, err)
+}